@@ -3,15 +3,57 @@ package client
 type Bitfield []byte
 
 // HasPiece queries a bitfield if it has a index
+// Indexes out of the bitfield are never present
 func (b Bitfield) HasPiece(index int) bool {
 	byteIndex := index / 8
 	offset := index % 8
+	if index < 0 || byteIndex >= len(b) {
+		return false
+	}
 	return b[byteIndex]>>(7-offset)&1 != 0
 }
 
 // SetPiece set a bit in a bitfield
+// Indexes out of the bitfield are ignored
 func (b Bitfield) SetPiece(index int) {
 	byteIndex := index / 8
 	offset := index % 8
+	if index < 0 || byteIndex >= len(b) {
+		return
+	}
 	b[byteIndex] |= 1 << (7 - offset)
 }
+
+// NewBitfield creates a empty bitfield for a number of pieces
+func NewBitfield(nPieces int) Bitfield {
+	return make(Bitfield, (nPieces+7)/8)
+}
+
+// NewFullBitfield creates a bitfield with all the pieces
+func NewFullBitfield(nPieces int) Bitfield {
+	bf := NewBitfield(nPieces)
+	for index := 0; index < nPieces; index++ {
+		bf.SetPiece(index)
+	}
+	return bf
+}
+
+// HasAll returns if all the pieces are on the bitfield
+func (b Bitfield) HasAll(nPieces int) bool {
+	for index := 0; index < nPieces; index++ {
+		if !b.HasPiece(index) {
+			return false
+		}
+	}
+	return true
+}
+
+// Empty returns if the bitfield has no pieces
+func (b Bitfield) Empty() bool {
+	for _, value := range b {
+		if value != 0 {
+			return false
+		}
+	}
+	return true
+}
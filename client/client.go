@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"fmt"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jhelison/go-torrent/marshallers/extension"
 	"github.com/jhelison/go-torrent/marshallers/handshake"
 	"github.com/jhelison/go-torrent/marshallers/message"
 	"github.com/jhelison/go-torrent/marshallers/peer"
@@ -14,59 +17,149 @@ import (
 )
 
 type Client struct {
-	Conn     net.Conn
-	Choked   bool
-	Bitfield Bitfield
-	peer     peer.Peer
-	banned   bool
-	retries  int
-	infoHash handshake.Hash
-	peerID   handshake.PeerID
+	Conn           net.Conn
+	Choked         bool
+	Bitfield       Bitfield
+	PeerInterested bool
+	Uploaded       atomic.Int64
+	peer           peer.Peer
+	banned         bool
+	retries        int
+	infoHash       handshake.Hash
+	peerID         handshake.PeerID
+	uploader       *uploader
+	uploads        *uploadQueue
+	extensions     bool
+	fast           bool
+	inbound        bool
+	allowedFast    map[int]bool
+	// picker counts the pieces of the peer while downloading
+	picker *piecePicker
+	// messages are read from the connection by the reader while downloading
+	messages   chan message.Message
+	consumed   chan struct{}
+	stopReader chan struct{}
+	readErr    error
+
+	extensionsMu     sync.Mutex
+	localExtensions  []Extension
+	remoteExtensions map[string]extension.ExtendedID
+	remoteHandshake  *extension.Handshake
 }
 
 // NewClient returns a new client
-// This also executes the handshake
+// This also executes the handshake and receives the peer pieces
 func NewClient(
 	peer peer.Peer,
 	peerID handshake.PeerID,
 	infoHash handshake.Hash,
+	nPieces int,
 ) (*Client, error) {
-	// Viper config
-	timeout := viper.GetDuration("peers.timeout")
-
-	// Do the tcp dial
-	conn, err := net.DialTimeout("tcp", peer.String(), timeout)
+	// Dial over uTP or TCP, encrypted if enabled
+	conn, err := dialPeer(peer, infoHash)
 	if err != nil {
 		return nil, err
 	}
 
 	// Complete the handshake with the peer
-	_, err = completeHandshake(conn, peerID, infoHash)
+	res, err := completeHandshake(conn, newLocalHandshake(peerID, infoHash))
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
+	client := newClient(conn, peer, peerID, infoHash, nil)
+	client.setReserved(res)
+
 	// Receives the bitfield
-	bf, err := recieveBitfield(conn)
+	err = client.recieveBitfield(nPieces)
 	if err != nil {
 		conn.Close()
 		return nil, err
 	}
 
+	return client, nil
+}
+
+// NewSeedClient returns a new client used only to upload
+// This also executes the handshake, but the bitfield is optional
+// since peers without any piece may not send it
+func NewSeedClient(
+	peer peer.Peer,
+	peerID handshake.PeerID,
+	infoHash handshake.Hash,
+	nPieces int,
+) (*Client, error) {
+	// Dial over uTP or TCP, encrypted if enabled
+	conn, err := dialPeer(peer, infoHash)
+	if err != nil {
+		return nil, err
+	}
+
+	// Complete the handshake with the peer
+	res, err := completeHandshake(conn, newLocalHandshake(peerID, infoHash))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	client := newClient(conn, peer, peerID, infoHash, NewBitfield(nPieces))
+	client.setReserved(res)
+	return client, nil
+}
+
+// newClient builds a client for a connection that completed the handshake
+func newClient(
+	conn net.Conn,
+	peer peer.Peer,
+	peerID handshake.PeerID,
+	infoHash handshake.Hash,
+	bf Bitfield,
+) *Client {
 	return &Client{
-		Conn:     conn,
-		Choked:   true,
-		banned:   false,
-		Bitfield: bf,
-		peer:     peer,
-		infoHash: infoHash,
-		peerID:   peerID,
-	}, nil
+		Conn:        conn,
+		Choked:      true,
+		banned:      false,
+		Bitfield:    bf,
+		peer:        peer,
+		infoHash:    infoHash,
+		peerID:      peerID,
+		allowedFast: map[int]bool{},
+	}
+}
+
+// setReserved enables the extensions supported by both sides
+func (c *Client) setReserved(remote *handshake.Handshake) {
+	c.extensions = remote.SupportsExtensions()
+	c.fast = remote.HasReserved(handshake.FastBit)
+}
+
+// newLocalHandshake returns our handshake flagging the supported extensions
+func newLocalHandshake(peerID handshake.PeerID, infoHash handshake.Hash) *handshake.Handshake {
+	h := handshake.NewHandshake(peerID, infoHash)
+	h.EnableExtensions()
+	h.SetReserved(handshake.FastBit)
+	return h
+}
+
+// newInboundClient builds a client for a incoming connection
+// The peer port is the connection port until the peer tells us its listen port
+func newInboundClient(
+	conn net.Conn,
+	remote *handshake.Handshake,
+	peerID handshake.PeerID,
+	infoHash handshake.Hash,
+	bf Bitfield,
+) *Client {
+	client := newClient(conn, peerFromAddr(conn.RemoteAddr()), peerID, infoHash, bf)
+	client.setReserved(remote)
+	client.inbound = true
+	return client
 }
 
 // completeHandshake does a handshake with a peer
-func completeHandshake(conn net.Conn, peerID handshake.PeerID, infoHash handshake.Hash) (*handshake.Handshake, error) {
+// The response must have the same info hash as the request
+func completeHandshake(conn net.Conn, req *handshake.Handshake) (*handshake.Handshake, error) {
 	// Viper config
 	timeout := viper.GetDuration("peers.timeout")
 
@@ -78,9 +171,8 @@ func completeHandshake(conn net.Conn, peerID handshake.PeerID, infoHash handshak
 	// We can ignore the error
 	defer conn.SetDeadline(time.Time{}) //nolint:errcheck
 
-	// Do the handshakeRes
-	handshakeRes := handshake.NewHandshake(peerID, infoHash)
-	_, err = conn.Write(handshakeRes.Marshal())
+	// Do the handshake
+	_, err = conn.Write(req.Marshal())
 	if err != nil {
 		return nil, err
 	}
@@ -90,35 +182,83 @@ func completeHandshake(conn net.Conn, peerID handshake.PeerID, infoHash handshak
 	if err != nil {
 		return nil, err
 	}
-	if !bytes.Equal(infoHash[:], res.InfoHash[:]) {
-		return nil, fmt.Errorf("expected info hash %s but got %s", infoHash, res.InfoHash)
+	if !bytes.Equal(req.InfoHash[:], res.InfoHash[:]) {
+		return nil, fmt.Errorf("expected info hash %s but got %s", req.InfoHash, res.InfoHash)
 	}
 
 	return res, nil
 }
 
-// recieveBitfield receives a bitfield from a peer
-func recieveBitfield(conn net.Conn) (Bitfield, error) {
+// recieveBitfield receives the bitfield from the peer
+// Extended messages may be sent before it and are handled on the way
+// With the fast extension the bitfield can be replaced by a have all or have none
+func (c *Client) recieveBitfield(nPieces int) error {
 	// Viper config
 	timeout := viper.GetDuration("peers.timeout")
 
-	err := conn.SetDeadline(time.Now().Add(timeout))
+	err := c.Conn.SetDeadline(time.Now().Add(timeout))
 	if err != nil {
-		return nil, err
+		return err
 	}
 	// We can ignore the error for this line
-	defer conn.SetDeadline(time.Time{}) //nolint:errcheck
+	defer c.Conn.SetDeadline(time.Time{}) //nolint:errcheck
 
-	// Validates if we have received a response and it's a bitfield msg
-	msg, err := message.Unmarshal(conn)
-	if err != nil {
-		return nil, err
+	for {
+		msg, err := c.Read()
+		if err != nil {
+			return err
+		}
+
+		// Validates if we have received a bitfield msg
+		switch msg.ID {
+		case message.MsgExtended:
+			err := c.handleExtendedMessage(msg)
+			if err != nil {
+				return err
+			}
+		case message.MsgBitfield:
+			c.Bitfield = msg.Payload
+			return nil
+		case message.MsgHaveAll, message.MsgHaveNone:
+			if !c.fast {
+				return fmt.Errorf("unexpected fast message %v without the fast extension", msg.ID)
+			}
+			c.setHaves(msg.ID, nPieces)
+			return nil
+		case message.MsgAllowedFast:
+			// Allowed fast pieces may come before the pieces
+			err := c.handleFastMessage(msg)
+			if err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("expected bitfield but got %v", msg)
+		}
+	}
+}
+
+// addPiece sets a piece from a have message on the bitfield
+// New pieces are counted on the picker
+func (c *Client) addPiece(index int) {
+	if c.Bitfield.HasPiece(index) {
+		return
 	}
-	if msg.ID != message.MsgBitfield {
-		return nil, fmt.Errorf("expected bitfield but got %v", msg)
+	c.Bitfield.SetPiece(index)
+	if c.picker != nil && c.Bitfield.HasPiece(index) {
+		c.picker.have(index)
 	}
+}
 
-	return msg.Payload, nil
+// setBitfield replaces the pieces of the peer
+// The pieces are counted again on the picker
+func (c *Client) setBitfield(bf Bitfield) {
+	if c.picker != nil {
+		c.picker.removePeer(c.Bitfield)
+	}
+	c.Bitfield = bf
+	if c.picker != nil {
+		c.picker.addPeer(c.Bitfield)
+	}
 }
 
 // Read reads the message from the client
@@ -127,6 +267,106 @@ func (c *Client) Read() (message.Message, error) {
 	return msg, err
 }
 
+// startReader reads the messages of the peer on its own goroutine
+// so they are handled even when nothing is downloaded from the peer
+// Piece messages are streamed from the connection,
+// the next message is only read after they're released
+func (c *Client) startReader() {
+	c.messages = make(chan message.Message)
+	c.consumed = make(chan struct{}, 1)
+	c.stopReader = make(chan struct{})
+
+	go func() {
+		defer close(c.messages)
+		for {
+			msg, err := c.Read()
+			if err != nil {
+				c.readErr = err
+				return
+			}
+			// Keep alives only keep the connection open
+			if msg.KeepAlive {
+				continue
+			}
+
+			select {
+			case c.messages <- msg:
+			case <-c.stopReader:
+				c.readErr = net.ErrClosed
+				return
+			}
+			if msg.ID != message.MsgPiece {
+				continue
+			}
+			select {
+			case <-c.consumed:
+			case <-c.stopReader:
+				c.readErr = net.ErrClosed
+				return
+			}
+		}
+	}()
+}
+
+// release lets the reader continue after a message is handled
+func (c *Client) release(msg message.Message) {
+	if msg.ID == message.MsgPiece {
+		c.consumed <- struct{}{}
+	}
+}
+
+// handleMessage handles the messages that don't depend on the piece being downloaded
+// Blocks that arrive without a piece waiting for them are discarded
+func (c *Client) handleMessage(msg message.Message) error {
+	switch msg.ID {
+	case message.MsgUnchoke:
+		c.Choked = false
+	case message.MsgChoke:
+		c.Choked = true
+	case message.MsgHave:
+		// If the message is have we parse it and update the bitfield with the index
+		index, err := message.ParseHave(msg)
+		if err != nil {
+			return err
+		}
+		c.addPiece(index)
+	case message.MsgBitfield:
+		c.setBitfield(msg.Payload)
+	case message.MsgPiece:
+		return message.DiscardPiece(msg)
+	case message.MsgExtended:
+		return c.handleExtendedMessage(msg)
+	case message.MsgHaveAll, message.MsgHaveNone:
+		if !c.fast {
+			return fmt.Errorf("unexpected fast message %v without the fast extension", msg.ID)
+		}
+		c.setHaves(msg.ID, len(c.Bitfield)*8)
+	case message.MsgRejectRequest:
+		// Only the rejects for canceled requests get here
+		if !c.fast {
+			return fmt.Errorf("unexpected fast message %v without the fast extension", msg.ID)
+		}
+	case message.MsgSuggest, message.MsgAllowedFast:
+		return c.handleFastMessage(msg)
+	default:
+		// Requests from the peer are handled by the uploads
+		return c.handleUploadMessage(msg)
+	}
+	return nil
+}
+
+// Close closes the connection and stops the uploads and the extensions
+func (c *Client) Close() error {
+	if c.uploads != nil {
+		c.uploads.close()
+	}
+	if c.stopReader != nil {
+		close(c.stopReader)
+	}
+	c.closeExtensions()
+	return c.Conn.Close()
+}
+
 // SendRequest sends a new request with the expected index, begin and length
 func (c *Client) SendRequest(index, begin, length int) error {
 	msg := message.NewRequestMessage(index, begin, length)
@@ -134,6 +374,13 @@ func (c *Client) SendRequest(index, begin, length int) error {
 	return err
 }
 
+// SendCancel cancels a request sent before
+func (c *Client) SendCancel(index, begin, length int) error {
+	msg := message.NewCancelMessage(index, begin, length)
+	_, err := c.Conn.Write(msg.Serialize())
+	return err
+}
+
 // SendHave send a new have message with a index
 func (c *Client) SendHave(index int) error {
 	msg := message.NewHaveMessage(index)
@@ -161,3 +408,36 @@ func (c *Client) SendUnchoke() error {
 	_, err := c.Conn.Write(msg.Serialize())
 	return err
 }
+
+// SendBitfield sends our bitfield
+func (c *Client) SendBitfield(bf Bitfield) error {
+	msg := message.NewMessage(message.MsgBitfield, bf)
+	_, err := c.Conn.Write(msg.Serialize())
+	return err
+}
+
+// SendPieces sends the pieces we have
+// With the fast extension have all and have none replace the bitfield
+// Otherwise a empty bitfield isn't sent
+func (c *Client) SendPieces(bf Bitfield, nPieces int) error {
+	var msg message.Message
+	switch {
+	case c.fast && bf.HasAll(nPieces):
+		msg = message.NewMessage(message.MsgHaveAll, nil)
+	case c.fast && bf.Empty():
+		msg = message.NewMessage(message.MsgHaveNone, nil)
+	case bf.Empty():
+		return nil
+	default:
+		msg = message.NewMessage(message.MsgBitfield, bf)
+	}
+	_, err := c.Conn.Write(msg.Serialize())
+	return err
+}
+
+// SendReject sends a new reject request message
+func (c *Client) SendReject(index, begin, length int) error {
+	msg := message.NewRejectMessage(index, begin, length)
+	_, err := c.Conn.Write(msg.Serialize())
+	return err
+}
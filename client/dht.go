@@ -0,0 +1,148 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/dht"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// The DHT node shared between all the torrents
+	defaultDHT     *dht.Server
+	defaultDHTErr  error
+	defaultDHTOnce sync.Once
+)
+
+// DefaultDHT returns the DHT node shared between all the torrents
+// It's bootstrapped from the node cache and the bootstrap nodes on the first call
+func DefaultDHT() (*dht.Server, error) {
+	defaultDHTOnce.Do(func() {
+		// Viper configs
+		enabled := viper.GetBool("dht.enabled")
+		host := viper.GetString("peers.listen_host")
+		port := viper.GetInt("dht.listen_port")
+		peersPort := viper.GetInt("peers.listen_port")
+		nodesPath := viper.GetString("dht.nodes_path")
+		bootstrapNodes := viper.GetStringSlice("dht.bootstrap_nodes")
+
+		if !enabled {
+			defaultDHTErr = errors.New("DHT is disabled")
+			return
+		}
+
+		// uTP already holds the peers port, so the DHT shares its socket
+		listener, err := DefaultListener()
+		if port == peersPort && err == nil && listener.utp != nil {
+			defaultDHT, defaultDHTErr = dht.NewServer(listener.utp.PacketConn())
+		} else {
+			defaultDHT, defaultDHTErr = dht.Listen(net.JoinHostPort(host, strconv.Itoa(port)))
+		}
+		if defaultDHTErr != nil {
+			return
+		}
+
+		// The cached nodes are tried before the bootstrap nodes
+		cached, err := dht.LoadNodes(nodesPath)
+		if err != nil {
+			log.Debug().Msgf("no DHT node cache loaded, err: %s", err)
+		}
+		err = defaultDHT.Bootstrap(append(cached, bootstrapNodes...))
+		if err != nil {
+			log.Warn().Msgf("failed to bootstrap the DHT, err: %s", err)
+		}
+	})
+	return defaultDHT, defaultDHTErr
+}
+
+// saveDHTNodes saves the DHT routing table into the node cache
+func saveDHTNodes(server *dht.Server) {
+	// Viper config
+	nodesPath := viper.GetString("dht.nodes_path")
+
+	err := server.SaveNodes(nodesPath)
+	if err != nil {
+		log.Warn().Msgf("failed to save the DHT nodes, err: %s", err)
+	}
+}
+
+// lookupDHT finds the peers for a info hash on the DHT
+// Errors are logged and no peers are returned
+func lookupDHT(infoHash handshake.Hash) []peer.Peer {
+	server, err := DefaultDHT()
+	if err != nil {
+		log.Debug().Msgf("not using the DHT, err: %s", err)
+		return nil
+	}
+
+	peers, err := server.GetPeers(infoHash)
+	if err != nil {
+		log.Warn().Msgf("failed to find peers on the DHT, err: %s", err)
+		return nil
+	}
+	saveDHTNodes(server)
+
+	log.Info().Msgf("Found %d peers on the DHT", len(peers))
+	return peers
+}
+
+// announceDHT announces the torrent on the DHT until the stop channel is closed
+// The peers found on each announce are sent to the returned channel
+// Private torrents never use the DHT
+func (t *Torrent) announceDHT(port uint16, stop <-chan struct{}) <-chan []peer.Peer {
+	if t.Private {
+		return nil
+	}
+
+	// Viper config
+	interval := viper.GetDuration("dht.announce_interval")
+
+	peers := make(chan []peer.Peer)
+	go func() {
+		server, err := DefaultDHT()
+		if err != nil {
+			log.Debug().Msgf("not using the DHT, err: %s", err)
+			return
+		}
+
+		// The nodes from trackerless torrents join the routing table
+		if len(t.Nodes) > 0 {
+			err = server.Bootstrap(t.Nodes)
+			if err != nil {
+				log.Debug().Msgf("failed to bootstrap from the torrent nodes, err: %s", err)
+			}
+		}
+
+		for {
+			found, err := server.Announce(t.InfoHash, port)
+			if err != nil {
+				log.Warn().Msgf("failed to announce on the DHT, err: %s", err)
+			} else {
+				saveDHTNodes(server)
+				log.Info().Msgf("Found %d peers on the DHT", len(found))
+
+				select {
+				case peers <- found:
+				case <-stop:
+					return
+				}
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return peers
+}
@@ -0,0 +1,136 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+	"github.com/jhelison/go-torrent/mse"
+
+	"github.com/spf13/viper"
+)
+
+// plaintextHeader is the start of every plaintext handshake
+var plaintextHeader = []byte("\x13BitTorrent protocol")
+
+// encryptionPolicy returns the configured encryption policy
+// Invalid policies fall back to prefer
+func encryptionPolicy() mse.Policy {
+	// Viper config
+	name := viper.GetString("peers.encryption")
+
+	policy, err := mse.ParsePolicy(name)
+	if err != nil {
+		log.Warn().Msgf("using the %s encryption policy, err: %s", mse.PolicyPrefer, err)
+		return mse.PolicyPrefer
+	}
+	return policy
+}
+
+// dialFunc opens a connection over a transport
+type dialFunc func(address string, timeout time.Duration) (net.Conn, error)
+
+// dialTCP opens a TCP connection
+func dialTCP(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", address, timeout)
+}
+
+// dialPeer opens a connection to a peer following the encryption policy
+// uTP is tried first, peers that don't answer it are dialed over TCP
+func dialPeer(p peer.Peer, infoHash handshake.Hash) (net.Conn, error) {
+	// Viper config
+	timeout := viper.GetDuration("peers.timeout")
+
+	if dial, ok := utpDialer(p); ok {
+		conn, err := dialWithPolicy(dial, p, infoHash, timeout)
+		if err == nil {
+			return conn, nil
+		}
+		log.Debug().Msgf("uTP failed with peer %s, trying TCP, err: %s", p, err)
+	}
+
+	return dialWithPolicy(dialTCP, p, infoHash, timeout)
+}
+
+// dialWithPolicy opens a connection and does the encryption handshake following the policy
+// With prefer, peers that fail the encryption are dialed again without it
+func dialWithPolicy(dial dialFunc, p peer.Peer, infoHash handshake.Hash, timeout time.Duration) (net.Conn, error) {
+	policy := encryptionPolicy()
+
+	conn, err := dial(p.String(), timeout)
+	if err != nil || policy == mse.PolicyDisabled {
+		return conn, err
+	}
+
+	encrypted, err := encryptConn(conn, infoHash, policy, timeout)
+	if err == nil || policy == mse.PolicyRequire {
+		return encrypted, err
+	}
+	log.Debug().Msgf("encryption failed with peer %s, trying plaintext, err: %s", p, err)
+
+	return dial(p.String(), timeout)
+}
+
+// encryptConn does the encryption handshake on a new connection
+// The connection is closed if the handshake fails
+func encryptConn(conn net.Conn, infoHash handshake.Hash, policy mse.Policy, timeout time.Duration) (net.Conn, error) {
+	err := conn.SetDeadline(time.Now().Add(timeout))
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	encrypted, err := mse.Initiate(conn, infoHash, policy.Methods())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// We can ignore the error for this line
+	conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	return encrypted, nil
+}
+
+// peekedConn is a connection with the first bytes already buffered
+type peekedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read reads from the buffered bytes first
+func (c peekedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
+
+// acceptEncryption detects and does the encryption handshake on a incoming connection
+// Returns the connection to read the peer handshake and the info hash used on the encryption
+func (l *Listener) acceptEncryption(conn net.Conn) (net.Conn, *handshake.Hash, error) {
+	policy := l.policy
+
+	reader := bufio.NewReader(conn)
+	peeked := peekedConn{Conn: conn, reader: reader}
+	header, err := reader.Peek(len(plaintextHeader))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if bytes.Equal(header, plaintextHeader) {
+		if policy == mse.PolicyRequire {
+			return nil, nil, errors.New("plaintext connections are refused")
+		}
+		return peeked, nil, nil
+	}
+	if policy == mse.PolicyDisabled {
+		return nil, nil, errors.New("encrypted connections are disabled")
+	}
+
+	encrypted, infoHash, err := mse.Accept(peeked, l.infoHashes(), policy.Methods())
+	if err != nil {
+		return nil, nil, err
+	}
+	hash := handshake.Hash(infoHash)
+	return encrypted, &hash, nil
+}
@@ -0,0 +1,197 @@
+package client
+
+import (
+	"net"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// This file exposes the internals of the package to the external tests
+
+// ValidateRequest validates a request against a uploader with the given pieces
+func (t *Torrent) ValidateRequest(have []int, index, begin, length int) error {
+	up := newUploader(t, nil)
+	for _, piece := range have {
+		up.markPiece(piece)
+	}
+	return up.validateRequest(blockRequest{index: index, begin: begin, length: length})
+}
+
+// UploadQueue exposes the queue of requests of a peer
+type UploadQueue struct {
+	queue *uploadQueue
+}
+
+// NewUploadQueue returns a new empty queue
+func NewUploadQueue() *UploadQueue {
+	return &UploadQueue{queue: newUploadQueue()}
+}
+
+// Push adds a request to the queue
+func (q *UploadQueue) Push(index, begin, length int) bool {
+	return q.queue.push(blockRequest{index: index, begin: begin, length: length})
+}
+
+// Cancel removes a request from the queue
+func (q *UploadQueue) Cancel(index, begin, length int) bool {
+	return q.queue.cancel(blockRequest{index: index, begin: begin, length: length})
+}
+
+// Pop waits for the next request
+func (q *UploadQueue) Pop() ([]int, bool) {
+	req, ok := q.queue.pop()
+	return []int{req.index, req.begin, req.length}, ok
+}
+
+// Close stops the queue
+func (q *UploadQueue) Close() {
+	q.queue.close()
+}
+
+// SeedConn shares all the pieces from the path with a peer that already did the handshake
+// Returns the client with the upload totals once the peer disconnects
+func (t *Torrent) SeedConn(conn net.Conn, path string, fast bool) (*Client, error) {
+	storage, err := t.openStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	up := newUploader(t, storage)
+	for index := range t.PieceHashes {
+		up.markPiece(index)
+	}
+
+	client := newClient(conn, peer.Peer{}, t.PeerID, t.InfoHash, NewBitfield(len(t.PieceHashes)))
+	client.fast = fast
+	client.startUploads(up)
+	defer client.uploads.close()
+
+	return client, client.seedLoop(len(t.PieceHashes))
+}
+
+// PiecePicker exposes the piece picker
+type PiecePicker struct {
+	picker *piecePicker
+	works  map[int]*pieceWork
+}
+
+// NewPiecePicker returns a picker with the missing pieces of a torrent
+func NewPiecePicker(nPieces, pieceLength int, missing []int) *PiecePicker {
+	p := &PiecePicker{works: map[int]*pieceWork{}}
+	works := []*pieceWork{}
+	for _, index := range missing {
+		work := &pieceWork{index: index, length: pieceLength}
+		p.works[index] = work
+		works = append(works, work)
+	}
+	p.picker = newPiecePicker(nPieces, works)
+	return p
+}
+
+// AddPeer counts the pieces of a new peer
+func (p *PiecePicker) AddPeer(bf Bitfield) {
+	p.picker.addPeer(bf)
+}
+
+// RemovePeer stops counting the pieces of a peer
+func (p *PiecePicker) RemovePeer(bf Bitfield) {
+	p.picker.removePeer(bf)
+}
+
+// Have counts a piece from a have message
+func (p *PiecePicker) Have(index int) {
+	p.picker.have(index)
+}
+
+// Availability returns how many peers have each piece
+func (p *PiecePicker) Availability() []int {
+	p.picker.mu.Lock()
+	defer p.picker.mu.Unlock()
+	return append([]int{}, p.picker.availability...)
+}
+
+// Next returns the index of the piece picked for the bitfield, or -1 without a piece
+func (p *PiecePicker) Next(bf Bitfield, local bool) int {
+	work, _, _ := p.picker.next(bf, local)
+	if work == nil {
+		return -1
+	}
+	return work.index
+}
+
+// GiveBack releases a picked piece
+func (p *PiecePicker) GiveBack(index int, local bool) {
+	p.picker.giveBack(p.works[index], local)
+}
+
+// Request flags a block of a picked piece as requested
+func (p *PiecePicker) Request(index, begin, length int) {
+	p.picker.request(index, begin, length)
+}
+
+// Finish flags a picked piece as verified
+func (p *PiecePicker) Finish(index int, local bool) {
+	p.picker.finish(p.works[index], local)
+}
+
+// AddBlock saves a block of a picked piece, returns if the piece is complete
+func (p *PiecePicker) AddBlock(index, begin int, block []byte) bool {
+	_, complete := p.picker.addBlock(index, begin, block)
+	return complete
+}
+
+// Reset drops the blocks of a piece that failed the hash check
+func (p *PiecePicker) Reset(index int) {
+	p.picker.reset(index)
+}
+
+// Watch returns the channel closed on the piece changes, its resets and if it's done
+func (p *PiecePicker) Watch(index int) (<-chan struct{}, int, bool) {
+	return p.picker.watch(index)
+}
+
+// LoadVerifiedPieces returns the pieces already on the path
+func (t *Torrent) LoadVerifiedPieces(path string) (Bitfield, error) {
+	storage, err := t.createStorage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+	return t.loadVerifiedPieces(storage), nil
+}
+
+// SaveResume saves the fast resume file for the data on the path
+func (t *Torrent) SaveResume(path string, bf Bitfield) error {
+	storage, err := t.createStorage(path)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	t.saveResume(storage, bf)
+	return nil
+}
+
+// StartPex exchanges the pooled peers with a outgoing peer supporting ut_pex
+// Returns the function stopping the exchange
+func StartPex(conn net.Conn, remote peer.Peer, pooled []peer.Peer) (func(), error) {
+	pool := newPeerPool()
+	for _, p := range pooled {
+		pool.add(p, 0)
+	}
+
+	client := newClient(conn, remote, handshake.PeerID{}, handshake.Hash{}, nil)
+	pex := newPexExtension(client, pool)
+	err := client.RegisterExtension(pex)
+	if err != nil {
+		return nil, err
+	}
+
+	payload, err := extension.Handshake{M: map[string]int{extension.ExtPex: 1}}.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return pex.Close, client.handleExtendedHandshake(payload)
+}
@@ -0,0 +1,170 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+	"github.com/jhelison/go-torrent/marshallers/message"
+)
+
+// clientVersion is the client name sent on the extended handshake
+const clientVersion = "go-torrent"
+
+// Extension is a extension protocol handler plugged into a client
+// The local id of each extension is given by the registration order
+type Extension interface {
+	// Name returns the extension name on the m dictionary
+	Name() string
+	// Extend adds the extension keys to our extended handshake
+	Extend(h *extension.Handshake)
+	// Handshake is called with the extended handshake from the peer
+	Handshake(c *Client, h *extension.Handshake) error
+	// Handle is called for each message sent to the extension
+	Handle(c *Client, payload []byte) error
+}
+
+// extensionCloser is implemented by extensions that must be stopped with the client
+type extensionCloser interface {
+	Close()
+}
+
+// RegisterExtension plugs a extension into the client
+// Extensions must be registered before our extended handshake is sent
+func (c *Client) RegisterExtension(ext Extension) error {
+	c.extensionsMu.Lock()
+	c.localExtensions = append(c.localExtensions, ext)
+	remote := c.remoteHandshake
+	c.extensionsMu.Unlock()
+
+	// The peer handshake may have arrived before the registration
+	if remote != nil {
+		return ext.Handshake(c, remote)
+	}
+	return nil
+}
+
+// SupportsExtension returns if the peer has a extension enabled
+func (c *Client) SupportsExtension(name string) bool {
+	c.extensionsMu.Lock()
+	defer c.extensionsMu.Unlock()
+	_, ok := c.remoteExtensions[name]
+	return ok
+}
+
+// SendExtendedHandshake sends our extended handshake with all the registered extensions
+// The listen port is sent so incoming peers can be shared by the peer
+func (c *Client) SendExtendedHandshake() error {
+	h := extension.Handshake{
+		M:       map[string]int{},
+		Version: clientVersion,
+		Reqq:    maxQueuedRequests,
+		Port:    int(listenPort()),
+	}
+	if c.peer.IP != nil {
+		h.YourIP = extension.CompactIP(c.peer.IP)
+	}
+
+	c.extensionsMu.Lock()
+	for i, ext := range c.localExtensions {
+		h.M[ext.Name()] = i + 1
+		ext.Extend(&h)
+	}
+	c.extensionsMu.Unlock()
+
+	payload, err := h.Marshal()
+	if err != nil {
+		return err
+	}
+	msg := extension.NewMessage(extension.HandshakeID, payload)
+	_, err = c.Conn.Write(msg.Serialize())
+	return err
+}
+
+// SendExtended sends a message to a extension of the peer
+func (c *Client) SendExtended(name string, payload []byte) error {
+	c.extensionsMu.Lock()
+	id, ok := c.remoteExtensions[name]
+	c.extensionsMu.Unlock()
+	if !ok {
+		return fmt.Errorf("peer %s doesn't support %s", c.peer, name)
+	}
+
+	msg := extension.NewMessage(id, payload)
+	_, err := c.Conn.Write(msg.Serialize())
+	return err
+}
+
+// handleExtendedMessage routes the extended messages to the registered extensions
+// Messages for unknown ids are ignored
+func (c *Client) handleExtendedMessage(msg message.Message) error {
+	id, payload, err := extension.ParseMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	if id == extension.HandshakeID {
+		return c.handleExtendedHandshake(payload)
+	}
+
+	c.extensionsMu.Lock()
+	var ext Extension
+	if int(id) <= len(c.localExtensions) {
+		ext = c.localExtensions[id-1]
+	}
+	c.extensionsMu.Unlock()
+
+	if ext == nil {
+		log.Debug().Msgf("ignoring unknown extended message %d from peer %s", id, c.peer)
+		return nil
+	}
+	return ext.Handle(c, payload)
+}
+
+// handleExtendedHandshake saves the extensions enabled by the peer
+// Later handshakes update the previous one
+func (c *Client) handleExtendedHandshake(payload []byte) error {
+	h, err := extension.UnmarshalHandshake(payload)
+	if err != nil {
+		log.Debug().Msgf("ignoring extended handshake from peer %s, err: %s", c.peer, err)
+		return nil
+	}
+	if h.Version != "" {
+		log.Debug().Msgf("Peer %s is using %s", c.peer, h.Version)
+	}
+
+	c.extensionsMu.Lock()
+	if c.remoteExtensions == nil {
+		c.remoteExtensions = map[string]extension.ExtendedID{}
+	}
+	for name, id := range h.M {
+		if id <= 0 || id > 255 {
+			delete(c.remoteExtensions, name)
+			continue
+		}
+		c.remoteExtensions[name] = extension.ExtendedID(id)
+	}
+	c.remoteHandshake = h
+	extensions := append([]Extension{}, c.localExtensions...)
+	c.extensionsMu.Unlock()
+
+	for _, ext := range extensions {
+		err := ext.Handshake(c, h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// closeExtensions stops the extensions with background work
+func (c *Client) closeExtensions() {
+	c.extensionsMu.Lock()
+	extensions := c.localExtensions
+	c.extensionsMu.Unlock()
+
+	for _, ext := range extensions {
+		if closer, ok := ext.(extensionCloser); ok {
+			closer.Close()
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/jhelison/go-torrent/marshallers/message"
+)
+
+// setHaves replaces the peer pieces from a have all or have none
+func (c *Client) setHaves(id message.MessageID, nPieces int) {
+	if id == message.MsgHaveAll {
+		c.setBitfield(NewFullBitfield(nPieces))
+	} else {
+		c.setBitfield(NewBitfield(nPieces))
+	}
+}
+
+// handleFastMessage handles the fast extension messages that don't depend on a piece
+// More information can be found on https://www.bittorrent.org/beps/bep_0006.html
+func (c *Client) handleFastMessage(msg message.Message) error {
+	if !c.fast {
+		return fmt.Errorf("unexpected fast message %v without the fast extension", msg.ID)
+	}
+
+	switch msg.ID {
+	case message.MsgAllowedFast:
+		// The piece can be requested even while choked
+		index, err := message.ParseAllowedFast(msg)
+		if err != nil {
+			return err
+		}
+		c.allowedFast[index] = true
+	case message.MsgSuggest:
+		// Suggestions are only a hint, the pieces are picked by the work queue
+		index, err := message.ParseSuggest(msg)
+		if err != nil {
+			return err
+		}
+		log.Trace().Msgf("Peer %s suggested piece %d", c.peer, index)
+	}
+	return nil
+}
+
+// canRequest returns if a piece can be requested from the peer
+// Allowed fast pieces can be requested while choked
+func (c *Client) canRequest(index int) bool {
+	return !c.Choked || c.allowedFast[index]
+}
@@ -0,0 +1,99 @@
+package client_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/message"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestFastPieces tests the have all and have none replacing the bitfield
+func TestFastPieces(t *testing.T) {
+	viper.Set("peers.timeout", "1s")
+	viper.Set("peers.encryption", "disabled")
+	nPieces := 10
+
+	testCases := []struct {
+		name        string
+		fast        bool
+		msg         message.Message
+		hasAll      bool
+		errContains string
+	}{
+		{
+			name:   "have all",
+			fast:   true,
+			msg:    message.NewMessage(message.MsgHaveAll, nil),
+			hasAll: true,
+		},
+		{
+			name: "have none",
+			fast: true,
+			msg:  message.NewMessage(message.MsgHaveNone, nil),
+		},
+		{
+			name:   "bitfield",
+			fast:   true,
+			msg:    message.NewMessage(message.MsgBitfield, client.NewFullBitfield(nPieces)),
+			hasAll: true,
+		},
+		{
+			name:        "have all without the fast extension",
+			msg:         message.NewMessage(message.MsgHaveAll, nil),
+			errContains: "without the fast extension",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			infoHash := handshake.Hash{1}
+			p := fakePeer(t, infoHash, tc.fast, tc.msg)
+
+			c, err := client.NewClient(p, handshake.PeerID{2}, infoHash, nPieces)
+			if tc.errContains != "" {
+				require.ErrorContains(t, err, tc.errContains)
+				return
+			}
+			require.NoError(t, err)
+			defer c.Close()
+
+			require.Equal(t, tc.hasAll, c.Bitfield.HasAll(nPieces))
+			require.Equal(t, !tc.hasAll, c.Bitfield.Empty())
+		})
+	}
+}
+
+// fakePeer starts a peer that answers the handshake and sends a single message
+func fakePeer(t *testing.T, infoHash handshake.Hash, fast bool, msg message.Message) peer.Peer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := handshake.Unmarshal(conn); err != nil {
+			return
+		}
+		res := handshake.NewHandshake(handshake.PeerID{3}, infoHash)
+		if fast {
+			res.SetReserved(handshake.FastBit)
+		}
+		conn.Write(res.Marshal())   //nolint:errcheck
+		conn.Write(msg.Serialize()) //nolint:errcheck
+		message.Unmarshal(conn)     //nolint:errcheck
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
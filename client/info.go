@@ -0,0 +1,103 @@
+package client
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"path/filepath"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/magnet"
+)
+
+// FileInfo is a single file from the torrent info
+type FileInfo struct {
+	Path   string `json:"path"`
+	Length int    `json:"length"`
+}
+
+// TorrentInfo is the metadata of a torrent, ready to be displayed
+// Magnets only have the info hash, name and trackers, the rest is on the peers
+type TorrentInfo struct {
+	InfoHash       string     `json:"info_hash"`
+	InfoHashBase32 string     `json:"info_hash_base32"`
+	Name           string     `json:"name"`
+	Magnet         bool       `json:"magnet"`
+	Length         int        `json:"length,omitempty"`
+	PieceLength    int        `json:"piece_length,omitempty"`
+	Pieces         int        `json:"pieces,omitempty"`
+	Private        bool       `json:"private"`
+	CreationDate   *time.Time `json:"creation_date,omitempty"`
+	CreatedBy      string     `json:"created_by,omitempty"`
+	Comment        string     `json:"comment,omitempty"`
+	Trackers       [][]string `json:"trackers"`
+	WebSeeds       []string   `json:"web_seeds,omitempty"`
+	Files          []FileInfo `json:"files,omitempty"`
+
+	infoHash handshake.Hash
+}
+
+// InfoFromTorrentFile returns the metadata of a torrent file
+// No tracker is contacted
+func InfoFromTorrentFile(tFile string) (*TorrentInfo, error) {
+	torrentFile, err := readTorrentFile(tFile)
+	if err != nil {
+		return nil, err
+	}
+
+	info := newTorrentInfo(torrentFile.InfoHash, torrentFile.Name, torrentFile.Tiers())
+	info.Length = torrentFile.Length
+	info.PieceLength = torrentFile.PieceLength
+	info.Pieces = len(torrentFile.PieceHashes)
+	info.Private = torrentFile.Private
+	info.CreatedBy = torrentFile.CreatedBy
+	info.Comment = torrentFile.Comment
+	info.WebSeeds = torrentFile.WebSeeds
+	if !torrentFile.CreationDate.IsZero() {
+		creationDate := torrentFile.CreationDate.UTC()
+		info.CreationDate = &creationDate
+	}
+
+	info.Files = make([]FileInfo, len(torrentFile.Files))
+	for i, file := range torrentFile.Files {
+		info.Files[i] = FileInfo{
+			Path:   filepath.Join(file.Path...),
+			Length: file.Length,
+		}
+	}
+
+	return info, nil
+}
+
+// InfoFromMagnet returns the metadata available on a magnet URI
+// The info dictionary is not fetched, so only the magnet fields are set
+func InfoFromMagnet(uri string) (*TorrentInfo, error) {
+	m, err := magnet.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each magnet tracker is its own tier
+	tiers := make([][]string, len(m.Trackers))
+	for i, tr := range m.Trackers {
+		tiers[i] = []string{tr}
+	}
+
+	info := newTorrentInfo(m.InfoHash, m.DisplayName, tiers)
+	info.Magnet = true
+	return info, nil
+}
+
+// newTorrentInfo builds the info with the fields shared by torrents and magnets
+func newTorrentInfo(infoHash handshake.Hash, name string, tiers [][]string) *TorrentInfo {
+	if tiers == nil {
+		tiers = [][]string{}
+	}
+	return &TorrentInfo{
+		InfoHash:       hex.EncodeToString(infoHash[:]),
+		InfoHashBase32: base32.StdEncoding.EncodeToString(infoHash[:]),
+		Name:           name,
+		Trackers:       tiers,
+		infoHash:       infoHash,
+	}
+}
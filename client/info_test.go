@@ -0,0 +1,62 @@
+package client_test
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+)
+
+// TestInfoFromTorrentFile tests the metadata read from a torrent file
+func TestInfoFromTorrentFile(t *testing.T) {
+	info := "d5:filesld6:lengthi6e4:pathl1:aeed6:lengthi4e4:pathl3:sub1:beee" +
+		"4:name4:test12:piece lengthi16e6:pieces20:" + strings.Repeat("a", 20) + "7:privatei1ee"
+	raw := "d8:announce16:http://test.org/7:comment5:hello13:creation datei1700000000e" +
+		"4:info" + info + "8:url-listl16:http://seed.org/ee"
+
+	path := filepath.Join(t.TempDir(), "test.torrent")
+	require.NoError(t, os.WriteFile(path, []byte(raw), 0o644))
+
+	torrentInfo, err := client.InfoFromTorrentFile(path)
+	require.NoError(t, err)
+
+	infoHash := sha1.Sum([]byte(info))
+	require.Equal(t, hex.EncodeToString(infoHash[:]), torrentInfo.InfoHash)
+	require.Len(t, torrentInfo.InfoHashBase32, 32)
+	require.Equal(t, "test", torrentInfo.Name)
+	require.False(t, torrentInfo.Magnet)
+	require.Equal(t, 10, torrentInfo.Length)
+	require.Equal(t, 16, torrentInfo.PieceLength)
+	require.Equal(t, 1, torrentInfo.Pieces)
+	require.True(t, torrentInfo.Private)
+	require.Equal(t, "hello", torrentInfo.Comment)
+	require.Equal(t, int64(1700000000), torrentInfo.CreationDate.Unix())
+	require.Equal(t, [][]string{{"http://test.org/"}}, torrentInfo.Trackers)
+	require.Equal(t, []string{"http://seed.org/"}, torrentInfo.WebSeeds)
+	require.Equal(t, []client.FileInfo{
+		{Path: filepath.Join("test", "a"), Length: 6},
+		{Path: filepath.Join("test", "sub", "b"), Length: 4},
+	}, torrentInfo.Files)
+}
+
+// TestInfoFromMagnet tests the metadata read from a magnet URI
+func TestInfoFromMagnet(t *testing.T) {
+	uri := "magnet:?xt=urn:btih:0123456789abcdef0123456789abcdef01234567&dn=test" +
+		"&tr=http://a.org/&tr=udp://b.org:80"
+
+	torrentInfo, err := client.InfoFromMagnet(uri)
+	require.NoError(t, err)
+
+	require.True(t, torrentInfo.Magnet)
+	require.Equal(t, "0123456789abcdef0123456789abcdef01234567", torrentInfo.InfoHash)
+	require.Equal(t, "AERUKZ4JVPG66AJDIVTYTK6N54ASGRLH", torrentInfo.InfoHashBase32)
+	require.Equal(t, "test", torrentInfo.Name)
+	require.Equal(t, [][]string{{"http://a.org/"}, {"udp://b.org:80"}}, torrentInfo.Trackers)
+	require.Empty(t, torrentInfo.Files)
+}
@@ -0,0 +1,283 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+	"github.com/jhelison/go-torrent/mse"
+	"github.com/jhelison/go-torrent/utp"
+
+	"github.com/spf13/viper"
+)
+
+// inboundHandler receives the connections after the handshake
+type inboundHandler func(conn net.Conn, remote *handshake.Handshake)
+
+// inboundTorrent is a torrent registered on a listener
+type inboundTorrent struct {
+	peerID  handshake.PeerID
+	handler inboundHandler
+}
+
+// Listener accepts the incoming peer connections over TCP and uTP
+// The connections are routed to the torrents by the info hash
+type Listener struct {
+	listener net.Listener
+	// utp shares the TCP port, it's nil if uTP is disabled or failed
+	utp *utp.Socket
+	// The configs are read once, when the listener starts
+	timeout time.Duration
+	policy  mse.Policy
+
+	mu       sync.RWMutex
+	torrents map[handshake.Hash]inboundTorrent
+}
+
+var (
+	// The listener shared between all the torrents
+	defaultListener     *Listener
+	defaultListenerErr  error
+	defaultListenerOnce sync.Once
+)
+
+// DefaultListener returns the listener shared between all the torrents
+// It starts listening on the configured address on the first call
+func DefaultListener() (*Listener, error) {
+	defaultListenerOnce.Do(func() {
+		// Viper configs
+		host := viper.GetString("peers.listen_host")
+		port := viper.GetInt("peers.listen_port")
+
+		defaultListener, defaultListenerErr = Listen(net.JoinHostPort(host, strconv.Itoa(port)))
+	})
+	return defaultListener, defaultListenerErr
+}
+
+// Listen starts accepting peer connections on a address
+// uTP listens on the same port as TCP, failing to do so only disables uTP
+func Listen(address string) (*Listener, error) {
+	// Viper configs
+	utpEnabled := viper.GetBool("peers.utp")
+	timeout := viper.GetDuration("peers.timeout")
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Listener{
+		listener: listener,
+		timeout:  timeout,
+		policy:   encryptionPolicy(),
+		torrents: map[handshake.Hash]inboundTorrent{},
+	}
+	go l.acceptLoop(listener)
+	log.Info().Msgf("Listening for peers on %s", listener.Addr())
+
+	if utpEnabled {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			listener.Close()
+			return nil, err
+		}
+		// The port is taken from TCP, as the address may have port 0
+		l.utp, err = utp.Listen(net.JoinHostPort(host, strconv.Itoa(int(l.Port()))))
+		if err != nil {
+			log.Warn().Msgf("not accepting uTP peers, err: %s", err)
+		} else {
+			go l.acceptLoop(l.utp)
+			log.Info().Msgf("Listening for uTP peers on %s", l.utp.Addr())
+		}
+	}
+	return l, nil
+}
+
+// Port returns the port the listener is bound to
+func (l *Listener) Port() uint16 {
+	return uint16(l.listener.Addr().(*net.TCPAddr).Port)
+}
+
+// Register routes the connections for a info hash to a handler
+// The peer ID is sent on our side of the handshake
+func (l *Listener) Register(infoHash handshake.Hash, peerID handshake.PeerID, handler inboundHandler) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.torrents[infoHash] = inboundTorrent{
+		peerID:  peerID,
+		handler: handler,
+	}
+}
+
+// Unregister stops routing the connections for a info hash
+func (l *Listener) Unregister(infoHash handshake.Hash) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.torrents, infoHash)
+}
+
+// Close stops accepting connections
+// The uTP connections are closed with the socket
+func (l *Listener) Close() error {
+	err := l.listener.Close()
+	if l.utp != nil {
+		err = errors.Join(err, l.utp.Close())
+	}
+	return err
+}
+
+// acceptLoop accepts the connections until the listener is closed
+func (l *Listener) acceptLoop(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Warn().Msgf("failed to accept connection, err: %s", err)
+			continue
+		}
+
+		go l.handleConn(conn)
+	}
+}
+
+// handleConn does the receiving side of the handshake
+// The connection is closed if the info hash isn't registered
+func (l *Listener) handleConn(conn net.Conn) {
+	peerConn, remote, torrent, err := l.receiveHandshake(conn)
+	if err != nil {
+		log.Debug().Msgf("failed to receive handshake from %s, err: %s", conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+
+	log.Info().Msgf("Incoming connection from peer %s", conn.RemoteAddr())
+	torrent.handler(peerConn, remote)
+}
+
+// receiveHandshake reads the remote handshake and answers with ours
+// The returned connection is the encrypted one if the peer used encryption
+func (l *Listener) receiveHandshake(conn net.Conn) (net.Conn, *handshake.Handshake, inboundTorrent, error) {
+	err := conn.SetDeadline(time.Now().Add(l.timeout))
+	if err != nil {
+		return nil, nil, inboundTorrent{}, err
+	}
+	// We can ignore the error for this line
+	defer conn.SetDeadline(time.Time{}) //nolint:errcheck
+
+	peerConn, encryptedHash, err := l.acceptEncryption(conn)
+	if err != nil {
+		return nil, nil, inboundTorrent{}, err
+	}
+
+	remote, err := handshake.Unmarshal(peerConn)
+	if err != nil {
+		return nil, nil, inboundTorrent{}, err
+	}
+	if encryptedHash != nil && *encryptedHash != remote.InfoHash {
+		return nil, nil, inboundTorrent{}, errors.New("handshake doesn't match the encryption info hash")
+	}
+
+	// Route by the info hash
+	l.mu.RLock()
+	torrent, ok := l.torrents[remote.InfoHash]
+	l.mu.RUnlock()
+	if !ok {
+		return nil, nil, inboundTorrent{}, errors.New("unknown info hash")
+	}
+
+	_, err = peerConn.Write(newLocalHandshake(torrent.peerID, remote.InfoHash).Marshal())
+	if err != nil {
+		return nil, nil, inboundTorrent{}, err
+	}
+
+	return peerConn, remote, torrent, nil
+}
+
+// infoHashes returns the registered info hashes
+// They are the possible secrets of the encrypted connections
+func (l *Listener) infoHashes() [][20]byte {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	hashes := make([][20]byte, 0, len(l.torrents))
+	for infoHash := range l.torrents {
+		hashes = append(hashes, infoHash)
+	}
+	return hashes
+}
+
+// listenPort returns the port announced to the trackers
+// This is the listener port or the configured port if it isn't listening
+func listenPort() uint16 {
+	listener, err := DefaultListener()
+	if err != nil {
+		return uint16(viper.GetInt("peers.listen_port"))
+	}
+	return listener.Port()
+}
+
+// announceIPv6 returns our IPv6 address sent to the trackers
+// It's nil if the listener is bound to IPv4 or there is no global IPv6
+func announceIPv6() net.IP {
+	listener, err := DefaultListener()
+	if err != nil {
+		return nil
+	}
+
+	ip := listener.listener.Addr().(*net.TCPAddr).IP
+	if ip.To4() != nil {
+		return nil
+	}
+	if !ip.IsUnspecified() {
+		return ip
+	}
+
+	// Listening on all the interfaces, use the first global address
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Debug().Msgf("failed to list the interface addresses, err: %s", err)
+		return nil
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.To4() == nil && ipNet.IP.IsGlobalUnicast() {
+			return ipNet.IP
+		}
+	}
+	return nil
+}
+
+// peerFromAddr builds a peer from a TCP or uTP connection address
+func peerFromAddr(addr net.Addr) peer.Peer {
+	var ip net.IP
+	var port int
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = addr.IP, addr.Port
+	case *net.UDPAddr:
+		ip, port = addr.IP, addr.Port
+	default:
+		return peer.Peer{}
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	return peer.Peer{
+		IP:   ip,
+		Port: uint16(port),
+	}
+}
+
+// isUTP returns if a connection is over uTP
+// The encrypted connections keep the address of the underlying one
+func isUTP(conn net.Conn) bool {
+	_, ok := conn.RemoteAddr().(*net.UDPAddr)
+	return ok
+}
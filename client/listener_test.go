@@ -0,0 +1,146 @@
+package client_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/mse"
+	"github.com/jhelison/go-torrent/utp"
+)
+
+// TestListener tests the routing of incoming connections by info hash
+func TestListener(t *testing.T) {
+	viper.Set("peers.timeout", "1s")
+
+	registered := handshake.Hash{1}
+	ourID := handshake.PeerID{2}
+
+	testCases := []struct {
+		name      string
+		infoHash  handshake.Hash
+		policy    mse.Policy
+		encrypted bool
+		utp       bool
+		routed    bool
+	}{
+		{
+			name:     "registered",
+			infoHash: registered,
+			policy:   mse.PolicyPrefer,
+			routed:   true,
+		},
+		{
+			name:     "unknown",
+			infoHash: handshake.Hash{3},
+			policy:   mse.PolicyPrefer,
+		},
+		{
+			name:      "encrypted",
+			infoHash:  registered,
+			policy:    mse.PolicyRequire,
+			encrypted: true,
+			routed:    true,
+		},
+		{
+			name:      "encrypted with unknown info hash",
+			infoHash:  handshake.Hash{3},
+			policy:    mse.PolicyPrefer,
+			encrypted: true,
+		},
+		{
+			name:      "encryption disabled",
+			infoHash:  registered,
+			policy:    mse.PolicyDisabled,
+			encrypted: true,
+		},
+		{
+			name:     "encryption required",
+			infoHash: registered,
+			policy:   mse.PolicyRequire,
+		},
+		{
+			name:     "utp",
+			infoHash: registered,
+			policy:   mse.PolicyPrefer,
+			utp:      true,
+			routed:   true,
+		},
+		{
+			name:      "encrypted utp",
+			infoHash:  registered,
+			policy:    mse.PolicyRequire,
+			encrypted: true,
+			utp:       true,
+			routed:    true,
+		},
+		{
+			name:     "unknown over utp",
+			infoHash: handshake.Hash{3},
+			policy:   mse.PolicyPrefer,
+			utp:      true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The configs are set before the listener goroutines start
+			viper.Set("peers.encryption", string(tc.policy))
+			viper.Set("peers.utp", true)
+
+			listener, err := client.Listen("127.0.0.1:0")
+			require.NoError(t, err)
+			defer listener.Close()
+
+			accepted := make(chan handshake.PeerID, 1)
+			listener.Register(registered, ourID, func(conn net.Conn, remote *handshake.Handshake) {
+				accepted <- remote.PeerID
+				conn.Close()
+			})
+
+			address := fmt.Sprintf("127.0.0.1:%d", listener.Port())
+			var conn net.Conn
+			if tc.utp {
+				socket, err := utp.Listen("127.0.0.1:0")
+				require.NoError(t, err)
+				defer socket.Close()
+				conn, err = socket.DialTimeout(address, time.Second)
+				require.NoError(t, err)
+			} else {
+				conn, err = net.Dial("tcp", address)
+				require.NoError(t, err)
+			}
+			defer conn.Close()
+			require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+			if tc.encrypted {
+				encrypted, err := mse.Initiate(conn, tc.infoHash, mse.CryptoRC4)
+				if !tc.routed {
+					require.Error(t, err)
+					return
+				}
+				require.NoError(t, err)
+				conn = encrypted
+			}
+
+			_, err = conn.Write(handshake.NewHandshake(handshake.PeerID{4}, tc.infoHash).Marshal())
+			require.NoError(t, err)
+
+			res, err := handshake.Unmarshal(conn)
+			if tc.routed {
+				require.NoError(t, err)
+				require.Equal(t, tc.infoHash, res.InfoHash)
+				require.Equal(t, ourID, res.PeerID)
+				require.Equal(t, handshake.PeerID{4}, <-accepted)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+}
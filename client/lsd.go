@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/lsd"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	// The local service discovery shared between all the torrents
+	defaultLSD     *lsd.Service
+	defaultLSDErr  error
+	defaultLSDOnce sync.Once
+)
+
+// DefaultLSD returns the local service discovery shared between all the torrents
+// It joins the IPv4 and IPv6 multicast groups on the first call
+func DefaultLSD() (*lsd.Service, error) {
+	defaultLSDOnce.Do(func() {
+		// Viper config
+		enabled := viper.GetBool("lsd.enabled")
+
+		if !enabled {
+			defaultLSDErr = errors.New("local service discovery is disabled")
+			return
+		}
+		defaultLSD, defaultLSDErr = lsd.Listen(lsd.Group4, lsd.Group6)
+	})
+	return defaultLSD, defaultLSDErr
+}
+
+// announceLSD announces the torrent on the local network until the stop channel is closed
+// The local peers are sent to the returned channel as they announce
+// Private torrents never use the local service discovery
+func (t *Torrent) announceLSD(port uint16, stop <-chan struct{}) <-chan []peer.Peer {
+	if t.Private {
+		return nil
+	}
+
+	service, err := DefaultLSD()
+	if err != nil {
+		log.Debug().Msgf("not using the local service discovery, err: %s", err)
+		return nil
+	}
+
+	// Viper config
+	interval := viper.GetDuration("lsd.announce_interval")
+	if interval < lsd.MinAnnounceInterval {
+		interval = lsd.MinAnnounceInterval
+	}
+
+	peers := service.Subscribe(t.InfoHash)
+	go func() {
+		defer service.Unsubscribe(t.InfoHash)
+
+		for {
+			err := service.Announce(t.InfoHash, port)
+			if err != nil {
+				log.Warn().Msgf("failed to announce on the local network, err: %s", err)
+			}
+
+			timer := time.NewTimer(interval)
+			select {
+			case <-timer.C:
+			case <-stop:
+				timer.Stop()
+				return
+			}
+		}
+	}()
+	return peers
+}
+
+// isLocalPeer returns if a peer is on the local network
+func isLocalPeer(p peer.Peer) bool {
+	return p.IP.IsLoopback() || p.IP.IsPrivate() || p.IP.IsLinkLocalUnicast()
+}
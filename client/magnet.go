@@ -0,0 +1,108 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/magnet"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+	"github.com/jhelison/go-torrent/tracker"
+)
+
+// TorrentFromMagnet returns a torrent from a magnet URI
+// The info dictionary is fetched from the peers using the metadata extension
+func TorrentFromMagnet(uri string) (Torrent, error) {
+	m, err := magnet.Parse(uri)
+	if err != nil {
+		return Torrent{}, err
+	}
+
+	peerID, err := newPeerID()
+	if err != nil {
+		return Torrent{}, err
+	}
+
+	// Each magnet tracker is its own tier, so the peers from all of them are merged
+	tiers := make([][]string, len(m.Trackers))
+	for i, tr := range m.Trackers {
+		tiers[i] = []string{tr}
+	}
+
+	trackers := tracker.NewManager(tiers)
+
+	// Collect the peers from the magnet and from the trackers
+	peers := m.Peers
+	if len(tiers) > 0 {
+		// The length is unknown until we have the info
+		// Trackers treat a left of 0 as a seeder, so we announce a single byte
+		res, err := trackers.Announce(tracker.AnnounceRequest{
+			InfoHash: m.InfoHash,
+			PeerID:   peerID,
+			Port:     listenPort(),
+			Left:     1,
+			IPv6:     announceIPv6(),
+		})
+		if err != nil {
+			log.Warn().Msgf("failed to announce the magnet, err: %s", err)
+		} else {
+			peers = append(peers, res.Peers...)
+		}
+	}
+	// Magnets without trackers rely on the DHT
+	peers = append(peers, lookupDHT(m.InfoHash)...)
+	peers = uniquePeers(peers)
+	if len(peers) == 0 {
+		return Torrent{}, errors.New("no peers found for the magnet")
+	}
+
+	log.Info().Msgf("Fetching metadata from %v peers", len(peers))
+
+	// Download and parse the info
+	rawInfo, err := fetchMetadata(peers, peerID, m.InfoHash)
+	if err != nil {
+		return Torrent{}, err
+	}
+
+	announce := ""
+	if len(m.Trackers) > 0 {
+		announce = m.Trackers[0]
+	}
+	torrent, err := bencode.UnmarshalInfo(bytes.NewReader(rawInfo), announce)
+	if err != nil {
+		return Torrent{}, err
+	}
+	torrentFile, err := torrent.ToTorrentFile()
+	if err != nil {
+		return Torrent{}, err
+	}
+
+	return Torrent{
+		Peers:  peers,
+		PeerID: peerID,
+		// The raw info has already been validated against the magnet info hash
+		InfoHash:    m.InfoHash,
+		Info:        rawInfo,
+		PieceHashes: torrentFile.PieceHashes,
+		PieceLength: torrentFile.PieceLength,
+		Length:      torrentFile.Length,
+		Name:        torrentFile.Name,
+		Files:       torrentFile.Files,
+		Trackers:    trackers,
+		Private:     torrentFile.Private,
+	}, nil
+}
+
+// uniquePeers removes the duplicated peers from a list
+func uniquePeers(peers []peer.Peer) []peer.Peer {
+	seen := make(map[string]bool, len(peers))
+	unique := make([]peer.Peer, 0, len(peers))
+	for _, p := range peers {
+		if seen[p.String()] {
+			continue
+		}
+		seen[p.String()] = true
+		unique = append(unique, p)
+	}
+	return unique
+}
@@ -0,0 +1,64 @@
+package client_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+)
+
+// TestMagnetMetadata tests fetching the metadata from a seeding peer
+func TestMagnetMetadata(t *testing.T) {
+	viper.Set("peers.timeout", "2s")
+	viper.Set("peers.listen_host", "127.0.0.1")
+	viper.Set("peers.listen_port", 0)
+	viper.Set("peers.encryption", "prefer")
+	viper.Set("peers.utp", true)
+	viper.Set("download.deadline", "5s")
+	viper.Set("download.fast_resume", false)
+	viper.Set("upload.idle_timeout", "5s")
+	viper.Set("dht.enabled", false)
+	viper.Set("lsd.enabled", false)
+
+	// Create the torrent for a single file
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("go-torrent"), 5000)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.bin"), data, 0o644))
+	created, err := bencode.Create(filepath.Join(dir, "data.bin"), bencode.CreateOptions{PieceLength: 16384})
+	require.NoError(t, err)
+
+	torrentPath := filepath.Join(dir, "data.torrent")
+	require.NoError(t, os.WriteFile(torrentPath, created, 0o644))
+
+	torrent, err := client.TorrentFromTorrentFile(torrentPath)
+	require.NoError(t, err)
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go torrent.Seed(dir, stop) //nolint:errcheck
+
+	listener, err := client.DefaultListener()
+	require.NoError(t, err)
+	uri := fmt.Sprintf("magnet:?xt=urn:btih:%s&x.pe=127.0.0.1:%d", hex.EncodeToString(torrent.InfoHash[:]), listener.Port())
+
+	// The seed registers on the listener after checking the pieces
+	var fetched client.Torrent
+	require.Eventually(t, func() bool {
+		fetched, err = client.TorrentFromMagnet(uri)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+
+	require.Equal(t, torrent.InfoHash, fetched.InfoHash)
+	require.Equal(t, torrent.Name, fetched.Name)
+	require.Equal(t, torrent.PieceHashes, fetched.PieceHashes)
+	require.Equal(t, torrent.Info, fetched.Info)
+}
@@ -0,0 +1,263 @@
+package client
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/message"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+
+	"github.com/spf13/viper"
+)
+
+// maxMetadataSize is the biggest info dictionary we accept from a peer
+const maxMetadataSize = 16 * 1024 * 1024
+
+// metadataResult is the result from a single peer metadata request
+type metadataResult struct {
+	peer peer.Peer
+	info []byte
+	err  error
+}
+
+// fetchMetadata downloads the info dictionary from the peers
+// All the peers are requested at the same time and the first valid info is returned
+func fetchMetadata(peers []peer.Peer, peerID handshake.PeerID, infoHash handshake.Hash) ([]byte, error) {
+	if len(peers) == 0 {
+		return nil, errors.New("no peers to request the metadata from")
+	}
+
+	// The channel is buffered so late workers never block
+	results := make(chan metadataResult, len(peers))
+	for _, p := range peers {
+		go func(p peer.Peer) {
+			info, err := requestMetadata(p, peerID, infoHash)
+			results <- metadataResult{peer: p, info: info, err: err}
+		}(p)
+	}
+
+	for range peers {
+		res := <-results
+		if res.err != nil {
+			log.Warn().Msgf("failed to fetch metadata from peer %s, err: %s", res.peer, res.err)
+			continue
+		}
+
+		log.Info().Msgf("Metadata received from peer %s", res.peer)
+		return res.info, nil
+	}
+
+	return nil, errors.New("failed to fetch the metadata from all peers")
+}
+
+// requestMetadata downloads the info dictionary from a single peer
+// The info is validated against the info hash
+func requestMetadata(p peer.Peer, peerID handshake.PeerID, infoHash handshake.Hash) ([]byte, error) {
+	// Viper config
+	deadline := viper.GetDuration("download.deadline")
+
+	conn, err := dialPeer(p, infoHash)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Handshake flagging the extension protocol
+	res, err := completeHandshake(conn, newLocalHandshake(peerID, infoHash))
+	if err != nil {
+		return nil, err
+	}
+	if !res.SupportsExtensions() {
+		return nil, errors.New("peer doesn't support the extension protocol")
+	}
+
+	// The full exchange has a single deadline
+	err = conn.SetDeadline(time.Now().Add(deadline))
+	if err != nil {
+		return nil, err
+	}
+
+	client := newClient(conn, p, peerID, infoHash, nil)
+	fetcher := &metadataFetcher{infoHash: infoHash}
+	err = client.RegisterExtension(fetcher)
+	if err != nil {
+		return nil, err
+	}
+	err = client.SendExtendedHandshake()
+	if err != nil {
+		return nil, err
+	}
+
+	for fetcher.result == nil {
+		msg, err := client.Read()
+		if err != nil {
+			return nil, err
+		}
+
+		// Everything not extended is ignored
+		// Piece messages are never requested, but we can't skip their data
+		if msg.ID == message.MsgPiece {
+			return nil, errors.New("unexpected piece message")
+		}
+		if msg.ID != message.MsgExtended {
+			continue
+		}
+
+		err = client.handleExtendedMessage(msg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fetcher.result, nil
+}
+
+// metadataFetcher is the ut_metadata extension downloading the info dictionary
+// All the pieces are requested once the peer tells us the metadata size
+type metadataFetcher struct {
+	infoHash handshake.Hash
+	info     []byte
+	done     []bool
+	received int
+	result   []byte
+}
+
+// Name returns the extension name
+func (f *metadataFetcher) Name() string {
+	return extension.ExtMetadata
+}
+
+// Extend doesn't add any key to the handshake
+func (f *metadataFetcher) Extend(h *extension.Handshake) {}
+
+// Handshake requests all the metadata pieces at once
+func (f *metadataFetcher) Handshake(c *Client, h *extension.Handshake) error {
+	if f.info != nil {
+		return nil
+	}
+	if !c.SupportsExtension(extension.ExtMetadata) {
+		return errors.New("peer doesn't support ut_metadata")
+	}
+	size := h.MetadataSize
+	if size <= 0 || size > maxMetadataSize {
+		return fmt.Errorf("invalid metadata size %d", size)
+	}
+
+	f.info = make([]byte, size)
+	nPieces := (size + extension.MetadataPieceSize - 1) / extension.MetadataPieceSize
+	f.done = make([]bool, nPieces)
+	for piece := 0; piece < nPieces; piece++ {
+		payload, err := extension.NewMetadataRequest(piece).Marshal()
+		if err != nil {
+			return err
+		}
+		err = c.SendExtended(extension.ExtMetadata, payload)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Handle copies a metadata piece
+// The info is validated once all the pieces are received
+func (f *metadataFetcher) Handle(c *Client, payload []byte) error {
+	if f.info == nil {
+		return errors.New("metadata received before the extended handshake")
+	}
+
+	metadataMsg, err := extension.UnmarshalMetadata(payload)
+	if err != nil {
+		return err
+	}
+	if metadataMsg.MsgType == extension.MetadataReject {
+		return fmt.Errorf("metadata piece %d rejected", metadataMsg.Piece)
+	}
+	if metadataMsg.MsgType != extension.MetadataData {
+		return nil
+	}
+
+	// Validate the piece bounds before the copy
+	begin := metadataMsg.Piece * extension.MetadataPieceSize
+	if metadataMsg.Piece < 0 || metadataMsg.Piece >= len(f.done) || begin+len(metadataMsg.Data) > len(f.info) {
+		return fmt.Errorf("invalid metadata piece %d", metadataMsg.Piece)
+	}
+	copy(f.info[begin:], metadataMsg.Data)
+	if !f.done[metadataMsg.Piece] {
+		f.done[metadataMsg.Piece] = true
+		f.received++
+	}
+
+	if f.received == len(f.done) {
+		hash := sha1.Sum(f.info)
+		if !bytes.Equal(hash[:], f.infoHash[:]) {
+			return errors.New("metadata doesn't match the info hash")
+		}
+		f.result = f.info
+	}
+	return nil
+}
+
+// metadataExtension is the ut_metadata extension serving our info dictionary
+// More information can be found on https://www.bittorrent.org/beps/bep_0009.html
+type metadataExtension struct {
+	info []byte
+}
+
+// newMetadataExtension returns a new extension serving the info
+func newMetadataExtension(info []byte) *metadataExtension {
+	return &metadataExtension{info: info}
+}
+
+// Name returns the extension name
+func (m *metadataExtension) Name() string {
+	return extension.ExtMetadata
+}
+
+// Extend adds the metadata size to the handshake
+func (m *metadataExtension) Extend(h *extension.Handshake) {
+	h.MetadataSize = len(m.info)
+}
+
+// Handshake doesn't need anything from the peer handshake
+func (m *metadataExtension) Handshake(c *Client, h *extension.Handshake) error {
+	return nil
+}
+
+// Handle answers the metadata requests
+// Invalid pieces are rejected
+func (m *metadataExtension) Handle(c *Client, payload []byte) error {
+	req, err := extension.UnmarshalMetadata(payload)
+	if err != nil {
+		log.Debug().Msgf("ignoring metadata message from peer %s, err: %s", c.peer, err)
+		return nil
+	}
+	if req.MsgType != extension.MetadataRequest || !c.SupportsExtension(extension.ExtMetadata) {
+		return nil
+	}
+
+	res := extension.MetadataMessage{
+		MsgType: extension.MetadataReject,
+		Piece:   req.Piece,
+	}
+	begin := req.Piece * extension.MetadataPieceSize
+	if req.Piece >= 0 && begin < len(m.info) {
+		end := begin + extension.MetadataPieceSize
+		if end > len(m.info) {
+			end = len(m.info)
+		}
+		res.MsgType = extension.MetadataData
+		res.TotalSize = len(m.info)
+		res.Data = m.info[begin:end]
+	}
+
+	payload, err = res.Marshal()
+	if err != nil {
+		return err
+	}
+	return c.SendExtended(extension.ExtMetadata, payload)
+}
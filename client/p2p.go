@@ -3,13 +3,22 @@ package client
 import (
 	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
+	"net"
+	"path/filepath"
 	"runtime"
+	"sync/atomic"
+	"time"
 
 	"github.com/jhelison/go-torrent/filesystem"
 	"github.com/jhelison/go-torrent/logger"
+	"github.com/jhelison/go-torrent/marshallers/bencode"
 	"github.com/jhelison/go-torrent/marshallers/handshake"
 	"github.com/jhelison/go-torrent/marshallers/peer"
+	"github.com/jhelison/go-torrent/tracker"
+
+	"github.com/spf13/viper"
 )
 
 var (
@@ -22,10 +31,15 @@ type Torrent struct {
 	Peers       []peer.Peer
 	PeerID      handshake.PeerID
 	InfoHash    handshake.Hash
+	Info        []byte
 	PieceHashes []handshake.Hash
 	PieceLength int
 	Length      int
 	Name        string
+	Files       []bencode.File
+	Trackers    *tracker.Manager
+	Private     bool
+	Nodes       []string
 }
 
 // pieceWork is a single work from a piece
@@ -42,19 +56,62 @@ type pieceResult struct {
 }
 
 // startDownloadWorker start a new worker to download a piece from a peer
-func (t *Torrent) startDownloadWorker(peer peer.Peer, workQueue chan *pieceWork, results chan *pieceResult) {
+// The verified pieces are also shared with the peer while downloading
+func (t *Torrent) startDownloadWorker(peer peer.Peer, picker *piecePicker, results chan *pieceResult, up *uploader, pool *peerPool) {
 	// Create a new client for the peer
-	client, err := NewClient(peer, t.PeerID, t.InfoHash)
+	client, err := NewClient(peer, t.PeerID, t.InfoHash, len(t.PieceHashes))
 	if err != nil {
 		log.Warn().Msgf("failed to start handshake with peer %s, err: %s", peer, err)
 		return
 	}
-	defer client.Conn.Close()
 
 	log.Info().Msgf("Handshake complete with peer %s", peer)
 
+	// Share the pieces we already have
+	err = client.SendPieces(up.bitfield(), len(t.PieceHashes))
+	if err != nil {
+		log.Warn().Msgf("failed to send bitfield to peer %s, err: %s", peer, err)
+		client.Close()
+		return
+	}
+
+	t.runDownloadWorker(client, picker, results, up, pool)
+}
+
+// acceptDownloadPeer handles a incoming connection while downloading
+// Our bitfield is sent first and the peer must answer with its own
+func (t *Torrent) acceptDownloadPeer(conn net.Conn, remote *handshake.Handshake, picker *piecePicker, results chan *pieceResult, up *uploader, pool *peerPool) {
+	client := newInboundClient(conn, remote, t.PeerID, t.InfoHash, nil)
+	p := client.peer
+
+	// The bitfield is optional if we don't have any piece
+	err := client.SendPieces(up.bitfield(), len(t.PieceHashes))
+	if err != nil {
+		log.Warn().Msgf("failed to send bitfield to peer %s, err: %s", p, err)
+		conn.Close()
+		return
+	}
+
+	err = client.recieveBitfield(len(t.PieceHashes))
+	if err != nil {
+		log.Warn().Msgf("failed to receive bitfield from peer %s, err: %s", p, err)
+		conn.Close()
+		return
+	}
+
+	t.runDownloadWorker(client, picker, results, up, pool)
+}
+
+// runDownloadWorker downloads the pieces from the picker using a client
+// The client is closed when the worker stops
+func (t *Torrent) runDownloadWorker(client *Client, picker *piecePicker, results chan *pieceResult, up *uploader, pool *peerPool) {
+	peer := client.peer
+	defer client.Close()
+	client.startUploads(up)
+	t.startExtensions(client, pool)
+
 	// Send unchoke
-	err = client.SendUnchoke()
+	err := client.SendUnchoke()
 	if err != nil {
 		log.Warn().Msgf("failed to send unchoke to peer %s, err: %s", peer, err)
 		return
@@ -67,30 +124,64 @@ func (t *Torrent) startDownloadWorker(peer peer.Peer, workQueue chan *pieceWork,
 		return
 	}
 
-	for work := range workQueue {
+	// The pieces of the peer are counted while it's downloading
+	// Peers on the local network are preferred by the picker
+	local := isLocalPeer(peer)
+	picker.addPeer(client.Bitfield)
+	client.picker = picker
+	defer func() {
+		picker.removePeer(client.Bitfield)
+	}()
+	client.startReader()
+
+	for {
+		work, changed, ok := picker.next(client.Bitfield, local)
+		if !ok {
+			return
+		}
+
+		// Keep handling the peer until there's a piece to download from it
+		if work == nil {
+			err := client.idle(changed)
+			if err != nil {
+				log.Warn().Msgf("Peer %s disconnected while idle, err: %s", peer, err)
+				return
+			}
+			continue
+		}
+
 		// Check if the client has been banned before new work
 		if client.banned {
 			log.Error().Msgf("peer %s has been banned", peer)
-			workQueue <- work
+			picker.giveBack(work, local)
 			return
 		}
 
 		// Create a new state for that piece work
 		state := pieceState{
-			work:   work,
-			client: client,
-			buf:    make([]byte, work.length),
+			work:        work,
+			client:      client,
+			picker:      picker,
+			buf:         make([]byte, work.length),
+			outstanding: map[int]int{},
 		}
 
 		err := state.processPiece()
+		if errors.Is(err, errPieceFinished) {
+			log.Debug().Msgf("Piece #%d finished by another peer, canceled the requests to %s", work.index, peer)
+			picker.giveBack(work, local)
+			continue
+		}
 		if err != nil {
 			log.Warn().Msgf("Error when processing piece %v, err: %s", work.index, err)
 			// If any error happens we can try the work again
 			state = pieceState{}
-			workQueue <- work
+			picker.giveBack(work, local)
 			continue
 		}
 
+		picker.finish(work, local)
+
 		// Send that now we have that piece
 		err = client.SendHave(work.index)
 		if err != nil {
@@ -106,6 +197,21 @@ func (t *Torrent) startDownloadWorker(peer peer.Peer, workQueue chan *pieceWork,
 	}
 }
 
+// idle handles the messages of a peer without a piece to download
+// Returns once a message is handled or the picker changes
+func (c *Client) idle(changed <-chan struct{}) error {
+	select {
+	case <-changed:
+		return nil
+	case msg, ok := <-c.messages:
+		if !ok {
+			return c.readErr
+		}
+		defer c.release(msg)
+		return c.handleMessage(msg)
+	}
+}
+
 // checkWorkHash takes a single buf and check it's sha1 hash against
 // expected work hash
 func checkWorkHash(work *pieceWork, buf []byte) error {
@@ -120,48 +226,150 @@ func checkWorkHash(work *pieceWork, buf []byte) error {
 // Download downloads a torrent
 func (t *Torrent) Download(path string) error {
 	log.Info().Msg("Starting download")
-	log.Info().Msgf("Total available peers: %v", len(t.Peers))
+	log.Info().Msgf("Total initial peers: %v", len(t.Peers))
+
+	// Viper config
+	resumeInterval := viper.GetDuration("download.resume_interval")
 
-	filePath := fmt.Sprintf("%s/%s", path, t.Name)
+	// Create the files for the torrent, keeping the existing data
+	storage, err := t.createStorage(path)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	up := newUploader(t, storage)
 
-	// Create a new work queue and result that are shared between peers
-	workQueue := make(chan *pieceWork, len(t.PieceHashes))
+	// Create a new piece picker and result that are shared between peers
+	// Only the pieces missing from the existing data are picked
+	verified := t.loadVerifiedPieces(storage)
+	works := []*pieceWork{}
 	results := make(chan *pieceResult)
+	donePieces := 0
+	verifiedBytes := 0
 	for index, hash := range t.PieceHashes {
 		length := t.calculatePieceSize(index)
-		workQueue <- &pieceWork{
+		if verified.HasPiece(index) {
+			up.markPiece(index)
+			donePieces++
+			verifiedBytes += length
+			continue
+		}
+
+		works = append(works, &pieceWork{
 			index:  index,
 			hash:   hash,
 			length: length,
-		}
+		})
 	}
+	picker := newPiecePicker(len(t.PieceHashes), works)
+	defer picker.close()
+	log.Info().Msgf("Resuming with %d of %d pieces", donePieces, len(t.PieceHashes))
 
-	// Create a new file
-	file, err := filesystem.CreateFileWithSize(filePath, int64(t.Length))
-	if err != nil {
-		return err
+	// Save the progress when leaving
+	defer func() {
+		t.saveResume(storage, up.bitfield())
+	}()
+	if donePieces == len(t.PieceHashes) {
+		log.Info().Msg("All pieces are already downloaded")
+		return nil
 	}
-	defer file.Close()
 
 	// Start the workers, one per each peer
-	for _, peer := range t.Peers {
-		// Errors are expected when downloading for peers
-		// We can ignore them on lint
-		go t.startDownloadWorker(peer, workQueue, results)
+	// Peers are tracked so new announces don't start duplicated workers
+	pool := newPeerPool()
+	knownPeers := map[string]bool{}
+	startWorkers := func(peers []peer.Peer) {
+		for _, peer := range peers {
+			if knownPeers[peer.String()] {
+				continue
+			}
+			knownPeers[peer.String()] = true
+
+			// Errors are expected when downloading for peers
+			// We can ignore them on lint
+			go t.startDownloadWorker(peer, picker, results, up, pool)
+		}
+	}
+	startWorkers(t.Peers)
+
+	// Accept the incoming peers with the same workers
+	port := t.acceptPeers(func(conn net.Conn, remote *handshake.Handshake) {
+		t.acceptDownloadPeer(conn, remote, picker, results, up, pool)
+	})
+	defer t.stopAcceptingPeers()
+
+	// Keep announcing to the trackers while downloading
+	var downloaded atomic.Int64
+	var announcer *tracker.Announcer
+	var newPeers <-chan []peer.Peer
+	if t.hasTrackers() {
+		announcer = tracker.NewAnnouncer(t.Trackers, t.InfoHash, t.PeerID, port, func() tracker.Stats {
+			return tracker.Stats{
+				Uploaded:   up.uploaded.Load(),
+				Downloaded: downloaded.Load(),
+				Left:       int64(t.Length-verifiedBytes) - downloaded.Load(),
+			}
+		})
+		announcer.SetIPv6(announceIPv6())
+		announcer.Start()
+		defer announcer.Stop()
+		newPeers = announcer.Peers()
 	}
 
+	// Look for more peers on the DHT
+	stopDHT := make(chan struct{})
+	defer close(stopDHT)
+	dhtPeers := t.announceDHT(port, stopDHT)
+
+	// Look for peers on the local network
+	stopLSD := make(chan struct{})
+	defer close(stopLSD)
+	localPeers := t.announceLSD(port, stopLSD)
+
 	// Collect results
-	donePieces := 0
+	lastSave := time.Now()
 	// Keep iterating until we are done with the pieces
 	for donePieces < len(t.PieceHashes) {
+		// Local peers are started before any other peer or result
+		select {
+		case peers := <-localPeers:
+			startWorkers(peers)
+			continue
+		default:
+		}
+
+		var res *pieceResult
+		select {
+		case peers := <-localPeers:
+			startWorkers(peers)
+			continue
+		case peers := <-newPeers:
+			startWorkers(peers)
+			continue
+		case peers := <-dhtPeers:
+			startWorkers(peers)
+			continue
+		case peers := <-pool.discovered:
+			startWorkers(peers)
+			continue
+		case res = <-results:
+		}
+
 		// Take the result, calculate the boundaries and safe on the buf
-		res := <-results
 		begin, _ := t.calculateBoundsForPiece(res.index)
-		err := filesystem.WriteFileChunk(file, res.buf, int64(begin))
+		err := storage.WriteAt(res.buf, int64(begin))
 		if err != nil {
 			return err
 		}
 		donePieces++
+		downloaded.Add(int64(len(res.buf)))
+		up.markPiece(res.index)
+
+		// Save the progress from time to time
+		if time.Since(lastSave) > resumeInterval {
+			t.saveResume(storage, up.bitfield())
+			lastSave = time.Now()
+		}
 
 		// Log to user
 		percent := float64(donePieces) / float64(len(t.PieceHashes)) * 100
@@ -170,12 +378,101 @@ func (t *Torrent) Download(path string) error {
 		log.Info().Msgf("(%0.2f%%) Downloaded piece #%d from %d peers, missing %v from %v pieces", percent, res.index, numWorkers, missingPieces, len(t.PieceHashes))
 	}
 
-	close(workQueue)
+	// The completed event is sent before the stopped one
+	if announcer != nil {
+		announcer.Complete()
+	}
 
 	// Return the final buffer
 	return nil
 }
 
+// startExtensions registers the torrent extensions and sends our extended handshake
+// Private torrents only take peers from the trackers, so they don't exchange peers
+func (t *Torrent) startExtensions(client *Client, pool *peerPool) {
+	// The torrent extensions never fail on the peer handshake
+	if len(t.Info) > 0 {
+		client.RegisterExtension(newMetadataExtension(t.Info)) //nolint:errcheck
+	}
+	if !t.Private {
+		client.RegisterExtension(newPexExtension(client, pool)) //nolint:errcheck
+	}
+
+	if !client.extensions {
+		return
+	}
+	err := client.SendExtendedHandshake()
+	if err != nil {
+		log.Warn().Msgf("failed to send extended handshake to peer %s, err: %s", client.peer, err)
+	}
+}
+
+// hasTrackers returns if the torrent has any tracker to announce
+func (t *Torrent) hasTrackers() bool {
+	return t.Trackers != nil && len(t.Trackers.Tiers()) > 0
+}
+
+// createStorage creates the storage with all the torrent files under the path
+func (t *Torrent) createStorage(path string) (*filesystem.Storage, error) {
+	return filesystem.NewStorage(t.storageEntries(path))
+}
+
+// acceptPeers routes the incoming connections for the torrent to a handler
+// Returns the port to be announced
+func (t *Torrent) acceptPeers(handler inboundHandler) uint16 {
+	listener, err := DefaultListener()
+	if err != nil {
+		log.Warn().Msgf("not accepting incoming peers, err: %s", err)
+		return listenPort()
+	}
+
+	listener.Register(t.InfoHash, t.PeerID, handler)
+	return listener.Port()
+}
+
+// stopAcceptingPeers stops routing the incoming connections for the torrent
+func (t *Torrent) stopAcceptingPeers() {
+	listener, err := DefaultListener()
+	if err != nil {
+		return
+	}
+	listener.Unregister(t.InfoHash)
+}
+
+// openStorage opens the existing torrent files under the path as read only
+func (t *Torrent) openStorage(path string) (*filesystem.Storage, error) {
+	return filesystem.OpenStorage(t.storageEntries(path))
+}
+
+// storageEntries builds the storage entries for the torrent files under the path
+func (t *Torrent) storageEntries(path string) []filesystem.FileEntry {
+	entries := make([]filesystem.FileEntry, len(t.Files))
+	for i, file := range t.Files {
+		entries[i] = filesystem.FileEntry{
+			Path:   filepath.Join(append([]string{path}, file.Path...)...),
+			Length: int64(file.Length),
+			Offset: int64(file.Offset),
+		}
+	}
+	return entries
+}
+
+// verifyPiece reads a piece from the storage and checks its hash
+func (t *Torrent) verifyPiece(storage *filesystem.Storage, index int) error {
+	begin, end := t.calculateBoundsForPiece(index)
+	buf := make([]byte, end-begin)
+	err := storage.ReadAt(buf, int64(begin))
+	if err != nil {
+		return err
+	}
+
+	return checkWorkHash(&pieceWork{
+		index:  index,
+		hash:   t.PieceHashes[index],
+		length: len(buf),
+	}, buf)
+}
+
 // calculatedPieceSize calculated a piece size for a index
 func (t Torrent) calculatePieceSize(index int) int {
 	begin, end := t.calculateBoundsForPiece(index)
@@ -0,0 +1,199 @@
+package client_test
+
+import (
+	"bytes"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/message"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestDownloadLateHave tests a peer that only announces its pieces after the bitfield
+func TestDownloadLateHave(t *testing.T) {
+	torrent, data := downloadTorrent(t, 20000)
+
+	torrent.Peers = []peer.Peer{fakeDownloadPeer(t, torrent.InfoHash, func(conn net.Conn) {
+		// The peer starts without any piece
+		empty := message.NewMessage(message.MsgBitfield, client.NewBitfield(len(torrent.PieceHashes)))
+		conn.Write(empty.Serialize()) //nolint:errcheck
+
+		// The pieces are announced while the worker is waiting for one
+		time.Sleep(200 * time.Millisecond)
+		unchoke := message.NewMessage(message.MsgUnchoke, nil)
+		conn.Write(unchoke.Serialize()) //nolint:errcheck
+		for index := range torrent.PieceHashes {
+			have := message.NewHaveMessage(index)
+			conn.Write(have.Serialize()) //nolint:errcheck
+		}
+
+		serveBlocks(conn, data, torrent.PieceLength)
+	})}
+
+	requireDownload(t, torrent, t.TempDir(), data)
+}
+
+// TestDownloadEndgameCancel tests that a stalled peer on the endgame gets the cancels
+// once the blocks are delivered by another peer
+func TestDownloadEndgameCancel(t *testing.T) {
+	torrent, data := downloadTorrent(t, 16380)
+	// A single piece with two blocks
+	viper.Set("download.block_size", 8192)
+
+	full := message.NewMessage(message.MsgBitfield, client.NewFullBitfield(len(torrent.PieceHashes)))
+	unchoke := message.NewMessage(message.MsgUnchoke, nil)
+
+	// The stalled peer never answers, the other peer waits for its requests
+	requested := make(chan struct{})
+	cancels := make(chan int, 2)
+	stalled := fakeDownloadPeer(t, torrent.InfoHash, func(conn net.Conn) {
+		conn.Write(full.Serialize())    //nolint:errcheck
+		conn.Write(unchoke.Serialize()) //nolint:errcheck
+
+		nRequests := 0
+		for {
+			msg, err := message.Unmarshal(conn)
+			if err != nil {
+				return
+			}
+			switch msg.ID {
+			case message.MsgRequest:
+				nRequests++
+				if nRequests == 2 {
+					close(requested)
+				}
+			case message.MsgCancel:
+				_, begin, _, err := message.ParseRequest(msg)
+				if err != nil {
+					return
+				}
+				cancels <- begin
+			}
+		}
+	})
+	seed := fakeDownloadPeer(t, torrent.InfoHash, func(conn net.Conn) {
+		conn.Write(full.Serialize())    //nolint:errcheck
+		conn.Write(unchoke.Serialize()) //nolint:errcheck
+		<-requested
+		serveBlocks(conn, data, torrent.PieceLength)
+	})
+	torrent.Peers = []peer.Peer{stalled, seed}
+
+	requireDownload(t, torrent, t.TempDir(), data)
+
+	// The download deadline is far, the cancels come from the delivered blocks
+	canceled := []int{}
+	for len(canceled) < 2 {
+		select {
+		case begin := <-cancels:
+			canceled = append(canceled, begin)
+		case <-time.After(time.Second):
+			require.FailNow(t, "cancel not sent to the stalled peer")
+		}
+	}
+	require.ElementsMatch(t, []int{0, 8192}, canceled)
+}
+
+// downloadTorrent creates a single file torrent with the data of the given length
+func downloadTorrent(t *testing.T, length int) (client.Torrent, []byte) {
+	viper.Set("peers.timeout", "2s")
+	viper.Set("peers.listen_host", "127.0.0.1")
+	viper.Set("peers.listen_port", 0)
+	viper.Set("peers.encryption", "disabled")
+	viper.Set("peers.utp", false)
+	viper.Set("peers.max_retries", 10)
+	viper.Set("download.deadline", "10s")
+	viper.Set("download.block_size", 16384)
+	viper.Set("download.max_backlog", 10)
+	viper.Set("download.fast_resume", false)
+	viper.Set("download.resume_interval", "30s")
+	viper.Set("dht.enabled", false)
+	viper.Set("lsd.enabled", false)
+
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("go-torrent"), length/10)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.bin"), data, 0o644))
+	created, err := bencode.Create(filepath.Join(dir, "data.bin"), bencode.CreateOptions{PieceLength: 16384})
+	require.NoError(t, err)
+
+	torrentPath := filepath.Join(dir, "data.torrent")
+	require.NoError(t, os.WriteFile(torrentPath, created, 0o644))
+
+	torrent, err := client.TorrentFromTorrentFile(torrentPath)
+	require.NoError(t, err)
+	return torrent, data
+}
+
+// requireDownload downloads the torrent into the dir and checks the downloaded data
+func requireDownload(t *testing.T, torrent client.Torrent, dir string, data []byte) {
+	done := make(chan error, 1)
+	go func() {
+		done <- torrent.Download(dir)
+	}()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "download timed out")
+	}
+
+	downloaded, err := os.ReadFile(filepath.Join(dir, "data.bin"))
+	require.NoError(t, err)
+	require.Equal(t, data, downloaded)
+}
+
+// fakeDownloadPeer starts a peer that answers the handshake and runs a script
+func fakeDownloadPeer(t *testing.T, infoHash handshake.Hash, script func(conn net.Conn)) peer.Peer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		if _, err := handshake.Unmarshal(conn); err != nil {
+			return
+		}
+		res := handshake.NewHandshake(handshake.PeerID{3}, infoHash)
+		conn.Write(res.Marshal()) //nolint:errcheck
+		script(conn)
+	}()
+
+	addr := listener.Addr().(*net.TCPAddr)
+	return peer.Peer{IP: addr.IP, Port: uint16(addr.Port)}
+}
+
+// serveBlocks answers the requests with the blocks of the data until the connection closes
+func serveBlocks(conn net.Conn, data []byte, pieceLength int) {
+	for {
+		msg, err := message.Unmarshal(conn)
+		if err != nil {
+			return
+		}
+		if msg.ID != message.MsgRequest {
+			continue
+		}
+
+		index, begin, length, err := message.ParseRequest(msg)
+		if err != nil {
+			return
+		}
+		offset := index*pieceLength + begin
+		block := message.NewPieceMessage(index, begin, data[offset:offset+length])
+		conn.Write(block.Serialize()) //nolint:errcheck
+	}
+}
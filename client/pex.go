@@ -0,0 +1,258 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+const (
+	// pexInterval is the min time between two ut_pex messages to a peer
+	pexInterval = time.Minute
+	// discoveredBuffer is the number of pending discovered peer lists
+	discoveredBuffer = 16
+)
+
+// pooledPeer is a connected peer that can be shared with the swarm
+type pooledPeer struct {
+	peer  peer.Peer
+	flags byte
+}
+
+// peerPool has the connected peers of a torrent
+// Peers learned from the swarm are sent on the discovered channel
+type peerPool struct {
+	mu        sync.Mutex
+	connected map[string]pooledPeer
+
+	discovered chan []peer.Peer
+}
+
+// newPeerPool returns a new empty pool
+func newPeerPool() *peerPool {
+	return &peerPool{
+		connected:  map[string]pooledPeer{},
+		discovered: make(chan []peer.Peer, discoveredBuffer),
+	}
+}
+
+// add flags a peer as connected
+func (p *peerPool) add(connected peer.Peer, flags byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected[connected.String()] = pooledPeer{peer: connected, flags: flags}
+}
+
+// remove flags a peer as disconnected
+func (p *peerPool) remove(disconnected peer.Peer) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.connected, disconnected.String())
+}
+
+// peers returns a copy of the connected peers
+func (p *peerPool) peers() map[string]pooledPeer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	peers := make(map[string]pooledPeer, len(p.connected))
+	for key, connected := range p.connected {
+		peers[key] = connected
+	}
+	return peers
+}
+
+// discover sends the peers learned from the swarm
+// The peers are dropped if nobody is reading them
+func (p *peerPool) discover(peers []peer.Peer) {
+	select {
+	case p.discovered <- peers:
+	default:
+	}
+}
+
+// pexExtension exchanges the peers from the pool with a single client
+// More information can be found on https://www.bittorrent.org/beps/bep_0011.html
+type pexExtension struct {
+	pool    *peerPool
+	started sync.Once
+	closed  chan struct{}
+	once    sync.Once
+
+	mu         sync.Mutex
+	advertised *peer.Peer
+}
+
+// newPexExtension returns a new ut_pex extension sharing a pool
+// Outgoing clients are added to the pool right away
+func newPexExtension(c *Client, pool *peerPool) *pexExtension {
+	pex := &pexExtension{
+		pool:   pool,
+		closed: make(chan struct{}),
+	}
+	// Only outgoing peers have a known listen port
+	// Incoming peers are shared after they tell us their port
+	if !c.inbound {
+		pex.advertise(c.peer, transportFlags(c)|extension.PexOutgoing)
+	}
+	return pex
+}
+
+// Name returns the extension name
+func (pex *pexExtension) Name() string {
+	return extension.ExtPex
+}
+
+// Extend doesn't add any key to the handshake
+func (pex *pexExtension) Extend(h *extension.Handshake) {}
+
+// Handshake starts sending the pool to peers supporting ut_pex
+func (pex *pexExtension) Handshake(c *Client, h *extension.Handshake) error {
+	if c.inbound && h.Port > 0 && h.Port <= 65535 {
+		pex.advertise(peer.Peer{IP: c.peer.IP, Port: uint16(h.Port)}, transportFlags(c))
+	}
+
+	if c.SupportsExtension(extension.ExtPex) {
+		pex.started.Do(func() {
+			go pex.sendLoop(c)
+		})
+	}
+	return nil
+}
+
+// Handle sends the peers added by the remote peer to the pool
+// Invalid messages are ignored
+func (pex *pexExtension) Handle(c *Client, payload []byte) error {
+	msg, err := extension.UnmarshalPex(payload)
+	if err != nil {
+		log.Debug().Msgf("ignoring pex message from peer %s, err: %s", c.peer, err)
+		return nil
+	}
+	added, err := msg.AddedPeers()
+	if err != nil {
+		log.Debug().Msgf("ignoring pex message from peer %s, err: %s", c.peer, err)
+		return nil
+	}
+
+	if len(added) > extension.MaxPexPeers {
+		added = added[:extension.MaxPexPeers]
+	}
+	if len(added) > 0 {
+		log.Debug().Msgf("Received %d peers from peer %s", len(added), c.peer)
+		pex.pool.discover(added)
+	}
+	return nil
+}
+
+// Close stops the exchange and removes the client from the pool
+func (pex *pexExtension) Close() {
+	pex.once.Do(func() {
+		pex.mu.Lock()
+		if pex.advertised != nil {
+			pex.pool.remove(*pex.advertised)
+		}
+		pex.mu.Unlock()
+		close(pex.closed)
+	})
+}
+
+// advertise adds the client to the pool with the address other peers can use
+func (pex *pexExtension) advertise(p peer.Peer, flags byte) {
+	pex.mu.Lock()
+	defer pex.mu.Unlock()
+
+	if pex.advertised != nil {
+		return
+	}
+	pex.advertised = &p
+	pex.pool.add(p, flags)
+}
+
+// sendLoop sends the changes on the pool to the peer
+// The connected peers are sent right after the handshake,
+// the later changes at most once per interval
+func (pex *pexExtension) sendLoop(c *Client) {
+	ticker := time.NewTicker(pexInterval)
+	defer ticker.Stop()
+
+	sent := map[string]pooledPeer{}
+	for {
+		err := pex.sendChanges(c, sent)
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-pex.closed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendChanges sends the peers added and dropped from the pool since the last message
+// Only the errors sending the message are returned
+func (pex *pexExtension) sendChanges(c *Client, sent map[string]pooledPeer) error {
+	// The peer itself is never sent back
+	current := pex.pool.peers()
+	delete(current, c.peer.String())
+	pex.mu.Lock()
+	if pex.advertised != nil {
+		delete(current, pex.advertised.String())
+	}
+	pex.mu.Unlock()
+
+	added, flags, dropped := diffPeers(sent, current)
+	if len(added) == 0 && len(dropped) == 0 {
+		return nil
+	}
+
+	payload, err := extension.NewPexMessage(added, flags, dropped).Marshal()
+	if err != nil {
+		log.Warn().Msgf("failed to build pex message, err: %s", err)
+		return nil
+	}
+	return c.SendExtended(extension.ExtPex, payload)
+}
+
+// diffPeers returns the peers added and dropped since the last message
+// The sent peers are updated with the changes, up to the max per message
+func diffPeers(sent, current map[string]pooledPeer) ([]peer.Peer, []byte, []peer.Peer) {
+	added := []peer.Peer{}
+	flags := []byte{}
+	for key, connected := range current {
+		if len(added) >= extension.MaxPexPeers {
+			break
+		}
+		if _, ok := sent[key]; ok {
+			continue
+		}
+		added = append(added, connected.peer)
+		flags = append(flags, connected.flags)
+		sent[key] = connected
+	}
+
+	dropped := []peer.Peer{}
+	for key, disconnected := range sent {
+		if len(dropped) >= extension.MaxPexPeers {
+			break
+		}
+		if _, ok := current[key]; ok {
+			continue
+		}
+		dropped = append(dropped, disconnected.peer)
+		delete(sent, key)
+	}
+
+	return added, flags, dropped
+}
+
+// transportFlags returns the pex flags of the client connection
+func transportFlags(c *Client) byte {
+	if isUTP(c.Conn) {
+		return extension.PexUTP
+	}
+	return 0
+}
@@ -0,0 +1,43 @@
+package client_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/extension"
+	"github.com/jhelison/go-torrent/marshallers/message"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestPexInitialPeers tests that the connected peers are sent right after the handshake
+func TestPexInitialPeers(t *testing.T) {
+	conn, remote := net.Pipe()
+	defer conn.Close()
+	defer remote.Close()
+
+	self := peer.Peer{IP: net.IPv4(10, 0, 0, 1), Port: 6881}
+	pooled := peer.Peer{IP: net.IPv4(10, 0, 0, 2), Port: 6881}
+	stop, err := client.StartPex(conn, self, []peer.Peer{pooled})
+	require.NoError(t, err)
+	defer stop()
+
+	// The first message doesn't wait for the interval
+	require.NoError(t, remote.SetReadDeadline(time.Now().Add(time.Second)))
+	msg, err := message.Unmarshal(remote)
+	require.NoError(t, err)
+	require.Equal(t, message.MsgExtended, msg.ID)
+
+	id, payload, err := extension.ParseMessage(msg)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, id)
+	pex, err := extension.UnmarshalPex(payload)
+	require.NoError(t, err)
+	added, err := pex.AddedPeers()
+	require.NoError(t, err)
+	require.Len(t, added, 1)
+	require.Equal(t, pooled.String(), added[0].String())
+}
@@ -0,0 +1,366 @@
+package client
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// piecePicker assigns the missing pieces to the download workers
+// Each peer gets the rarest piece it has, ties are randomized
+// The availability is tracked from the bitfields and have messages of the peers
+//
+// Once every block of the missing pieces is requested the picker enters the endgame,
+// the pieces are also given to the idle peers and the first to deliver each block wins
+//
+// Peers on the local network are preferred, without pending pieces they also
+// download the pieces that only remote peers are downloading
+type piecePicker struct {
+	mu sync.Mutex
+	// pending are the pieces waiting for a peer, indexed by piece
+	pending  []*pieceWork
+	nPending int
+	// active are the pieces being downloaded and downloaders the workers on each,
+	// localDownloaders are the workers on the local network
+	active           map[int]*pieceWork
+	downloaders      []int
+	localDownloaders []int
+	// blocks are the blocks requested and received for the active pieces
+	// and unrequested the bytes of them not requested by any peer
+	blocks       map[int]*pieceBlocks
+	unrequested  int
+	finished     []bool
+	availability []int
+	closed       bool
+	// changed is closed and replaced when pieces are given back, new pieces
+	// are counted or on the endgame
+	changed chan struct{}
+}
+
+// pieceBlocks are the blocks requested and received for a piece from any peer
+type pieceBlocks struct {
+	buf         []byte
+	requested   map[int]bool
+	unrequested int
+	received    map[int]bool
+	missing     int
+	// resets are the times the piece failed the hash check
+	resets int
+	// changed is closed and replaced when a block is received,
+	// the piece is finished or reset
+	changed chan struct{}
+}
+
+// newPiecePicker creates a picker for the missing pieces of a torrent
+func newPiecePicker(nPieces int, works []*pieceWork) *piecePicker {
+	p := &piecePicker{
+		pending:          make([]*pieceWork, nPieces),
+		nPending:         len(works),
+		active:           map[int]*pieceWork{},
+		downloaders:      make([]int, nPieces),
+		localDownloaders: make([]int, nPieces),
+		blocks:           map[int]*pieceBlocks{},
+		finished:         make([]bool, nPieces),
+		availability:     make([]int, nPieces),
+		changed:          make(chan struct{}),
+	}
+	for _, work := range works {
+		p.pending[work.index] = work
+	}
+	return p
+}
+
+// addPeer counts the pieces of a new peer
+func (p *piecePicker) addPeer(bf Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count(bf, 1)
+	p.notify()
+}
+
+// removePeer stops counting the pieces of a peer that left
+func (p *piecePicker) removePeer(bf Bitfield) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count(bf, -1)
+}
+
+// have counts a new piece from a have message
+// The idle workers are woken, since the piece may be new to its peer
+func (p *piecePicker) have(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if index >= 0 && index < len(p.availability) {
+		p.availability[index]++
+		p.notify()
+	}
+}
+
+// count adds a delta to the availability of the pieces on a bitfield
+func (p *piecePicker) count(bf Bitfield, delta int) {
+	for index := range p.availability {
+		if bf.HasPiece(index) {
+			p.availability[index] += delta
+		}
+	}
+}
+
+// next returns the rarest pending piece on the bitfield
+// On the endgame the pieces being downloaded by other peers are also picked,
+// local peers also pick the pieces without other local peers before it
+// Without a piece it returns a channel closed once the pieces change,
+// so the worker can keep handling its peer while waiting
+// Returns false once the picker is closed
+func (p *piecePicker) next(bf Bitfield, local bool) (*pieceWork, <-chan struct{}, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return nil, nil, false
+	}
+
+	work := p.rarest(bf)
+	if work != nil {
+		p.pending[work.index] = nil
+		p.nPending--
+		p.active[work.index] = work
+		p.addDownloader(work.index, local)
+		p.blocks[work.index] = &pieceBlocks{
+			buf:         make([]byte, work.length),
+			requested:   map[int]bool{},
+			unrequested: work.length,
+			received:    map[int]bool{},
+			missing:     work.length,
+			changed:     make(chan struct{}),
+		}
+		p.unrequested += work.length
+		return work, nil, true
+	}
+
+	// All the blocks are requested, request them again from this peer
+	if p.endgame() {
+		work = p.duplicate(bf, false)
+		if work != nil {
+			p.addDownloader(work.index, local)
+			log.Debug().Msgf("Endgame, downloading piece #%d from %d peers", work.index, p.downloaders[work.index])
+			return work, nil, true
+		}
+	}
+
+	// Local peers don't wait for the endgame to help the remote peers
+	if local {
+		work = p.duplicate(bf, true)
+		if work != nil {
+			p.addDownloader(work.index, local)
+			log.Debug().Msgf("Downloading piece #%d from a local peer", work.index)
+			return work, nil, true
+		}
+	}
+
+	// Wait for a piece to be given back, a new piece or the endgame
+	return nil, p.changed, true
+}
+
+// rarest returns the pending piece on the bitfield with the lowest availability
+// The ties are randomized, so the peers don't download the same pieces
+func (p *piecePicker) rarest(bf Bitfield) *pieceWork {
+	var rarest *pieceWork
+	ties := 0
+	for index, work := range p.pending {
+		if work == nil || !bf.HasPiece(index) {
+			continue
+		}
+
+		switch {
+		case rarest == nil || p.availability[index] < p.availability[rarest.index]:
+			rarest = work
+			ties = 1
+		case p.availability[index] == p.availability[rarest.index]:
+			// Each tie has the same chance of being picked
+			ties++
+			if rand.Intn(ties) == 0 {
+				rarest = work
+			}
+		}
+	}
+	return rarest
+}
+
+// duplicate returns the active piece on the bitfield with the fewest downloaders
+// remoteOnly skips the pieces already downloaded by local peers
+// The ties are randomized, so the duplicates are spread between the pieces
+func (p *piecePicker) duplicate(bf Bitfield, remoteOnly bool) *pieceWork {
+	var fewest *pieceWork
+	ties := 0
+	for index, work := range p.active {
+		if !bf.HasPiece(index) || p.blocks[index].missing == 0 {
+			continue
+		}
+		if remoteOnly && p.localDownloaders[index] > 0 {
+			continue
+		}
+
+		switch {
+		case fewest == nil || p.downloaders[index] < p.downloaders[fewest.index]:
+			fewest = work
+			ties = 1
+		case p.downloaders[index] == p.downloaders[fewest.index]:
+			ties++
+			if rand.Intn(ties) == 0 {
+				fewest = work
+			}
+		}
+	}
+	return fewest
+}
+
+// giveBack releases a piece that the worker stopped downloading
+// It's picked again if it isn't finished and no other peer is downloading it
+func (p *piecePicker) giveBack(work *pieceWork, local bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeDownloader(work.index, local)
+	if p.finished[work.index] || p.downloaders[work.index] > 0 {
+		return
+	}
+	// The received blocks are dropped, they may be from a invalid piece
+	p.unrequested -= p.blocks[work.index].unrequested
+	delete(p.active, work.index)
+	delete(p.blocks, work.index)
+	p.pending[work.index] = work
+	p.nPending++
+	p.notify()
+}
+
+// finish flags a downloaded and verified piece
+func (p *piecePicker) finish(work *pieceWork, local bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removeDownloader(work.index, local)
+	p.finished[work.index] = true
+	p.unrequested -= p.blocks[work.index].unrequested
+	// The other peers downloading the piece stop
+	close(p.blocks[work.index].changed)
+	delete(p.active, work.index)
+	delete(p.blocks, work.index)
+}
+
+// addDownloader counts a worker downloading a piece
+func (p *piecePicker) addDownloader(index int, local bool) {
+	p.downloaders[index]++
+	if local {
+		p.localDownloaders[index]++
+	}
+}
+
+// removeDownloader stops counting a worker downloading a piece
+func (p *piecePicker) removeDownloader(index int, local bool) {
+	p.downloaders[index]--
+	if local {
+		p.localDownloaders[index]--
+	}
+}
+
+// request flags a block of a active piece as requested from a peer
+func (p *piecePicker) request(index, begin, length int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	blocks, ok := p.blocks[index]
+	if !ok || blocks.requested[begin] {
+		return
+	}
+	blocks.requested[begin] = true
+	blocks.unrequested -= length
+	p.unrequested -= length
+
+	if p.endgame() {
+		// The idle workers can start the endgame
+		p.notify()
+	}
+}
+
+// endgame returns if every block of the missing pieces was requested
+func (p *piecePicker) endgame() bool {
+	return p.nPending == 0 && p.unrequested == 0
+}
+
+// reset drops the blocks of a piece that failed the hash check
+// The other peers downloading the piece request the blocks again
+func (p *piecePicker) reset(index int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	blocks, ok := p.blocks[index]
+	if !ok {
+		return
+	}
+	p.unrequested += len(blocks.buf) - blocks.unrequested
+	blocks.requested = map[int]bool{}
+	blocks.unrequested = len(blocks.buf)
+	blocks.received = map[int]bool{}
+	blocks.missing = len(blocks.buf)
+	blocks.resets++
+
+	close(blocks.changed)
+	blocks.changed = make(chan struct{})
+}
+
+// addBlock saves a block of a active piece received from a peer
+// The blocks already received from other peers are ignored
+// Returns the piece buffer once the last missing block is received
+func (p *piecePicker) addBlock(index, begin int, block []byte) ([]byte, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	blocks, ok := p.blocks[index]
+	if !ok || blocks.received[begin] {
+		return nil, false
+	}
+	copy(blocks.buf[begin:], block)
+	blocks.received[begin] = true
+	blocks.missing -= len(block)
+
+	// The other peers downloading the piece cancel the block
+	close(blocks.changed)
+	blocks.changed = make(chan struct{})
+	return blocks.buf, blocks.missing == 0
+}
+
+// hasBlock returns if a block of a piece was received from any peer
+func (p *piecePicker) hasBlock(index, begin int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	blocks, ok := p.blocks[index]
+	return !ok || blocks.received[begin]
+}
+
+// watch returns a channel closed once a block of the piece is received,
+// the times the piece was reset and if the piece is no longer downloaded
+// A piece with all the blocks is only done once its hash is checked
+func (p *piecePicker) watch(index int) (<-chan struct{}, int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	blocks, ok := p.blocks[index]
+	if !ok {
+		return nil, 0, true
+	}
+	return blocks.changed, blocks.resets, false
+}
+
+// close stops all the workers waiting for a piece
+func (p *piecePicker) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	p.notify()
+}
+
+// notify wakes the workers waiting for a piece
+func (p *piecePicker) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
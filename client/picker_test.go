@@ -0,0 +1,211 @@
+package client_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+)
+
+// bitfield returns a bitfield with the given pieces
+func bitfield(nPieces int, pieces ...int) client.Bitfield {
+	bf := client.NewBitfield(nPieces)
+	for _, index := range pieces {
+		bf.SetPiece(index)
+	}
+	return bf
+}
+
+// TestPickerRarest tests that each peer gets the rarest piece it has
+func TestPickerRarest(t *testing.T) {
+	nPieces := 5
+	peers := []client.Bitfield{
+		bitfield(nPieces, 0, 1, 2, 3),
+		bitfield(nPieces, 1, 2, 3),
+		bitfield(nPieces, 2, 3),
+	}
+	picker := client.NewPiecePicker(nPieces, 1, []int{0, 1, 2, 3, 4})
+	for _, bf := range peers {
+		picker.AddPeer(bf)
+	}
+	require.Equal(t, []int{1, 2, 3, 3, 0}, picker.Availability())
+
+	testCases := []struct {
+		name     string
+		bf       client.Bitfield
+		expected []int
+	}{
+		{
+			name:     "least available piece",
+			bf:       peers[0],
+			expected: []int{0},
+		},
+		{
+			name:     "rarest piece the peer has",
+			bf:       peers[1],
+			expected: []int{1},
+		},
+		{
+			name:     "ties are randomized",
+			bf:       peers[2],
+			expected: []int{2, 3},
+		},
+		{
+			name:     "no piece the peer has",
+			bf:       bitfield(nPieces),
+			expected: []int{-1},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			picked := map[int]bool{}
+			for i := 0; i < 100; i++ {
+				index := picker.Next(tc.bf, false)
+				require.Contains(t, tc.expected, index)
+				picked[index] = true
+				if index >= 0 {
+					picker.GiveBack(index, false)
+				}
+			}
+			require.Len(t, picked, len(tc.expected))
+		})
+	}
+}
+
+// TestPickerAvailability tests the availability counted from the peers
+func TestPickerAvailability(t *testing.T) {
+	nPieces := 3
+	picker := client.NewPiecePicker(nPieces, 1, []int{0, 1, 2})
+	first := bitfield(nPieces, 0, 1)
+	second := bitfield(nPieces, 1)
+
+	picker.AddPeer(first)
+	picker.AddPeer(second)
+	require.Equal(t, []int{1, 2, 0}, picker.Availability())
+
+	// The have messages count the new pieces, invalid indexes are ignored
+	picker.Have(2)
+	picker.Have(3)
+	picker.Have(-1)
+	require.Equal(t, []int{1, 2, 1}, picker.Availability())
+
+	picker.RemovePeer(first)
+	require.Equal(t, []int{0, 1, 1}, picker.Availability())
+	picker.RemovePeer(second)
+	require.Equal(t, []int{0, 0, 1}, picker.Availability())
+}
+
+// TestPickerEndgame tests that the endgame only starts once every block is requested
+func TestPickerEndgame(t *testing.T) {
+	nPieces := 2
+	full := client.NewFullBitfield(nPieces)
+	picker := client.NewPiecePicker(nPieces, 32768, []int{0, 1})
+	picker.AddPeer(full)
+	picker.AddPeer(full)
+
+	first := picker.Next(full, false)
+	second := picker.Next(full, false)
+	require.ElementsMatch(t, []int{0, 1}, []int{first, second})
+
+	// The pieces are taken, but some blocks weren't requested yet
+	require.Equal(t, -1, picker.Next(full, false))
+	picker.Request(first, 0, 16384)
+	picker.Request(first, 16384, 16384)
+	picker.Request(second, 0, 16384)
+	require.Equal(t, -1, picker.Next(full, false))
+
+	// Requesting a block again doesn't count twice
+	picker.Request(second, 0, 16384)
+	require.Equal(t, -1, picker.Next(full, false))
+
+	// With every block requested the pieces are duplicated
+	picker.Request(second, 16384, 16384)
+	duplicated := picker.Next(full, false)
+	require.Contains(t, []int{0, 1}, duplicated)
+
+	// A piece given back to the pending stops the endgame
+	picker.GiveBack(duplicated, false)
+	picker.GiveBack(duplicated, false)
+	require.Equal(t, duplicated, picker.Next(full, false))
+	require.Equal(t, -1, picker.Next(full, false))
+}
+
+// TestPickerReset tests that a piece failing the hash check is downloaded again
+// by the other peers, while a verified piece stops them
+func TestPickerReset(t *testing.T) {
+	nPieces := 1
+	full := client.NewFullBitfield(nPieces)
+	picker := client.NewPiecePicker(nPieces, 2, []int{0})
+	picker.AddPeer(full)
+	picker.AddPeer(full)
+
+	// Both peers download the piece on the endgame
+	require.Equal(t, 0, picker.Next(full, false))
+	picker.Request(0, 0, 1)
+	picker.Request(0, 1, 1)
+	require.Equal(t, 0, picker.Next(full, false))
+
+	// A complete piece isn't done until its hash is checked
+	require.False(t, picker.AddBlock(0, 0, []byte{1}))
+	require.True(t, picker.AddBlock(0, 1, []byte{2}))
+	changed, resets, done := picker.Watch(0)
+	require.Equal(t, 0, resets)
+	require.False(t, done)
+
+	// The failed piece is reset for the other peer
+	picker.Reset(0)
+	picker.GiveBack(0, false)
+	requireClosed(t, changed)
+	changed, resets, done = picker.Watch(0)
+	require.Equal(t, 1, resets)
+	require.False(t, done)
+
+	// The blocks are requested and received again
+	require.Equal(t, -1, picker.Next(full, false))
+	require.False(t, picker.AddBlock(0, 0, []byte{1}))
+	require.True(t, picker.AddBlock(0, 1, []byte{2}))
+
+	// A verified piece stops the other peers
+	picker.Finish(0, false)
+	requireClosed(t, changed)
+	_, _, done = picker.Watch(0)
+	require.True(t, done)
+}
+
+// TestPickerLocal tests that local peers help the remote peers before the endgame
+func TestPickerLocal(t *testing.T) {
+	nPieces := 2
+	full := client.NewFullBitfield(nPieces)
+	picker := client.NewPiecePicker(nPieces, 2, []int{0, 1})
+	picker.AddPeer(full)
+	picker.AddPeer(full)
+	picker.AddPeer(full)
+
+	// The remote peers take all the pieces
+	remote := picker.Next(full, false)
+	other := picker.Next(full, false)
+	require.ElementsMatch(t, []int{0, 1}, []int{remote, other})
+	require.Equal(t, -1, picker.Next(full, false))
+
+	// The local peer gets the pieces of the remote peers, but only once
+	local := picker.Next(full, true)
+	require.Contains(t, []int{0, 1}, local)
+	second := picker.Next(full, true)
+	require.ElementsMatch(t, []int{0, 1}, []int{local, second})
+	require.Equal(t, -1, picker.Next(full, true))
+
+	// Once the local peer leaves the piece can be taken again
+	picker.GiveBack(local, true)
+	require.Equal(t, local, picker.Next(full, true))
+}
+
+// requireClosed checks that a channel is closed
+func requireClosed(t *testing.T, ch <-chan struct{}) {
+	select {
+	case <-ch:
+	default:
+		require.FailNow(t, "channel not closed")
+	}
+}
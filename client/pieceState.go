@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,14 +10,24 @@ import (
 	"github.com/spf13/viper"
 )
 
+// errPieceFinished is returned when other peers delivered and verified the rest of the piece
+var errPieceFinished = errors.New("piece finished by another peer")
+
 // pieceState is the representation of the current progress of a single piece
+// The blocks are read into buf and saved on the picker,
+// buf is replaced by the full piece once this peer delivers the last block
 type pieceState struct {
-	client     *Client
-	buf        []byte
-	downloaded int
-	requested  int
-	backlog    int
-	work       *pieceWork
+	client    *Client
+	picker    *piecePicker
+	buf       []byte
+	completed bool
+	requested int
+	backlog   int
+	resets    int
+	rejected  []blockRequest
+	// outstanding are the requested blocks waiting for the data, by the offset
+	outstanding map[int]int
+	work        *pieceWork
 }
 
 // processPiece process a single piece and download it
@@ -38,6 +49,9 @@ func (state *pieceState) processPiece() error {
 
 	// Download piece from peers
 	err := state.downloadPiece()
+	if errors.Is(err, errPieceFinished) {
+		return err
+	}
 	if err != nil {
 		state.client.retries++
 		return fmt.Errorf("error downloading piece with peer %s, err: %s", state.client.peer, err)
@@ -46,6 +60,8 @@ func (state *pieceState) processPiece() error {
 	// Check the hash for the buf
 	err = checkWorkHash(state.work, state.buf)
 	if err != nil {
+		// The other peers on the piece download it again
+		state.picker.reset(state.work.index)
 		state.client.retries++
 		return fmt.Errorf("integrity validation failed, invalid index: %v", state.work.index)
 	}
@@ -57,83 +73,179 @@ func (state *pieceState) processPiece() error {
 func (state *pieceState) downloadPiece() error {
 	// Variables from configs
 	maxBlockSize := viper.GetInt("download.block_size")
-	maxBacklog := viper.GetInt("download.block_size")
+	maxBacklog := viper.GetInt("download.max_backlog")
 	deadline := viper.GetDuration("download.deadline")
 
 	// Set a deadline to skip stuck peers
-	err := state.client.Conn.SetDeadline(time.Now().Add(deadline))
-	if err != nil {
-		return err
-	}
-	// We can ignore the error on the defer
-	defer state.client.Conn.SetDeadline(time.Time{}) //nolint:errcheck
-
-	for state.downloaded < state.work.length {
-		// If choked we wait a bit
-		if state.client.Choked {
-			log.Trace().Msgf("Peer %d chocked, waiting a bit", state.client.peer)
-			time.Sleep(time.Second)
-		} else {
+	timeout := time.NewTimer(deadline)
+	defer timeout.Stop()
+
+	for {
+		// On the endgame other peers may deliver the blocks first
+		changed, resets, done := state.picker.watch(state.work.index)
+		if !done && resets != state.resets {
+			state.resetRequests(resets)
+		}
+		err := state.cancelReceived()
+		if err != nil {
+			return err
+		}
+		if state.completed {
+			return nil
+		}
+		if done {
+			return errPieceFinished
+		}
+
+		// If choked we wait for the unchoke
+		if state.client.canRequest(state.work.index) {
+			// Rejected blocks are requested again first
+			for state.backlog < maxBacklog && len(state.rejected) > 0 {
+				req := state.rejected[0]
+				err := state.client.SendRequest(req.index, req.begin, req.length)
+				if err != nil {
+					return err
+				}
+				state.rejected = state.rejected[1:]
+				state.outstanding[req.begin] = req.length
+				state.backlog++
+			}
+
 			// We can open request messages until we reach the max backlog
 			for state.backlog < maxBacklog && state.requested < state.work.length {
+				begin := state.requested
 				blockSize := maxBlockSize
 				// Last block may be shorter
-				if state.work.length-state.requested < blockSize {
-					blockSize = state.work.length - state.requested
+				if state.work.length-begin < blockSize {
+					blockSize = state.work.length - begin
+				}
+				state.requested += blockSize
+
+				// The blocks received from other peers or still waited are skipped
+				_, waiting := state.outstanding[begin]
+				if waiting || state.picker.hasBlock(state.work.index, begin) {
+					continue
 				}
 
 				// Request and create a new backlog
-				err := state.client.SendRequest(state.work.index, state.requested, blockSize)
+				err := state.client.SendRequest(state.work.index, begin, blockSize)
 				if err != nil {
 					return err
 				}
 
+				state.outstanding[begin] = blockSize
 				state.backlog++
-				state.requested += blockSize
+				state.picker.request(state.work.index, begin, blockSize)
 			}
 		}
 
-		// Read a message
+		// Read a message, or wake up when another peer delivers a block
 		// This can unchoke the client
-		err := state.readMessage()
+		select {
+		case msg, ok := <-state.client.messages:
+			if !ok {
+				return state.client.readErr
+			}
+			err := state.readMessage(msg)
+			if err != nil {
+				return err
+			}
+		case <-changed:
+		case <-timeout.C:
+			return fmt.Errorf("deadline of %s exceeded", deadline)
+		}
+	}
+}
+
+// resetRequests requests the blocks again after the piece failed the hash check
+// The outstanding requests are kept, the blocks are saved once they arrive
+func (state *pieceState) resetRequests(resets int) {
+	state.resets = resets
+	state.requested = 0
+	state.rejected = nil
+	for begin, length := range state.outstanding {
+		state.picker.request(state.work.index, begin, length)
+	}
+}
+
+// cancelReceived cancels the requests for the blocks received from other peers
+// The blocks that arrive after it are ignored
+func (state *pieceState) cancelReceived() error {
+	for begin, length := range state.outstanding {
+		if !state.picker.hasBlock(state.work.index, begin) {
+			continue
+		}
+		err := state.client.SendCancel(state.work.index, begin, length)
 		if err != nil {
 			return err
 		}
+		delete(state.outstanding, begin)
+		state.backlog--
 	}
 
+	rejected := state.rejected[:0]
+	for _, req := range state.rejected {
+		if !state.picker.hasBlock(req.index, req.begin) {
+			rejected = append(rejected, req)
+		}
+	}
+	state.rejected = rejected
 	return nil
 }
 
-// readMessage reads a message and update the pieceProgress state
-func (state *pieceState) readMessage() error {
-	// Read a message from the client
-	msg, err := state.client.Read()
-	if err != nil {
-		return err
-	}
+// readMessage handles a message and update the pieceProgress state
+// The messages that don't depend on the piece are handled by the client
+func (state *pieceState) readMessage(msg message.Message) error {
+	defer state.client.release(msg)
 
 	// Update the state based on the message id
 	switch msg.ID {
-	case message.MsgUnchoke:
-		state.client.Choked = false
-	case message.MsgChoke:
-		state.client.Choked = true
-	case message.MsgHave:
-		// If the message is have we parse it and update the bitfield with the index
-		index, err := message.ParseHave(msg)
-		if err != nil {
-			return err
-		}
-		state.client.Bitfield.SetPiece(index)
 	case message.MsgPiece:
 		// If we have a piece message we parse if and update the state with
 		// a new download complete and a smaller backlog
-		n, err := message.ParsePiece(state.work.index, state.buf, msg)
+		begin, n, err := message.ParsePieceBlock(state.work.index, state.buf, msg)
+		if errors.Is(err, message.ErrUnexpectedIndex) {
+			// Blocks from canceled pieces may still arrive
+			return nil
+		}
 		if err != nil {
 			return err
 		}
-		state.downloaded += n
+
+		// Only the requested blocks are counted
+		length, ok := state.outstanding[begin]
+		if !ok {
+			return nil
+		}
+		if n != length {
+			return fmt.Errorf("expected block of length %d at offset %d, got length %d", length, begin, n)
+		}
+		delete(state.outstanding, begin)
+		state.backlog--
+
+		// The peer delivering the last block gets the full piece
+		buf, complete := state.picker.addBlock(state.work.index, begin, state.buf[begin:begin+n])
+		if complete {
+			state.buf = buf
+			state.completed = true
+		}
+	case message.MsgRejectRequest:
+		// The rejected block frees a backlog slot and is requested again
+		if !state.client.fast {
+			return fmt.Errorf("unexpected fast message %v without the fast extension", msg.ID)
+		}
+		index, begin, length, err := message.ParseRequest(msg)
+		if err != nil {
+			return err
+		}
+		if index != state.work.index || state.outstanding[begin] != length {
+			return nil
+		}
+		delete(state.outstanding, begin)
 		state.backlog--
+		state.rejected = append(state.rejected, blockRequest{index: index, begin: begin, length: length})
+	default:
+		return state.client.handleMessage(msg)
 	}
 	return nil
 }
@@ -0,0 +1,94 @@
+package client
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"runtime"
+	"sync"
+
+	"github.com/jhelison/go-torrent/filesystem"
+
+	"github.com/spf13/viper"
+)
+
+// resumePath returns the path of the fast resume file for the torrent
+func (t *Torrent) resumePath() string {
+	return filepath.Join(viper.GetString("download.resume_path"), hex.EncodeToString(t.InfoHash[:])+".json")
+}
+
+// loadVerifiedPieces returns the pieces that are already on the storage
+// A fast resume file saved with the same file states skips the hash check
+func (t *Torrent) loadVerifiedPieces(storage *filesystem.Storage) Bitfield {
+	// Viper config
+	fastResume := viper.GetBool("download.fast_resume")
+
+	if fastResume {
+		data, err := filesystem.LoadResume(t.resumePath())
+		if err == nil {
+			states, err := storage.FileStates()
+			if err == nil && data.Matches(states) && len(data.Bitfield) == len(NewBitfield(len(t.PieceHashes))) {
+				log.Info().Msg("Using the fast resume data")
+				return data.Bitfield
+			}
+		}
+	}
+
+	log.Info().Msg("Checking the existing data")
+	bf := NewBitfield(len(t.PieceHashes))
+	for index, err := range t.hashCheckPieces(storage) {
+		if err == nil {
+			bf.SetPiece(index)
+		}
+	}
+	return bf
+}
+
+// saveResume saves the fast resume file with the current file states
+func (t *Torrent) saveResume(storage *filesystem.Storage, bf Bitfield) {
+	// Viper config
+	fastResume := viper.GetBool("download.fast_resume")
+	if !fastResume {
+		return
+	}
+
+	states, err := storage.FileStates()
+	if err != nil {
+		log.Warn().Msgf("failed to read the file states, err: %s", err)
+		return
+	}
+
+	err = filesystem.SaveResume(t.resumePath(), filesystem.ResumeData{
+		Bitfield: bf,
+		Files:    states,
+	})
+	if err != nil {
+		log.Warn().Msgf("failed to save the fast resume file, err: %s", err)
+	}
+}
+
+// hashCheckPieces checks the hash of all the pieces from the storage
+// The pieces are hashed in parallel, one worker per CPU
+// Returns a error per piece, nil for the valid ones
+func (t *Torrent) hashCheckPieces(storage *filesystem.Storage) []error {
+	errs := make([]error, len(t.PieceHashes))
+	indexes := make(chan int)
+
+	var wg sync.WaitGroup
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				errs[index] = t.verifyPiece(storage, index)
+			}
+		}()
+	}
+
+	for index := range t.PieceHashes {
+		indexes <- index
+	}
+	close(indexes)
+	wg.Wait()
+
+	return errs
+}
@@ -0,0 +1,104 @@
+package client_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/message"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestLoadVerifiedPieces tests when the fast resume file skips the hash check
+func TestLoadVerifiedPieces(t *testing.T) {
+	torrent, data := downloadTorrent(t, 40000)
+	viper.Set("download.fast_resume", true)
+	viper.Set("download.resume_path", t.TempDir())
+
+	// The second piece is missing from the data
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.bin")
+	partial := append([]byte{}, data...)
+	copy(partial[16384:32768], make([]byte, 16384))
+	require.NoError(t, os.WriteFile(path, partial, 0o644))
+
+	// Without a resume file the pieces are hashed
+	bf, err := torrent.LoadVerifiedPieces(dir)
+	require.NoError(t, err)
+	require.Equal(t, bitfield(3, 0, 2), bf)
+
+	// A stale resume file is trusted while the file is the same
+	require.NoError(t, torrent.SaveResume(dir, client.NewFullBitfield(3)))
+	bf, err = torrent.LoadVerifiedPieces(dir)
+	require.NoError(t, err)
+	require.Equal(t, client.NewFullBitfield(3), bf)
+
+	// A different modification time hashes the pieces again
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(-time.Hour)))
+	bf, err = torrent.LoadVerifiedPieces(dir)
+	require.NoError(t, err)
+	require.Equal(t, bitfield(3, 0, 2), bf)
+
+	// A different size hashes the pieces again
+	require.NoError(t, torrent.SaveResume(dir, client.NewFullBitfield(3)))
+	require.NoError(t, os.WriteFile(path, partial[:20000], 0o644))
+	bf, err = torrent.LoadVerifiedPieces(dir)
+	require.NoError(t, err)
+	require.Equal(t, bitfield(3, 0), bf)
+}
+
+// TestDownloadResume tests that only the missing pieces are downloaded
+func TestDownloadResume(t *testing.T) {
+	torrent, data := downloadTorrent(t, 40000)
+	viper.Set("download.fast_resume", true)
+	viper.Set("download.resume_path", t.TempDir())
+
+	dir := t.TempDir()
+	partial := append([]byte{}, data...)
+	copy(partial[16384:32768], make([]byte, 16384))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.bin"), partial, 0o644))
+	require.NoError(t, torrent.SaveResume(dir, bitfield(3, 0, 2)))
+
+	var mu sync.Mutex
+	requested := map[int]bool{}
+	torrent.Peers = []peer.Peer{fakeDownloadPeer(t, torrent.InfoHash, func(conn net.Conn) {
+		full := message.NewMessage(message.MsgBitfield, client.NewFullBitfield(3))
+		conn.Write(full.Serialize()) //nolint:errcheck
+		unchoke := message.NewMessage(message.MsgUnchoke, nil)
+		conn.Write(unchoke.Serialize()) //nolint:errcheck
+
+		for {
+			msg, err := message.Unmarshal(conn)
+			if err != nil {
+				return
+			}
+			if msg.ID != message.MsgRequest {
+				continue
+			}
+			index, begin, length, err := message.ParseRequest(msg)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			requested[index] = true
+			mu.Unlock()
+
+			offset := index*torrent.PieceLength + begin
+			block := message.NewPieceMessage(index, begin, data[offset:offset+length])
+			conn.Write(block.Serialize()) //nolint:errcheck
+		}
+	})}
+
+	requireDownload(t, torrent, dir, data)
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, map[int]bool{1: true}, requested)
+}
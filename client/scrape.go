@@ -0,0 +1,60 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/tracker"
+)
+
+// TrackerScrape is the swarm information from a single tracker
+// The error is set if the tracker failed to answer
+type TrackerScrape struct {
+	Tracker   string `json:"tracker"`
+	Seeders   int    `json:"seeders"`
+	Leechers  int    `json:"leechers"`
+	Completed int    `json:"completed"`
+	Error     string `json:"error,omitempty"`
+}
+
+// TorrentScrape is the swarm information of a torrent from all its trackers
+type TorrentScrape struct {
+	Name     string          `json:"name"`
+	InfoHash string          `json:"info_hash"`
+	Trackers []TrackerScrape `json:"trackers"`
+}
+
+// Scrape requests the swarm information from all the trackers of a torrent
+// The trackers are scraped at the same time and keep the tiers order
+func (info *TorrentInfo) Scrape() *TorrentScrape {
+	trackers := []string{}
+	for _, tier := range info.Trackers {
+		trackers = append(trackers, tier...)
+	}
+
+	scrapes := make([]TrackerScrape, len(trackers))
+	var wg sync.WaitGroup
+	for i, announceURL := range trackers {
+		wg.Add(1)
+		go func(i int, announceURL string) {
+			defer wg.Done()
+
+			scrapes[i].Tracker = announceURL
+			results, err := tracker.Scrape(announceURL, []handshake.Hash{info.infoHash})
+			if err != nil {
+				scrapes[i].Error = err.Error()
+				return
+			}
+			scrapes[i].Seeders = results[0].Seeders
+			scrapes[i].Leechers = results[0].Leechers
+			scrapes[i].Completed = results[0].Completed
+		}(i, announceURL)
+	}
+	wg.Wait()
+
+	return &TorrentScrape{
+		Name:     info.Name,
+		InfoHash: info.InfoHash,
+		Trackers: scrapes,
+	}
+}
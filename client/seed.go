@@ -0,0 +1,201 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/message"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+	"github.com/jhelison/go-torrent/tracker"
+
+	"github.com/spf13/viper"
+)
+
+// Seed shares the torrent data from the path until the stop channel is closed
+// Only the pieces that pass the hash check are shared
+func (t *Torrent) Seed(path string, stop <-chan struct{}) error {
+	log.Info().Msg("Starting seed")
+
+	storage, err := t.openStorage(path)
+	if err != nil {
+		return err
+	}
+	defer storage.Close()
+	up := newUploader(t, storage)
+
+	// Check all the pieces before sharing them
+	verified := t.loadVerifiedPieces(storage)
+	verifiedBytes := 0
+	for index := range t.PieceHashes {
+		if !verified.HasPiece(index) {
+			continue
+		}
+		up.markPiece(index)
+		verifiedBytes += t.calculatePieceSize(index)
+	}
+	if verifiedBytes == 0 {
+		return errors.New("no valid pieces to seed")
+	}
+	log.Info().Msgf("Sharing %d of %d bytes", verifiedBytes, t.Length)
+
+	// Start the workers for each new peer from the trackers
+	pool := newPeerPool()
+	knownPeers := map[string]bool{}
+	startWorkers := func(peers []peer.Peer) {
+		for _, peer := range peers {
+			if knownPeers[peer.String()] {
+				continue
+			}
+			knownPeers[peer.String()] = true
+			go t.startSeedWorker(peer, up, pool)
+		}
+	}
+	startWorkers(t.Peers)
+
+	// Incoming peers have already done the handshake
+	port := t.acceptPeers(func(conn net.Conn, remote *handshake.Handshake) {
+		client := newInboundClient(conn, remote, t.PeerID, t.InfoHash, NewBitfield(len(t.PieceHashes)))
+		t.runSeedWorker(client, up, pool)
+	})
+	defer t.stopAcceptingPeers()
+
+	var newPeers <-chan []peer.Peer
+	if t.hasTrackers() {
+		announcer := tracker.NewAnnouncer(t.Trackers, t.InfoHash, t.PeerID, port, func() tracker.Stats {
+			return tracker.Stats{
+				Uploaded: up.uploaded.Load(),
+				Left:     int64(t.Length - verifiedBytes),
+			}
+		})
+		announcer.SetIPv6(announceIPv6())
+		announcer.Start()
+		defer announcer.Stop()
+		newPeers = announcer.Peers()
+	}
+
+	stopDHT := make(chan struct{})
+	defer close(stopDHT)
+	dhtPeers := t.announceDHT(port, stopDHT)
+
+	stopLSD := make(chan struct{})
+	defer close(stopLSD)
+	localPeers := t.announceLSD(port, stopLSD)
+
+	for {
+		select {
+		case peers := <-newPeers:
+			startWorkers(peers)
+		case peers := <-dhtPeers:
+			startWorkers(peers)
+		case peers := <-localPeers:
+			startWorkers(peers)
+		case peers := <-pool.discovered:
+			startWorkers(peers)
+		case <-stop:
+			log.Info().Msgf("Stopping seed, uploaded %d bytes", up.uploaded.Load())
+			return nil
+		}
+	}
+}
+
+// startSeedWorker connects to a peer and serves its requests
+func (t *Torrent) startSeedWorker(peer peer.Peer, up *uploader, pool *peerPool) {
+	client, err := NewSeedClient(peer, t.PeerID, t.InfoHash, len(t.PieceHashes))
+	if err != nil {
+		log.Warn().Msgf("failed to start handshake with peer %s, err: %s", peer, err)
+		return
+	}
+
+	log.Info().Msgf("Handshake complete with peer %s", peer)
+
+	t.runSeedWorker(client, up, pool)
+}
+
+// runSeedWorker sends our bitfield and serves the requests from a client
+// The client is closed when the worker stops
+func (t *Torrent) runSeedWorker(client *Client, up *uploader, pool *peerPool) {
+	peer := client.peer
+	defer client.Close()
+	client.startUploads(up)
+
+	err := client.SendPieces(up.bitfield(), len(t.PieceHashes))
+	if err != nil {
+		log.Warn().Msgf("failed to send bitfield to peer %s, err: %s", peer, err)
+		return
+	}
+	t.startExtensions(client, pool)
+
+	err = client.seedLoop(len(t.PieceHashes))
+	log.Info().Msgf("Peer %s disconnected after uploading %d bytes, err: %s", peer, client.Uploaded.Load(), err)
+}
+
+// seedLoop reads the messages from the peer until it disconnects
+// Peers that already have all the pieces are dropped
+func (c *Client) seedLoop(nPieces int) error {
+	// Viper config
+	idleTimeout := viper.GetDuration("upload.idle_timeout")
+
+	for {
+		err := c.Conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if err != nil {
+			return err
+		}
+
+		msg, err := c.Read()
+		if err != nil {
+			return err
+		}
+
+		switch msg.ID {
+		case message.MsgBitfield:
+			c.Bitfield = msg.Payload
+		case message.MsgHave:
+			index, err := message.ParseHave(msg)
+			if err != nil {
+				return err
+			}
+			c.Bitfield.SetPiece(index)
+		case message.MsgHaveAll, message.MsgHaveNone:
+			if !c.fast {
+				return fmt.Errorf("unexpected fast message %v without the fast extension", msg.ID)
+			}
+			c.setHaves(msg.ID, nPieces)
+		case message.MsgSuggest, message.MsgAllowedFast:
+			err := c.handleFastMessage(msg)
+			if err != nil {
+				return err
+			}
+		case message.MsgExtended:
+			err := c.handleExtendedMessage(msg)
+			if err != nil {
+				return err
+			}
+		case message.MsgPiece:
+			// We never request pieces while seeding
+			err := message.DiscardPiece(msg)
+			if err != nil {
+				return err
+			}
+		case message.MsgInterrested:
+			// Every interested peer is unchoked
+			if !c.PeerInterested {
+				err := c.SendUnchoke()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		err = c.handleUploadMessage(msg)
+		if err != nil {
+			return err
+		}
+
+		if c.Bitfield.HasAll(nPieces) {
+			return fmt.Errorf("peer %s is also a seed", c.peer)
+		}
+	}
+}
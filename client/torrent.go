@@ -2,83 +2,65 @@ package client
 
 import (
 	"crypto/rand"
-	"io"
-	"net/http"
 	_ "net/http/pprof"
 	"os"
 
 	"github.com/jhelison/go-torrent/marshallers/bencode"
-	bencoderesponse "github.com/jhelison/go-torrent/marshallers/bencode_response"
-	"github.com/jhelison/go-torrent/marshallers/peer"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/tracker"
 )
 
 // TorrentFromTorrrentFile returns a torrent from a torrent file
 func TorrentFromTorrentFile(tFile string) (Torrent, error) {
-	// Read the file
-	file, err := os.Open(tFile)
-	if err != nil {
-		return Torrent{}, err
-	}
-	defer file.Close()
-
-	// Create a new torrent from the bencode
-	torrent, err := bencode.Unmarshal(file)
-	if err != nil {
-		return Torrent{}, err
-	}
-
-	// Transform into a torrent file
-	torrentFile, err := torrent.ToTorrentFile()
+	torrentFile, err := readTorrentFile(tFile)
 	if err != nil {
 		return Torrent{}, err
 	}
 
 	// Generate random bytes to be used as our client ID
-	var randomBytes [20]byte
-	_, err = rand.Read(randomBytes[:])
-	if err != nil {
-		return Torrent{}, err
-	}
-
-	// Build the announce tracker URL with our default port
-	url, err := torrentFile.BuildTrackerURL(randomBytes, 6881)
-	if err != nil {
-		return Torrent{}, err
-	}
-
-	// Get the response and unmarshal into a bencode response
-	body, err := get(url)
-	if err != nil {
-		return Torrent{}, err
-	}
-	res, err := bencoderesponse.Unmarshal(body)
-	if err != nil {
-		return Torrent{}, err
-	}
-
-	// Parse the peers
-	peers, err := peer.Unmarshal([]byte(res.Peers))
+	peerID, err := newPeerID()
 	if err != nil {
 		return Torrent{}, err
 	}
 
+	// The peers are requested from the trackers when the download starts
 	return Torrent{
-		Peers:       peers,
-		PeerID:      randomBytes,
+		PeerID:      peerID,
 		InfoHash:    torrentFile.InfoHash,
+		Info:        torrentFile.Info,
 		PieceHashes: torrentFile.PieceHashes,
 		PieceLength: torrentFile.PieceLength,
 		Length:      torrentFile.Length,
 		Name:        torrentFile.Name,
+		Files:       torrentFile.Files,
+		Trackers:    tracker.NewManager(torrentFile.Tiers()),
+		Private:     torrentFile.Private,
+		Nodes:       torrentFile.Nodes,
 	}, nil
 }
 
-// get is just a simple https getter
-func get(url string) (io.ReadCloser, error) {
-	resp, err := http.Get(url)
+// readTorrentFile reads and parses a torrent file
+func readTorrentFile(tFile string) (bencode.TorrentFile, error) {
+	// Read the file
+	file, err := os.Open(tFile)
 	if err != nil {
-		return http.NoBody, err
+		return bencode.TorrentFile{}, err
+	}
+	defer file.Close()
+
+	// Create a new torrent from the bencode
+	torrent, err := bencode.Unmarshal(file)
+	if err != nil {
+		return bencode.TorrentFile{}, err
 	}
 
-	return resp.Body, nil
+	// Transform into a torrent file
+	return torrent.ToTorrentFile()
+}
+
+// newPeerID generates a random peer ID
+func newPeerID() (handshake.PeerID, error) {
+	var randomBytes [20]byte
+	_, err := rand.Read(randomBytes[:])
+	return randomBytes, err
 }
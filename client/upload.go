@@ -0,0 +1,256 @@
+package client
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/jhelison/go-torrent/filesystem"
+	"github.com/jhelison/go-torrent/marshallers/message"
+)
+
+const (
+	// maxRequestLength is the biggest block a peer can request
+	maxRequestLength = 16 * 1024
+	// maxQueuedRequests is the max number of pending requests for a peer
+	maxQueuedRequests = 256
+)
+
+// blockRequest is a block requested by a remote peer
+type blockRequest struct {
+	index  int
+	begin  int
+	length int
+}
+
+// uploader serves the verified pieces of a torrent
+// It's shared between all the clients of a torrent
+type uploader struct {
+	torrent  *Torrent
+	storage  *filesystem.Storage
+	uploaded atomic.Int64
+
+	mu   sync.RWMutex
+	have Bitfield
+}
+
+// newUploader returns a new uploader without any verified piece
+func newUploader(t *Torrent, storage *filesystem.Storage) *uploader {
+	return &uploader{
+		torrent: t,
+		storage: storage,
+		have:    NewBitfield(len(t.PieceHashes)),
+	}
+}
+
+// markPiece flags a piece as verified and ready to be shared
+func (u *uploader) markPiece(index int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.have.SetPiece(index)
+}
+
+// hasPiece returns if a piece can be shared
+func (u *uploader) hasPiece(index int) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.have.HasPiece(index)
+}
+
+// bitfield returns a copy of the verified pieces
+func (u *uploader) bitfield() Bitfield {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return append(Bitfield{}, u.have...)
+}
+
+// validateRequest checks if a request can be served
+func (u *uploader) validateRequest(req blockRequest) error {
+	if req.length <= 0 || req.length > maxRequestLength {
+		return fmt.Errorf("invalid request length %d", req.length)
+	}
+	if req.index < 0 || req.index >= len(u.torrent.PieceHashes) {
+		return fmt.Errorf("invalid request index %d", req.index)
+	}
+	if req.begin < 0 || req.begin+req.length > u.torrent.calculatePieceSize(req.index) {
+		return fmt.Errorf("invalid request bounds %d-%d for piece %d", req.begin, req.begin+req.length, req.index)
+	}
+	if !u.hasPiece(req.index) {
+		return fmt.Errorf("piece %d not available", req.index)
+	}
+	return nil
+}
+
+// readBlock reads a requested block from the storage
+func (u *uploader) readBlock(req blockRequest) ([]byte, error) {
+	pieceBegin, _ := u.torrent.calculateBoundsForPiece(req.index)
+	buf := make([]byte, req.length)
+	err := u.storage.ReadAt(buf, int64(pieceBegin+req.begin))
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// uploadQueue is the queue of blocks requested by a single peer
+// Requests can be canceled while they are still on the queue
+type uploadQueue struct {
+	mu       sync.Mutex
+	requests []blockRequest
+	signal   chan struct{}
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// newUploadQueue returns a new empty queue
+func newUploadQueue() *uploadQueue {
+	return &uploadQueue{
+		signal: make(chan struct{}, 1),
+		closed: make(chan struct{}),
+	}
+}
+
+// push adds a request to the queue
+// Returns false if the queue is full
+func (q *uploadQueue) push(req blockRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.requests) >= maxQueuedRequests {
+		return false
+	}
+	q.requests = append(q.requests, req)
+
+	select {
+	case q.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// cancel removes a request from the queue
+// Returns false if the request isn't queued
+func (q *uploadQueue) cancel(req blockRequest) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, queued := range q.requests {
+		if queued == req {
+			q.requests = append(q.requests[:i], q.requests[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// pop waits for the next request
+// Returns false if the queue has been closed
+func (q *uploadQueue) pop() (blockRequest, bool) {
+	for {
+		q.mu.Lock()
+		if len(q.requests) > 0 {
+			req := q.requests[0]
+			q.requests = q.requests[1:]
+			q.mu.Unlock()
+			return req, true
+		}
+		q.mu.Unlock()
+
+		select {
+		case <-q.signal:
+		case <-q.closed:
+			return blockRequest{}, false
+		}
+	}
+}
+
+// close stops the queue, waking up any waiting pop
+func (q *uploadQueue) close() {
+	q.once.Do(func() {
+		close(q.closed)
+	})
+}
+
+// startUploads enables the uploads for the client
+// The requests are served on the background until the client is closed
+func (c *Client) startUploads(u *uploader) {
+	c.uploader = u
+	c.uploads = newUploadQueue()
+	go c.serveUploads()
+}
+
+// serveUploads sends the requested blocks to the peer
+func (c *Client) serveUploads() {
+	for {
+		req, ok := c.uploads.pop()
+		if !ok {
+			return
+		}
+
+		block, err := c.uploader.readBlock(req)
+		if err != nil {
+			log.Warn().Msgf("failed to read block for peer %s, err: %s", c.peer, err)
+			continue
+		}
+
+		msg := message.NewPieceMessage(req.index, req.begin, block)
+		_, err = c.Conn.Write(msg.Serialize())
+		if err != nil {
+			return
+		}
+
+		c.Uploaded.Add(int64(len(block)))
+		c.uploader.uploaded.Add(int64(len(block)))
+	}
+}
+
+// handleUploadMessage handles the messages related to the uploads
+// Messages not related to uploads are ignored
+func (c *Client) handleUploadMessage(msg message.Message) error {
+	switch msg.ID {
+	case message.MsgInterrested:
+		c.PeerInterested = true
+	case message.MsgNotInterested:
+		c.PeerInterested = false
+	case message.MsgRequest:
+		if c.uploads == nil {
+			return nil
+		}
+		index, begin, length, err := message.ParseRequest(msg)
+		if err != nil {
+			return err
+		}
+		req := blockRequest{index: index, begin: begin, length: length}
+		if err := c.uploader.validateRequest(req); err != nil {
+			log.Debug().Msgf("ignoring request from peer %s, err: %s", c.peer, err)
+			return c.rejectRequest(req)
+		}
+		if !c.uploads.push(req) {
+			log.Debug().Msgf("upload queue full for peer %s", c.peer)
+			return c.rejectRequest(req)
+		}
+	case message.MsgCancel:
+		if c.uploads == nil {
+			return nil
+		}
+		index, begin, length, err := message.ParseRequest(msg)
+		if err != nil {
+			return err
+		}
+		// With the fast extension every canceled request must be answered
+		req := blockRequest{index: index, begin: begin, length: length}
+		if c.uploads.cancel(req) {
+			return c.rejectRequest(req)
+		}
+	}
+	return nil
+}
+
+// rejectRequest tells the peer a request won't be served
+// Without the fast extension the request is dropped silently
+func (c *Client) rejectRequest(req blockRequest) error {
+	if !c.fast {
+		return nil
+	}
+	return c.SendReject(req.index, req.begin, req.length)
+}
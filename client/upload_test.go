@@ -0,0 +1,192 @@
+package client_test
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/message"
+)
+
+// TestValidateRequest tests the requests that can be served
+func TestValidateRequest(t *testing.T) {
+	torrent := client.Torrent{
+		PieceHashes: make([]handshake.Hash, 3),
+		PieceLength: 32768,
+		Length:      70000,
+	}
+	have := []int{0, 2}
+
+	testCases := []struct {
+		name        string
+		index       int
+		begin       int
+		length      int
+		errContains string
+	}{
+		{
+			name:   "pass",
+			index:  0,
+			begin:  16384,
+			length: 16384,
+		},
+		{
+			name:   "last piece",
+			index:  2,
+			begin:  0,
+			length: 4464,
+		},
+		{
+			name:        "missing piece",
+			index:       1,
+			length:      16384,
+			errContains: "piece 1 not available",
+		},
+		{
+			name:        "index out of range",
+			index:       3,
+			length:      16384,
+			errContains: "invalid request index 3",
+		},
+		{
+			name:        "past the piece end",
+			index:       0,
+			begin:       16385,
+			length:      16384,
+			errContains: "invalid request bounds",
+		},
+		{
+			name:        "past the last piece end",
+			index:       2,
+			begin:       0,
+			length:      4465,
+			errContains: "invalid request bounds",
+		},
+		{
+			name:        "length above 16KiB",
+			index:       0,
+			length:      16385,
+			errContains: "invalid request length 16385",
+		},
+		{
+			name:        "empty length",
+			index:       0,
+			errContains: "invalid request length 0",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := torrent.ValidateRequest(have, tc.index, tc.begin, tc.length)
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestUploadQueue tests the cancel and close of the queued requests
+func TestUploadQueue(t *testing.T) {
+	queue := client.NewUploadQueue()
+	require.True(t, queue.Push(0, 0, 16384))
+	require.True(t, queue.Push(0, 16384, 16384))
+
+	// Only queued requests can be canceled
+	require.True(t, queue.Cancel(0, 0, 16384))
+	require.False(t, queue.Cancel(0, 0, 16384))
+	require.False(t, queue.Cancel(1, 0, 16384))
+
+	req, ok := queue.Pop()
+	require.True(t, ok)
+	require.Equal(t, []int{0, 16384, 16384}, req)
+
+	// Close wakes the waiting pop
+	popped := make(chan bool)
+	go func() {
+		_, ok := queue.Pop()
+		popped <- ok
+	}()
+	queue.Close()
+	select {
+	case ok := <-popped:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		require.FailNow(t, "pop not woken by close")
+	}
+}
+
+// TestSeedConn tests serving the requests of a peer and the cancel of a queued request
+func TestSeedConn(t *testing.T) {
+	torrent, data := downloadTorrent(t, 40000)
+	viper.Set("upload.idle_timeout", "5s")
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "data.bin"), data, 0o644))
+
+	conn, remote := net.Pipe()
+	defer remote.Close()
+	seeded := make(chan *client.Client, 1)
+	go func() {
+		c, _ := torrent.SeedConn(conn, dir, true)
+		seeded <- c
+	}()
+	require.NoError(t, remote.SetDeadline(time.Now().Add(5*time.Second)))
+
+	// Interested peers are unchoked
+	send := func(msg message.Message) {
+		_, err := remote.Write(msg.Serialize())
+		require.NoError(t, err)
+	}
+	send(message.NewMessage(message.MsgInterrested, nil))
+	msg, err := message.Unmarshal(remote)
+	require.NoError(t, err)
+	require.Equal(t, message.MsgUnchoke, msg.ID)
+
+	// The first block blocks the pipe, so the second stays on the queue until canceled
+	send(message.NewRequestMessage(0, 0, 16384))
+	send(message.NewRequestMessage(1, 0, 16384))
+	send(message.NewCancelMessage(1, 0, 16384))
+
+	// The block and the reject may come in any order
+	buf := make([]byte, torrent.PieceLength)
+	ids := []message.MessageID{}
+	for len(ids) < 2 {
+		msg, err := message.Unmarshal(remote)
+		require.NoError(t, err)
+		ids = append(ids, msg.ID)
+
+		switch msg.ID {
+		case message.MsgPiece:
+			begin, n, err := message.ParsePieceBlock(0, buf, msg)
+			require.NoError(t, err)
+			require.Equal(t, 0, begin)
+			require.Equal(t, data[:16384], buf[:n])
+		case message.MsgRejectRequest:
+			index, begin, length, err := message.ParseRequest(msg)
+			require.NoError(t, err)
+			require.Equal(t, []int{1, 0, 16384}, []int{index, begin, length})
+		}
+	}
+	require.ElementsMatch(t, []message.MessageID{message.MsgPiece, message.MsgRejectRequest}, ids)
+
+	// The canceled block is never sent
+	require.NoError(t, remote.SetReadDeadline(time.Now().Add(200*time.Millisecond)))
+	_, err = message.Unmarshal(remote)
+	require.ErrorIs(t, err, os.ErrDeadlineExceeded)
+
+	remote.Close()
+	c := <-seeded
+	require.Eventually(t, func() bool {
+		return c.Uploaded.Load() == 16384
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,42 @@
+package client
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+var (
+	// The peers that didn't answer over uTP, they are only dialed over TCP
+	utpFailedMu sync.Mutex
+	utpFailed   = map[string]bool{}
+)
+
+// utpDialer returns the uTP dial for a peer
+// uTP is dialed from the listener socket, so the peer sees our listen port
+// It's not available if uTP is disabled or the peer didn't answer before
+func utpDialer(p peer.Peer) (dialFunc, bool) {
+	listener, err := DefaultListener()
+	if err != nil || listener.utp == nil {
+		return nil, false
+	}
+
+	utpFailedMu.Lock()
+	failed := utpFailed[p.String()]
+	utpFailedMu.Unlock()
+	if failed {
+		return nil, false
+	}
+
+	return func(address string, timeout time.Duration) (net.Conn, error) {
+		conn, err := listener.utp.DialTimeout(address, timeout)
+		if err != nil {
+			utpFailedMu.Lock()
+			utpFailed[p.String()] = true
+			utpFailedMu.Unlock()
+		}
+		return conn, err
+	}, true
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"os"
+
+	"github.com/jhelison/go-torrent/filesystem"
+)
+
+// PieceStatus is the integrity status of a piece or a file
+type PieceStatus string
+
+// Types of status
+const (
+	StatusGood    PieceStatus = "good"
+	StatusBad     PieceStatus = "bad"
+	StatusMissing PieceStatus = "missing"
+)
+
+// FileReport is the integrity report for a single file
+// A file is good only if all the pieces it's part of are good
+type FileReport struct {
+	Path          string      `json:"path"`
+	Length        int         `json:"length"`
+	Status        PieceStatus `json:"status"`
+	GoodPieces    int         `json:"good_pieces"`
+	BadPieces     int         `json:"bad_pieces"`
+	MissingPieces int         `json:"missing_pieces"`
+}
+
+// VerifyReport is the integrity report for the data of a torrent
+type VerifyReport struct {
+	Good    int           `json:"good"`
+	Bad     int           `json:"bad"`
+	Missing int           `json:"missing"`
+	Pieces  []PieceStatus `json:"pieces"`
+	Files   []FileReport  `json:"files"`
+}
+
+// Complete returns if all the pieces and files are good
+func (r VerifyReport) Complete() bool {
+	if r.Bad > 0 || r.Missing > 0 {
+		return false
+	}
+	for _, file := range r.Files {
+		if file.Status != StatusGood {
+			return false
+		}
+	}
+	return true
+}
+
+// Verify checks the hash of all the pieces from the data on the path
+// Pieces from files that don't exist or are too short are reported as missing
+func (t *Torrent) Verify(path string) (*VerifyReport, error) {
+	entries := t.storageEntries(path)
+
+	// Only the complete files are opened
+	existing := []filesystem.FileEntry{}
+	missingFiles := make([]bool, len(entries))
+	for i, entry := range entries {
+		info, err := os.Stat(entry.Path)
+		if err != nil || info.IsDir() || info.Size() < entry.Length {
+			missingFiles[i] = true
+			continue
+		}
+		existing = append(existing, entry)
+	}
+
+	storage, err := filesystem.OpenStorage(existing)
+	if err != nil {
+		return nil, err
+	}
+	defer storage.Close()
+
+	// Classify each piece
+	report := VerifyReport{
+		Pieces: make([]PieceStatus, len(t.PieceHashes)),
+	}
+	for index, err := range t.hashCheckPieces(storage) {
+		switch {
+		case t.pieceHasMissingFile(index, missingFiles):
+			report.Pieces[index] = StatusMissing
+			report.Missing++
+		case err != nil:
+			report.Pieces[index] = StatusBad
+			report.Bad++
+		default:
+			report.Pieces[index] = StatusGood
+			report.Good++
+		}
+	}
+
+	// Aggregate the pieces on each file
+	for i, file := range t.Files {
+		fileReport := FileReport{
+			Path:   entries[i].Path,
+			Length: file.Length,
+		}
+		for _, index := range t.filePieces(i) {
+			switch report.Pieces[index] {
+			case StatusGood:
+				fileReport.GoodPieces++
+			case StatusBad:
+				fileReport.BadPieces++
+			case StatusMissing:
+				fileReport.MissingPieces++
+			}
+		}
+
+		switch {
+		case missingFiles[i]:
+			fileReport.Status = StatusMissing
+		case fileReport.BadPieces > 0 || fileReport.MissingPieces > 0:
+			fileReport.Status = StatusBad
+		default:
+			fileReport.Status = StatusGood
+		}
+		report.Files = append(report.Files, fileReport)
+	}
+
+	return &report, nil
+}
+
+// filePieces returns the indexes of the pieces that overlap a file
+func (t *Torrent) filePieces(fileIndex int) []int {
+	file := t.Files[fileIndex]
+	if file.Length == 0 || t.PieceLength == 0 {
+		return nil
+	}
+
+	first := file.Offset / t.PieceLength
+	last := (file.Offset + file.Length - 1) / t.PieceLength
+	indexes := make([]int, 0, last-first+1)
+	for index := first; index <= last && index < len(t.PieceHashes); index++ {
+		indexes = append(indexes, index)
+	}
+	return indexes
+}
+
+// pieceHasMissingFile returns if any file overlapping a piece is missing
+func (t *Torrent) pieceHasMissingFile(index int, missingFiles []bool) bool {
+	begin, end := t.calculateBoundsForPiece(index)
+	for i, file := range t.Files {
+		if !missingFiles[i] || file.Length == 0 {
+			continue
+		}
+		if file.Offset < end && file.Offset+file.Length > begin {
+			return true
+		}
+	}
+	return false
+}
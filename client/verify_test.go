@@ -0,0 +1,66 @@
+package client_test
+
+import (
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/client"
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+)
+
+// TestVerify tests the integrity report for good, bad and missing data
+func TestVerify(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	torrent := client.Torrent{
+		PieceLength: 8,
+		Length:      len(data),
+		Files: []bencode.File{
+			{Path: []string{"test", "a"}, Length: 6, Offset: 0},
+			{Path: []string{"test", "b"}, Length: 6, Offset: 6},
+			{Path: []string{"test", "c"}, Length: 8, Offset: 12},
+		},
+	}
+	for begin := 0; begin < len(data); begin += torrent.PieceLength {
+		end := begin + torrent.PieceLength
+		if end > len(data) {
+			end = len(data)
+		}
+		torrent.PieceHashes = append(torrent.PieceHashes, handshake.Hash(sha1.Sum(data[begin:end])))
+	}
+
+	dir := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "test"), os.ModePerm))
+	write := func(name string, content []byte) {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "test", name), content, 0o644))
+	}
+
+	// All the data is good
+	write("a", data[0:6])
+	write("b", data[6:12])
+	write("c", data[12:20])
+	report, err := torrent.Verify(dir)
+	require.NoError(t, err)
+	require.True(t, report.Complete())
+	require.Equal(t, 3, report.Good)
+
+	// A corrupted byte on the last file
+	write("c", []byte("XXXXXXXX"))
+	report, err = torrent.Verify(dir)
+	require.NoError(t, err)
+	require.False(t, report.Complete())
+	require.Equal(t, []client.PieceStatus{client.StatusGood, client.StatusBad, client.StatusBad}, report.Pieces)
+	require.Equal(t, client.StatusBad, report.Files[1].Status)
+
+	// A missing file
+	require.NoError(t, os.Remove(filepath.Join(dir, "test", "a")))
+	report, err = torrent.Verify(dir)
+	require.NoError(t, err)
+	require.Equal(t, []client.PieceStatus{client.StatusMissing, client.StatusBad, client.StatusBad}, report.Pieces)
+	require.Equal(t, client.StatusMissing, report.Files[0].Status)
+	require.Equal(t, 1, report.Missing)
+}
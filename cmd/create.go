@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+
+	"github.com/spf13/cobra"
+)
+
+func CreateCmd() *cobra.Command {
+	trackers := []string{}
+	webSeeds := []string{}
+	pieceLength := 0
+	private := false
+	comment := ""
+	output := ""
+
+	cmd := &cobra.Command{
+		Use:   "create [path] [options]",
+		Short: "Create a torrent file from a file or directory",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Check if the path exists
+			if _, err := os.Stat(args[0]); os.IsNotExist(err) {
+				return fmt.Errorf("Error: Path does not exist: %s\n", args[0])
+			}
+
+			// Each tracker is its own tier
+			tiers := [][]string{}
+			for _, tracker := range trackers {
+				tiers = append(tiers, []string{tracker})
+			}
+
+			torrent, err := bencode.Create(args[0], bencode.CreateOptions{
+				Tiers:        tiers,
+				PieceLength:  pieceLength,
+				Private:      private,
+				Comment:      comment,
+				CreatedBy:    "go-torrent",
+				CreationDate: time.Now(),
+				WebSeeds:     webSeeds,
+			})
+			if err != nil {
+				return err
+			}
+
+			// The default output is named after the path
+			if output == "" {
+				output = filepath.Base(filepath.Clean(args[0])) + ".torrent"
+			}
+
+			err = os.WriteFile(output, torrent, 0o644)
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(cmd.OutOrStdout(), "Torrent created: %s\n", output)
+			return nil
+		},
+	}
+
+	// Other flags
+	cmd.Flags().StringArrayVar(&trackers, "tracker", trackers, "tracker announce URL, can be repeated for backup tiers")
+	cmd.Flags().IntVar(&pieceLength, "piece-length", pieceLength, "piece length in bytes, a power of two from 16KiB to 16MiB (default picks one from the size)")
+	cmd.Flags().BoolVar(&private, "private", private, "mark the torrent as private")
+	cmd.Flags().StringVar(&comment, "comment", comment, "comment stored in the torrent")
+	cmd.Flags().StringArrayVar(&webSeeds, "web-seed", webSeeds, "web seed URL, can be repeated")
+	cmd.Flags().StringVarP(&output, "output", "o", output, "output torrent file (default is <name>.torrent)")
+
+	return cmd
+}
@@ -3,6 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/jhelison/go-torrent/client"
 
@@ -14,16 +15,11 @@ func DownloadCmd() *cobra.Command {
 	defaultOutPath := viper.GetString("download.output_path")
 
 	cmd := &cobra.Command{
-		Use:   "download [torrent_file] [options]",
-		Short: "Download a single torrent file into the output path",
+		Use:   "download [torrent_file|magnet_uri] [options]",
+		Short: "Download a single torrent file or magnet link into the output path",
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			filePath := args[0]
-
-			// Check if the file exists
-			if file, err := os.Stat(filePath); os.IsNotExist(err) || file.IsDir() {
-				return fmt.Errorf("Error: File does not exist: %s\n", filePath)
-			}
+			source := args[0]
 
 			// Check if the output path exists
 			if file, err := os.Stat(defaultOutPath); os.IsNotExist(err) || !file.IsDir() {
@@ -31,7 +27,7 @@ func DownloadCmd() *cobra.Command {
 			}
 
 			// Get the torrent object
-			torrent, err := client.TorrentFromTorrentFile(filePath)
+			torrent, err := loadTorrent(source)
 			if err != nil {
 				return err
 			}
@@ -51,3 +47,17 @@ func DownloadCmd() *cobra.Command {
 
 	return cmd
 }
+
+// loadTorrent loads a torrent from a magnet URI or a torrent file path
+func loadTorrent(source string) (client.Torrent, error) {
+	if strings.HasPrefix(source, "magnet:") {
+		return client.TorrentFromMagnet(source)
+	}
+
+	// Check if the file exists
+	if file, err := os.Stat(source); os.IsNotExist(err) || file.IsDir() {
+		return client.Torrent{}, fmt.Errorf("Error: File does not exist: %s\n", source)
+	}
+
+	return client.TorrentFromTorrentFile(source)
+}
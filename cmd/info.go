@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jhelison/go-torrent/client"
+
+	"github.com/spf13/cobra"
+)
+
+func InfoCmd() *cobra.Command {
+	jsonOutput := false
+
+	cmd := &cobra.Command{
+		Use:   "info [torrent_file|magnet_uri] [options]",
+		Short: "Print the metadata of a torrent file or magnet link",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			info, err := loadInfo(args[0])
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(info)
+			}
+
+			printInfo(cmd.OutOrStdout(), info)
+			return nil
+		},
+	}
+
+	// Other flags
+	cmd.Flags().BoolVar(&jsonOutput, "json", jsonOutput, "print the info as JSON")
+
+	return cmd
+}
+
+// loadInfo loads the metadata from a magnet URI or a torrent file path
+func loadInfo(source string) (*client.TorrentInfo, error) {
+	if strings.HasPrefix(source, "magnet:") {
+		return client.InfoFromMagnet(source)
+	}
+
+	// Check if the file exists
+	if file, err := os.Stat(source); os.IsNotExist(err) || file.IsDir() {
+		return nil, fmt.Errorf("Error: File does not exist: %s\n", source)
+	}
+
+	return client.InfoFromTorrentFile(source)
+}
+
+// printInfo prints a human readable view of the metadata
+func printInfo(out io.Writer, info *client.TorrentInfo) {
+	fmt.Fprintf(out, "Name:           %s\n", info.Name)
+	fmt.Fprintf(out, "Info hash:      %s\n", info.InfoHash)
+	fmt.Fprintf(out, "Info hash (32): %s\n", info.InfoHashBase32)
+
+	if info.Magnet {
+		fmt.Fprintln(out, "Metadata:       not available on magnet links")
+	} else {
+		fmt.Fprintf(out, "Size:           %s (%d bytes)\n", formatSize(info.Length), info.Length)
+		fmt.Fprintf(out, "Pieces:         %d x %s\n", info.Pieces, formatSize(info.PieceLength))
+		fmt.Fprintf(out, "Private:        %t\n", info.Private)
+	}
+
+	if info.CreationDate != nil {
+		fmt.Fprintf(out, "Created on:     %s\n", info.CreationDate.Format("2006-01-02 15:04:05 MST"))
+	}
+	if info.CreatedBy != "" {
+		fmt.Fprintf(out, "Created by:     %s\n", info.CreatedBy)
+	}
+	if info.Comment != "" {
+		fmt.Fprintf(out, "Comment:        %s\n", info.Comment)
+	}
+
+	if len(info.Trackers) > 0 {
+		fmt.Fprintln(out, "Trackers:")
+		for i, tier := range info.Trackers {
+			for _, tracker := range tier {
+				fmt.Fprintf(out, "  [tier %d] %s\n", i, tracker)
+			}
+		}
+	}
+
+	if len(info.WebSeeds) > 0 {
+		fmt.Fprintln(out, "Web seeds:")
+		for _, webSeed := range info.WebSeeds {
+			fmt.Fprintf(out, "  %s\n", webSeed)
+		}
+	}
+
+	if len(info.Files) > 0 {
+		fmt.Fprintln(out, "Files:")
+		printFileTree(out, info.Files)
+	}
+}
+
+// printFileTree prints the files as a tree
+// The directories are printed once, as the files are grouped by path
+func printFileTree(out io.Writer, files []client.FileInfo) {
+	previous := []string{}
+	for _, file := range files {
+		components := strings.Split(filepath.ToSlash(file.Path), "/")
+		dirs := components[:len(components)-1]
+
+		// Skip the directories shared with the previous file
+		shared := 0
+		for shared < len(dirs) && shared < len(previous) && dirs[shared] == previous[shared] {
+			shared++
+		}
+		for depth := shared; depth < len(dirs); depth++ {
+			fmt.Fprintf(out, "%s%s/\n", strings.Repeat("  ", depth+1), dirs[depth])
+		}
+
+		fmt.Fprintf(out, "%s%s (%s)\n", strings.Repeat("  ", len(dirs)+1), components[len(components)-1], formatSize(file.Length))
+		previous = dirs
+	}
+}
+
+// formatSize formats a size in bytes with binary units
+func formatSize(size int) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	value := float64(size)
+	units := []string{"KiB", "MiB", "GiB", "TiB", "PiB"}
+	i := -1
+	for value >= unit && i < len(units)-1 {
+		value /= unit
+		i++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[i])
+}
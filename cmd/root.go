@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 
@@ -22,8 +23,13 @@ var (
 )
 
 // Execute executes the root command.
+// A incomplete verify exits with the code 1
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if errors.Is(err, errIncomplete) {
+		os.Exit(1)
+	}
+	return err
 }
 
 func init() {
@@ -34,6 +40,11 @@ func init() {
 
 	// Additional commands
 	rootCmd.AddCommand(DownloadCmd())
+	rootCmd.AddCommand(SeedCmd())
+	rootCmd.AddCommand(VerifyCmd())
+	rootCmd.AddCommand(CreateCmd())
+	rootCmd.AddCommand(InfoCmd())
+	rootCmd.AddCommand(ScrapeCmd())
 }
 
 // initConfig initiates all the configurations used in go-torrent
@@ -91,8 +102,42 @@ func buildConfigs(home string) {
 	viper.SetDefault("download.max_backlog", 10)
 	viper.SetDefault("download.block_size", 16384)
 	viper.SetDefault("download.output_path", fmt.Sprintf("%s/Downloads", home))
+	viper.SetDefault("download.fast_resume", true)
+	viper.SetDefault("download.resume_interval", "30s")
+	viper.SetDefault("download.resume_path", fmt.Sprintf("%s/.go-torrent/resume", home))
 
 	// Peers config
 	viper.SetDefault("peers.max_retries", 10)
 	viper.SetDefault("peers.timeout", "5s")
+	viper.SetDefault("peers.listen_host", "")
+	viper.SetDefault("peers.listen_port", 6881)
+	viper.SetDefault("peers.encryption", "prefer")
+	viper.SetDefault("peers.utp", true)
+
+	// DHT config
+	viper.SetDefault("dht.enabled", true)
+	viper.SetDefault("dht.listen_port", 6881)
+	viper.SetDefault("dht.announce_interval", "15m")
+	viper.SetDefault("dht.nodes_path", fmt.Sprintf("%s/.go-torrent/dht_nodes.json", home))
+	viper.SetDefault("dht.bootstrap_nodes", []string{
+		"router.bittorrent.com:6881",
+		"dht.transmissionbt.com:6881",
+		"router.utorrent.com:6881",
+	})
+
+	// Local service discovery config
+	viper.SetDefault("lsd.enabled", true)
+	viper.SetDefault("lsd.announce_interval", "5m")
+
+	// Upload config
+	viper.SetDefault("upload.idle_timeout", "2m")
+
+	// Tracker config
+	viper.SetDefault("tracker.http_timeout", "15s")
+	viper.SetDefault("tracker.default_interval", "30m")
+	viper.SetDefault("tracker.retry_interval", "1m")
+	viper.SetDefault("tracker.udp_timeout", "15s")
+	viper.SetDefault("tracker.udp_max_retries", 3)
+	// Announce to every tier and merge the peers, instead of stopping at the first tier that answers
+	viper.SetDefault("tracker.announce_all_tiers", false)
 }
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/jhelison/go-torrent/client"
+
+	"github.com/spf13/cobra"
+)
+
+func ScrapeCmd() *cobra.Command {
+	jsonOutput := false
+
+	cmd := &cobra.Command{
+		Use:   "scrape [torrent_file|magnet_uri...] [options]",
+		Short: "Print the seeders and leechers of torrents from their trackers",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scrapes := make([]*client.TorrentScrape, 0, len(args))
+			for _, source := range args {
+				info, err := loadInfo(source)
+				if err != nil {
+					return err
+				}
+				scrapes = append(scrapes, info.Scrape())
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(scrapes)
+			}
+
+			return printScrapes(cmd.OutOrStdout(), scrapes)
+		},
+	}
+
+	// Other flags
+	cmd.Flags().BoolVar(&jsonOutput, "json", jsonOutput, "print the scrapes as JSON")
+
+	return cmd
+}
+
+// printScrapes prints a table with the trackers of each torrent
+func printScrapes(out io.Writer, scrapes []*client.TorrentScrape) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for i, scrape := range scrapes {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintf(w, "%s (%s)\n", scrape.Name, scrape.InfoHash)
+		if len(scrape.Trackers) == 0 {
+			fmt.Fprintln(w, "  no trackers")
+			continue
+		}
+
+		fmt.Fprintln(w, "  TRACKER\tSEEDERS\tLEECHERS\tCOMPLETED\tERROR")
+		for _, tr := range scrape.Trackers {
+			if tr.Error != "" {
+				fmt.Fprintf(w, "  %s\t-\t-\t-\t%s\n", tr.Tracker, tr.Error)
+				continue
+			}
+			fmt.Fprintf(w, "  %s\t%d\t%d\t%d\t\n", tr.Tracker, tr.Seeders, tr.Leechers, tr.Completed)
+		}
+	}
+	return w.Flush()
+}
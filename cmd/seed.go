@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func SeedCmd() *cobra.Command {
+	defaultDataPath := viper.GetString("download.output_path")
+
+	cmd := &cobra.Command{
+		Use:   "seed [torrent_file|magnet_uri] [options]",
+		Short: "Share the downloaded data of a torrent with the peers",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			source := args[0]
+
+			// Check if the data path exists
+			if file, err := os.Stat(defaultDataPath); os.IsNotExist(err) || !file.IsDir() {
+				return fmt.Errorf("Error: Data path does not exist: %s\n", defaultDataPath)
+			}
+
+			// Get the torrent object
+			torrent, err := loadTorrent(source)
+			if err != nil {
+				return err
+			}
+
+			// Keep seeding until interrupted
+			signals := make(chan os.Signal, 1)
+			signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+			stop := make(chan struct{})
+			go func() {
+				<-signals
+				close(stop)
+			}()
+
+			return torrent.Seed(defaultDataPath, stop)
+		},
+	}
+
+	// Other flags
+	cmd.Flags().StringVar(&defaultDataPath, "data", defaultDataPath, "path with the downloaded data")
+
+	return cmd
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jhelison/go-torrent/client"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// errIncomplete is returned when the verified data isn't complete
+// Execute maps it to the exit code 1
+var errIncomplete = errors.New("the data is incomplete")
+
+func VerifyCmd() *cobra.Command {
+	defaultDataPath := viper.GetString("download.output_path")
+	jsonOutput := false
+
+	cmd := &cobra.Command{
+		Use:   "verify [torrent_file] [options]",
+		Short: "Check the integrity of the downloaded data of a torrent",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Check if the data path exists
+			if file, err := os.Stat(defaultDataPath); os.IsNotExist(err) || !file.IsDir() {
+				return fmt.Errorf("Error: Data path does not exist: %s\n", defaultDataPath)
+			}
+
+			// Get the torrent object
+			torrent, err := loadTorrent(args[0])
+			if err != nil {
+				return err
+			}
+
+			report, err := torrent.Verify(defaultDataPath)
+			if err != nil {
+				return err
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				err = encoder.Encode(report)
+				if err != nil {
+					return err
+				}
+			} else {
+				printVerifyReport(cmd, report)
+			}
+
+			// The exit code reflects the integrity of the data
+			if !report.Complete() {
+				// The report was already printed
+				cmd.SilenceUsage = true
+				cmd.SilenceErrors = true
+				return errIncomplete
+			}
+			return nil
+		},
+	}
+
+	// Other flags
+	cmd.Flags().StringVar(&defaultDataPath, "data", defaultDataPath, "path with the downloaded data")
+	cmd.Flags().BoolVar(&jsonOutput, "json", jsonOutput, "print the report as JSON")
+
+	return cmd
+}
+
+// printVerifyReport prints a human readable report
+// Only the pieces that aren't good are listed
+func printVerifyReport(cmd *cobra.Command, report *client.VerifyReport) {
+	out := cmd.OutOrStdout()
+
+	fmt.Fprintln(out, "Files:")
+	for _, file := range report.Files {
+		fmt.Fprintf(out, "  [%-7s] %s (%d bytes, %d good, %d bad, %d missing pieces)\n",
+			file.Status, file.Path, file.Length, file.GoodPieces, file.BadPieces, file.MissingPieces)
+	}
+
+	fmt.Fprintln(out, "Pieces:")
+	for index, status := range report.Pieces {
+		if status != client.StatusGood {
+			fmt.Fprintf(out, "  #%d %s\n", index, status)
+		}
+	}
+
+	fmt.Fprintf(out, "Total: %d good, %d bad, %d missing of %d pieces\n",
+		report.Good, report.Bad, report.Missing, len(report.Pieces))
+}
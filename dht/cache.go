@@ -0,0 +1,58 @@
+package dht
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// cachedNode is a node saved on the node cache
+type cachedNode struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"`
+}
+
+// SaveNodes saves the nodes from the routing table into a file
+// The saved nodes are used to bootstrap on the next start
+func (s *Server) SaveNodes(path string) error {
+	nodes := s.Nodes()
+	cached := make([]cachedNode, len(nodes))
+	for i, node := range nodes {
+		cached[i] = cachedNode{
+			ID:   hex.EncodeToString(node.ID[:]),
+			Addr: node.Addr.String(),
+		}
+	}
+
+	raw, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
+
+// LoadNodes reads the addresses of the nodes from a node cache file
+func LoadNodes(path string) ([]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cached := []cachedNode{}
+	err = json.Unmarshal(raw, &cached)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(cached))
+	for _, node := range cached {
+		addrs = append(addrs, node.Addr)
+	}
+	return addrs, nil
+}
@@ -0,0 +1,701 @@
+package dht
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jhelison/go-torrent/logger"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/krpc"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+var (
+	// Default logger
+	log = logger.GetLogger()
+)
+
+const (
+	// alpha is the number of parallel queries on lookups
+	alpha = 3
+	// maxLookupRounds bounds the number of rounds of a lookup
+	maxLookupRounds = 16
+	// secretLifetime is how long a token secret is used
+	// Tokens are accepted for up to two lifetimes
+	secretLifetime = 5 * time.Minute
+	// peerLifetime is how long announced peers are stored
+	peerLifetime = 30 * time.Minute
+	// maxStoredPeers is the max number of peers stored per info hash
+	maxStoredPeers = 1000
+	// maxStoredHashes is the max number of info hashes with stored peers
+	maxStoredHashes = 10000
+	// maxValues is the max number of peers returned on a get_peers
+	maxValues = 50
+	// refreshInterval is the interval between routing table refreshes
+	refreshInterval = 15 * time.Minute
+)
+
+// DefaultQueryTimeout is the time to wait for a response
+const DefaultQueryTimeout = 5 * time.Second
+
+// Server is a Mainline DHT node
+// More information can be found on https://www.bittorrent.org/beps/bep_0005.html
+type Server struct {
+	conn  net.PacketConn
+	id    krpc.NodeID
+	table *table
+	// want are the node families we can reach from the socket
+	want []string
+
+	// QueryTimeout is the time to wait for a response
+	QueryTimeout time.Duration
+
+	transactionID atomic.Uint32
+	mu            sync.Mutex
+	transactions  map[string]chan *krpc.Message
+
+	secretMu      sync.Mutex
+	secret        [20]byte
+	prevSecret    [20]byte
+	secretRotated time.Time
+
+	peersMu sync.Mutex
+	peers   map[handshake.Hash]map[string]storedPeer
+
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// storedPeer is a peer announced to us
+type storedPeer struct {
+	peer    peer.Peer
+	expires time.Time
+}
+
+// Listen starts a DHT node on a UDP address with a random id
+func Listen(address string) (*Server, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewServer(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewServer starts a DHT node with a random id over a packet connection
+// The connection can be shared with other protocols, as uTP
+func NewServer(conn net.PacketConn) (*Server, error) {
+	var id krpc.NodeID
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		conn:         conn,
+		id:           id,
+		table:        newTable(id),
+		want:         socketFamilies(conn.LocalAddr()),
+		QueryTimeout: DefaultQueryTimeout,
+		transactions: map[string]chan *krpc.Message{},
+		peers:        map[handshake.Hash]map[string]storedPeer{},
+		closed:       make(chan struct{}),
+	}
+	s.rotateSecret()
+	s.rotateSecret()
+
+	go s.readLoop()
+	go s.refreshLoop()
+
+	log.Info().Msgf("DHT listening on %s", conn.LocalAddr())
+	return s, nil
+}
+
+// ID returns the id of the node
+func (s *Server) ID() krpc.NodeID {
+	return s.id
+}
+
+// Addr returns the address the node is bound to
+func (s *Server) Addr() *net.UDPAddr {
+	return s.conn.LocalAddr().(*net.UDPAddr)
+}
+
+// Nodes returns the nodes from the routing table
+func (s *Server) Nodes() []krpc.NodeInfo {
+	return s.table.nodes()
+}
+
+// Close stops the node
+func (s *Server) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+	return s.conn.Close()
+}
+
+// Bootstrap joins the network from a list of known addresses
+// The addresses can be from the node cache or well known routers
+func (s *Server) Bootstrap(addrs []string) error {
+	var wg sync.WaitGroup
+	for _, address := range addrs {
+		udpAddr, err := net.ResolveUDPAddr("udp", address)
+		if err != nil {
+			log.Debug().Msgf("failed to resolve DHT node %s, err: %s", address, err)
+			continue
+		}
+
+		wg.Add(1)
+		go func(addr *net.UDPAddr) {
+			defer wg.Done()
+			_, err := s.findNode(addr, s.id)
+			if err != nil {
+				log.Debug().Msgf("failed to bootstrap from %s, err: %s", addr, err)
+			}
+		}(udpAddr)
+	}
+	wg.Wait()
+
+	if s.table.len() == 0 {
+		return errors.New("no DHT nodes answered the bootstrap")
+	}
+
+	// Find the nodes close to us
+	s.lookup(s.id, false)
+	log.Info().Msgf("DHT bootstrapped with %d nodes", s.table.len())
+	return nil
+}
+
+// Ping pings a node, adding it to the routing table if it answers
+func (s *Server) Ping(addr *net.UDPAddr) (krpc.NodeID, error) {
+	res, err := s.query(addr, krpc.QueryPing, &krpc.Args{})
+	if err != nil {
+		return krpc.NodeID{}, err
+	}
+	return krpc.ParseID(res.Return.ID)
+}
+
+// GetPeers finds the peers for a info hash
+func (s *Server) GetPeers(infoHash handshake.Hash) ([]peer.Peer, error) {
+	result := s.lookup(krpc.NodeID(infoHash), true)
+	if len(result.responders) == 0 {
+		return nil, errors.New("no DHT nodes answered the lookup")
+	}
+	return result.peers, nil
+}
+
+// Announce finds the peers for a info hash and announces that we are on the swarm
+// The peer is announced to the closest nodes that returned a token
+func (s *Server) Announce(infoHash handshake.Hash, port uint16) ([]peer.Peer, error) {
+	result := s.lookup(krpc.NodeID(infoHash), true)
+	if len(result.responders) == 0 {
+		return nil, errors.New("no DHT nodes answered the lookup")
+	}
+
+	var wg sync.WaitGroup
+	for _, responder := range result.responders {
+		if responder.token == "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(r lookupResponder) {
+			defer wg.Done()
+			_, err := s.query(r.info.Addr, krpc.QueryAnnouncePeer, &krpc.Args{
+				InfoHash: string(infoHash[:]),
+				Port:     int(port),
+				Token:    r.token,
+			})
+			if err != nil {
+				log.Debug().Msgf("failed to announce to DHT node %s, err: %s", r.info.Addr, err)
+			}
+		}(responder)
+	}
+	wg.Wait()
+
+	return result.peers, nil
+}
+
+// findNode asks a node for the nodes close to a target
+// The returned nodes are not added to the table until they answer
+func (s *Server) findNode(addr *net.UDPAddr, target krpc.NodeID) ([]krpc.NodeInfo, error) {
+	res, err := s.query(addr, krpc.QueryFindNode, &krpc.Args{Target: string(target[:]), Want: s.want})
+	if err != nil {
+		return nil, err
+	}
+	return parseNodes(res.Return)
+}
+
+// query sends a query and waits for the response
+// The id of the node is always set on the arguments
+func (s *Server) query(addr *net.UDPAddr, method string, args *krpc.Args) (*krpc.Message, error) {
+	args.ID = string(s.id[:])
+
+	transactionID := make([]byte, 4)
+	binary.BigEndian.PutUint32(transactionID, s.transactionID.Add(1))
+	msg := krpc.Message{
+		TransactionID: string(transactionID),
+		Type:          krpc.TypeQuery,
+		Query:         method,
+		Args:          args,
+	}
+	buf, err := msg.Marshal()
+	if err != nil {
+		return nil, err
+	}
+
+	// Register the transaction before sending
+	responses := make(chan *krpc.Message, 1)
+	s.mu.Lock()
+	s.transactions[msg.TransactionID] = responses
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.transactions, msg.TransactionID)
+		s.mu.Unlock()
+	}()
+
+	_, err = s.conn.WriteTo(buf, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(s.QueryTimeout)
+	defer timer.Stop()
+	select {
+	case res := <-responses:
+		if res.Type == krpc.TypeError {
+			if res.Error != nil {
+				return nil, *res.Error
+			}
+			return nil, errors.New("krpc error without details")
+		}
+		if res.Return == nil {
+			return nil, errors.New("krpc response without return values")
+		}
+
+		// Nodes that answer are added to the table
+		id, err := krpc.ParseID(res.Return.ID)
+		if err != nil {
+			return nil, err
+		}
+		s.table.add(krpc.NodeInfo{ID: id, Addr: addr})
+		return res, nil
+	case <-timer.C:
+		s.table.fail(addr)
+		return nil, errors.New("krpc query timeout")
+	case <-s.closed:
+		return nil, errors.New("dht closed")
+	}
+}
+
+// readLoop reads the packets and handles the queries and responses
+func (s *Server) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, from, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Debug().Msgf("failed to read DHT packet, err: %s", err)
+			continue
+		}
+		addr, ok := from.(*net.UDPAddr)
+		if !ok {
+			continue
+		}
+
+		msg, err := krpc.Unmarshal(buf[:n])
+		if err != nil {
+			log.Trace().Msgf("invalid DHT packet from %s, err: %s", addr, err)
+			continue
+		}
+
+		switch msg.Type {
+		case krpc.TypeQuery:
+			s.handleQuery(addr, msg)
+		case krpc.TypeResponse, krpc.TypeError:
+			s.mu.Lock()
+			responses, ok := s.transactions[msg.TransactionID]
+			s.mu.Unlock()
+			if ok {
+				select {
+				case responses <- msg:
+				default:
+				}
+			}
+		}
+	}
+}
+
+// refreshLoop refreshes the routing table from time to time
+func (s *Server) refreshLoop() {
+	ticker := time.NewTicker(refreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if s.table.len() > 0 {
+				s.lookup(s.id, false)
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// lookupResponder is a node that answered a lookup with its token
+type lookupResponder struct {
+	info  krpc.NodeInfo
+	token string
+}
+
+// lookupResult is the result of a iterative lookup
+// The responders are the closest nodes that answered
+type lookupResult struct {
+	peers      []peer.Peer
+	responders []lookupResponder
+}
+
+// lookup does a iterative lookup of the nodes closest to the target
+// With getPeers the nodes are asked for the peers of the target
+func (s *Server) lookup(target krpc.NodeID, getPeers bool) lookupResult {
+	shortlist := s.table.closest(target, K)
+	queried := map[string]bool{}
+	seenPeers := map[string]bool{}
+	result := lookupResult{}
+	var mu sync.Mutex
+
+	for round := 0; round < maxLookupRounds; round++ {
+		// Query the closest nodes that weren't queried yet
+		sortByDistance(shortlist, target)
+		candidates := []krpc.NodeInfo{}
+		for i := 0; i < len(shortlist) && i < K && len(candidates) < alpha; i++ {
+			if !queried[shortlist[i].Addr.String()] {
+				candidates = append(candidates, shortlist[i])
+			}
+		}
+		if len(candidates) == 0 {
+			break
+		}
+
+		var wg sync.WaitGroup
+		found := []krpc.NodeInfo{}
+		for _, candidate := range candidates {
+			queried[candidate.Addr.String()] = true
+
+			wg.Add(1)
+			go func(info krpc.NodeInfo) {
+				defer wg.Done()
+				nodes, values, token, err := s.lookupQuery(info.Addr, target, getPeers)
+				if err != nil {
+					return
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				found = append(found, nodes...)
+				result.responders = append(result.responders, lookupResponder{info: info, token: token})
+				for _, p := range values {
+					if !seenPeers[p.String()] {
+						seenPeers[p.String()] = true
+						result.peers = append(result.peers, p)
+					}
+				}
+			}(candidate)
+		}
+		wg.Wait()
+
+		// Add the new nodes to the shortlist
+		known := map[string]bool{}
+		for _, info := range shortlist {
+			known[info.Addr.String()] = true
+		}
+		for _, info := range found {
+			if info.ID == s.id || known[info.Addr.String()] {
+				continue
+			}
+			known[info.Addr.String()] = true
+			shortlist = append(shortlist, info)
+		}
+	}
+
+	// Keep the closest responders
+	closest := make([]krpc.NodeInfo, len(result.responders))
+	tokens := map[string]string{}
+	for i, responder := range result.responders {
+		closest[i] = responder.info
+		tokens[responder.info.Addr.String()] = responder.token
+	}
+	sortByDistance(closest, target)
+	if len(closest) > K {
+		closest = closest[:K]
+	}
+	result.responders = result.responders[:0]
+	for _, info := range closest {
+		result.responders = append(result.responders, lookupResponder{info: info, token: tokens[info.Addr.String()]})
+	}
+
+	return result
+}
+
+// lookupQuery sends a find_node or get_peers query for a lookup
+func (s *Server) lookupQuery(addr *net.UDPAddr, target krpc.NodeID, getPeers bool) ([]krpc.NodeInfo, []peer.Peer, string, error) {
+	if !getPeers {
+		nodes, err := s.findNode(addr, target)
+		return nodes, nil, "", err
+	}
+
+	res, err := s.query(addr, krpc.QueryGetPeers, &krpc.Args{InfoHash: string(target[:]), Want: s.want})
+	if err != nil {
+		return nil, nil, "", err
+	}
+	nodes, err := parseNodes(res.Return)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	values, err := krpc.UnmarshalValues(res.Return.Values)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return nodes, values, res.Return.Token, nil
+}
+
+// handleQuery answers a query from another node
+func (s *Server) handleQuery(addr *net.UDPAddr, msg *krpc.Message) {
+	if msg.Args == nil {
+		s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "missing arguments")
+		return
+	}
+	id, err := krpc.ParseID(msg.Args.ID)
+	if err != nil {
+		s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "invalid id")
+		return
+	}
+
+	ret := &krpc.Return{ID: string(s.id[:])}
+	switch msg.Query {
+	case krpc.QueryPing:
+	case krpc.QueryFindNode:
+		target, err := krpc.ParseID(msg.Args.Target)
+		if err != nil {
+			s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "invalid target")
+			return
+		}
+		s.setNodes(ret, target, addr, msg.Args.Want)
+	case krpc.QueryGetPeers:
+		infoHash, err := krpc.ParseID(msg.Args.InfoHash)
+		if err != nil {
+			s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "invalid info hash")
+			return
+		}
+		ret.Token = s.token(addr.IP, s.currentSecret())
+		for _, p := range s.storedPeers(handshake.Hash(infoHash)) {
+			// Only the peers of the node family are returned
+			if (p.IP.To4() != nil) == (addr.IP.To4() != nil) {
+				ret.Values = append(ret.Values, krpc.MarshalPeer(p))
+			}
+		}
+		if len(ret.Values) == 0 {
+			s.setNodes(ret, infoHash, addr, msg.Args.Want)
+		}
+	case krpc.QueryAnnouncePeer:
+		infoHash, err := krpc.ParseID(msg.Args.InfoHash)
+		if err != nil {
+			s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "invalid info hash")
+			return
+		}
+		if !s.validToken(addr.IP, msg.Args.Token) {
+			s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "invalid token")
+			return
+		}
+
+		// The implied port is the source port of the packet
+		port := msg.Args.Port
+		if msg.Args.ImpliedPort != 0 {
+			port = addr.Port
+		}
+		if port <= 0 || port > 65535 {
+			s.sendError(addr, msg.TransactionID, krpc.ErrorProtocol, "invalid port")
+			return
+		}
+		s.storePeer(handshake.Hash(infoHash), peer.Peer{IP: addr.IP, Port: uint16(port)})
+	default:
+		s.sendError(addr, msg.TransactionID, krpc.ErrorMethodUnknown, "method unknown")
+		return
+	}
+
+	// Nodes that query us are also added to the table
+	s.table.add(krpc.NodeInfo{ID: id, Addr: addr})
+	s.send(addr, krpc.Message{
+		TransactionID: msg.TransactionID,
+		Type:          krpc.TypeResponse,
+		Return:        ret,
+	})
+}
+
+// setNodes adds the closest nodes of the wanted families to a response
+// Without a want, the family is the one of the querying node
+func (s *Server) setNodes(ret *krpc.Return, target krpc.NodeID, addr *net.UDPAddr, want []string) {
+	if len(want) == 0 {
+		want = socketFamilies(addr)
+	}
+
+	closest := s.table.closest(target, K)
+	for _, family := range want {
+		switch family {
+		case krpc.WantNodes4:
+			ret.Nodes = krpc.MarshalNodes(closest)
+		case krpc.WantNodes6:
+			ret.Nodes6 = krpc.MarshalNodes6(closest)
+		}
+	}
+}
+
+// sendError sends a error message
+func (s *Server) sendError(addr *net.UDPAddr, transactionID string, code int, message string) {
+	s.send(addr, krpc.Message{
+		TransactionID: transactionID,
+		Type:          krpc.TypeError,
+		Error:         &krpc.Error{Code: code, Message: message},
+	})
+}
+
+// send sends a message, logging the errors
+func (s *Server) send(addr *net.UDPAddr, msg krpc.Message) {
+	buf, err := msg.Marshal()
+	if err != nil {
+		log.Warn().Msgf("failed to marshal DHT message, err: %s", err)
+		return
+	}
+	_, err = s.conn.WriteTo(buf, addr)
+	if err != nil {
+		log.Debug().Msgf("failed to send DHT message to %s, err: %s", addr, err)
+	}
+}
+
+// rotateSecret replaces the token secret, keeping the previous one
+func (s *Server) rotateSecret() {
+	s.prevSecret = s.secret
+	rand.Read(s.secret[:]) //nolint:errcheck
+	s.secretRotated = time.Now()
+}
+
+// currentSecret returns the secret for new tokens, rotating it when expired
+func (s *Server) currentSecret() [20]byte {
+	s.secretMu.Lock()
+	defer s.secretMu.Unlock()
+
+	if time.Since(s.secretRotated) > secretLifetime {
+		s.rotateSecret()
+	}
+	return s.secret
+}
+
+// token builds the token for a IP
+func (s *Server) token(ip net.IP, secret [20]byte) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	hash := sha1.Sum(append(secret[:], ip...))
+	return string(hash[:])
+}
+
+// validToken checks a token against the current and previous secrets
+func (s *Server) validToken(ip net.IP, token string) bool {
+	current := s.currentSecret()
+
+	s.secretMu.Lock()
+	previous := s.prevSecret
+	s.secretMu.Unlock()
+
+	return token == s.token(ip, current) || token == s.token(ip, previous)
+}
+
+// storePeer stores a announced peer
+func (s *Server) storePeer(infoHash handshake.Hash, p peer.Peer) {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	peers, ok := s.peers[infoHash]
+	if !ok {
+		if len(s.peers) >= maxStoredHashes {
+			return
+		}
+		peers = map[string]storedPeer{}
+		s.peers[infoHash] = peers
+	}
+	if _, ok := peers[p.String()]; !ok && len(peers) >= maxStoredPeers {
+		return
+	}
+	peers[p.String()] = storedPeer{peer: p, expires: time.Now().Add(peerLifetime)}
+}
+
+// storedPeers returns the peers announced for a info hash
+// The expired peers are removed
+func (s *Server) storedPeers(infoHash handshake.Hash) []peer.Peer {
+	s.peersMu.Lock()
+	defer s.peersMu.Unlock()
+
+	peers := []peer.Peer{}
+	for key, stored := range s.peers[infoHash] {
+		if time.Now().After(stored.expires) {
+			delete(s.peers[infoHash], key)
+			continue
+		}
+		if len(peers) < maxValues {
+			peers = append(peers, stored.peer)
+		}
+	}
+	if len(s.peers[infoHash]) == 0 {
+		delete(s.peers, infoHash)
+	}
+	return peers
+}
+
+// parseNodes parses the IPv4 and IPv6 nodes from a response
+func parseNodes(ret *krpc.Return) ([]krpc.NodeInfo, error) {
+	nodes, err := krpc.UnmarshalNodes(ret.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	nodes6, err := krpc.UnmarshalNodes6(ret.Nodes6)
+	if err != nil {
+		return nil, err
+	}
+	return append(nodes, nodes6...), nil
+}
+
+// socketFamilies returns the node families reachable from a address
+// The unspecified IPv6 address is dual-stack and reaches both
+func socketFamilies(addr net.Addr) []string {
+	udpAddr, ok := addr.(*net.UDPAddr)
+	switch {
+	case !ok || udpAddr.IP == nil:
+		return []string{krpc.WantNodes4, krpc.WantNodes6}
+	case udpAddr.IP.To4() != nil:
+		return []string{krpc.WantNodes4}
+	case udpAddr.IP.IsUnspecified():
+		return []string{krpc.WantNodes4, krpc.WantNodes6}
+	default:
+		return []string{krpc.WantNodes6}
+	}
+}
@@ -0,0 +1,155 @@
+package dht_test
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/dht"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/krpc"
+)
+
+// newSwarm starts a swarm of DHT nodes on loopback
+// Every node bootstraps from the first one
+func newSwarm(t *testing.T, size int) []*dht.Server {
+	return newSwarmOn(t, "127.0.0.1:0", size)
+}
+
+// newSwarmOn starts a swarm of DHT nodes on a address
+func newSwarmOn(t *testing.T, address string, size int) []*dht.Server {
+	nodes := make([]*dht.Server, size)
+	for i := range nodes {
+		node, err := dht.Listen(address)
+		require.NoError(t, err)
+		node.QueryTimeout = time.Second
+		t.Cleanup(func() { node.Close() })
+		nodes[i] = node
+	}
+
+	router := nodes[0].Addr().String()
+	for _, node := range nodes[1:] {
+		require.NoError(t, node.Bootstrap([]string{router}))
+	}
+	return nodes
+}
+
+// TestSwarm tests the announce and the get_peers on a local swarm
+func TestSwarm(t *testing.T) {
+	testCases := []struct {
+		name     string
+		address  string
+		expected string
+	}{
+		{
+			name:     "ipv4",
+			address:  "127.0.0.1:0",
+			expected: "127.0.0.1:6881",
+		},
+		{
+			name:     "ipv6",
+			address:  "[::1]:0",
+			expected: "[::1]:6881",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", tc.address)
+			if err != nil {
+				t.Skipf("address not available, err: %s", err)
+			}
+			conn.Close()
+
+			nodes := newSwarmOn(t, tc.address, 12)
+
+			infoHash := handshake.Hash{1, 2, 3}
+			_, err = nodes[3].Announce(infoHash, 6881)
+			require.NoError(t, err)
+
+			peers, err := nodes[len(nodes)-1].GetPeers(infoHash)
+			require.NoError(t, err)
+			require.Len(t, peers, 1)
+			require.Equal(t, tc.expected, peers[0].String())
+
+			// Other info hashes don't have peers
+			peers, err = nodes[5].GetPeers(handshake.Hash{4, 5, 6})
+			require.NoError(t, err)
+			require.Empty(t, peers)
+		})
+	}
+}
+
+// TestPing tests that nodes answering a ping are added to the table
+func TestPing(t *testing.T) {
+	nodes := newSwarm(t, 2)
+
+	id, err := nodes[1].Ping(nodes[0].Addr())
+	require.NoError(t, err)
+	require.Equal(t, nodes[0].ID(), id)
+
+	// A address without a node times out
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = nodes[1].Ping(conn.LocalAddr().(*net.UDPAddr))
+	require.ErrorContains(t, err, "timeout")
+}
+
+// TestInvalidAnnounce tests that announces without a valid token are rejected
+func TestInvalidAnnounce(t *testing.T) {
+	node, err := dht.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer node.Close()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer conn.Close()
+
+	query := krpc.Message{
+		TransactionID: "aa",
+		Type:          krpc.TypeQuery,
+		Query:         krpc.QueryAnnouncePeer,
+		Args: &krpc.Args{
+			ID:       string(make([]byte, 20)),
+			InfoHash: string(make([]byte, 20)),
+			Port:     6881,
+			Token:    "invalid",
+		},
+	}
+	buf, err := query.Marshal()
+	require.NoError(t, err)
+	_, err = conn.WriteToUDP(buf, node.Addr())
+	require.NoError(t, err)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	res := make([]byte, 1500)
+	n, err := conn.Read(res)
+	require.NoError(t, err)
+
+	msg, err := krpc.Unmarshal(res[:n])
+	require.NoError(t, err)
+	require.Equal(t, krpc.TypeError, msg.Type)
+	require.Equal(t, krpc.ErrorProtocol, msg.Error.Code)
+}
+
+// TestNodeCache tests that the routing table can be saved and loaded
+func TestNodeCache(t *testing.T) {
+	nodes := newSwarm(t, 3)
+	path := filepath.Join(t.TempDir(), "dht", "nodes.json")
+
+	require.NoError(t, nodes[1].SaveNodes(path))
+	addrs, err := dht.LoadNodes(path)
+	require.NoError(t, err)
+	require.Contains(t, addrs, nodes[0].Addr().String())
+
+	// A new node can bootstrap from the cache
+	node, err := dht.Listen("127.0.0.1:0")
+	require.NoError(t, err)
+	defer node.Close()
+	require.NoError(t, node.Bootstrap(addrs))
+	require.NotEmpty(t, node.Nodes())
+}
@@ -0,0 +1,165 @@
+package dht
+
+import (
+	"bytes"
+	"math/bits"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/krpc"
+)
+
+const (
+	// K is the max number of nodes on each bucket and on lookups
+	K = 8
+	// staleAfter is the time without answers before a node can be replaced
+	staleAfter = 15 * time.Minute
+	// maxFailures is the number of failed queries before a node is removed
+	maxFailures = 3
+)
+
+// node is a node on the routing table
+type node struct {
+	info     krpc.NodeInfo
+	lastSeen time.Time
+	failures int
+}
+
+// table is the Kademlia routing table
+// Bucket i has the nodes sharing i prefix bits with our id
+type table struct {
+	self krpc.NodeID
+
+	mu      sync.Mutex
+	buckets [160][]*node
+}
+
+// newTable creates a empty routing table for a id
+func newTable(self krpc.NodeID) *table {
+	return &table{self: self}
+}
+
+// distance returns the XOR distance between two ids
+func distance(a, b krpc.NodeID) krpc.NodeID {
+	var d krpc.NodeID
+	for i := range a {
+		d[i] = a[i] ^ b[i]
+	}
+	return d
+}
+
+// bucketIndex returns the bucket for a id, -1 for our own id
+func (t *table) bucketIndex(id krpc.NodeID) int {
+	d := distance(t.self, id)
+	for i, b := range d {
+		if b != 0 {
+			return i*8 + bits.LeadingZeros8(b)
+		}
+	}
+	return -1
+}
+
+// add adds or refreshes a node that answered us
+// Full buckets only accept a new node in place of a stale one
+func (t *table) add(info krpc.NodeInfo) bool {
+	index := t.bucketIndex(info.ID)
+	if index < 0 || info.Addr == nil {
+		return false
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	bucket := t.buckets[index]
+	for i, n := range bucket {
+		if n.info.ID == info.ID {
+			n.info = info
+			n.lastSeen = time.Now()
+			n.failures = 0
+			// Most recently seen nodes are at the end
+			t.buckets[index] = append(append(bucket[:i:i], bucket[i+1:]...), n)
+			return true
+		}
+	}
+
+	newNode := &node{info: info, lastSeen: time.Now()}
+	if len(bucket) < K {
+		t.buckets[index] = append(bucket, newNode)
+		return true
+	}
+
+	for i, n := range bucket {
+		if n.failures > 0 || time.Since(n.lastSeen) > staleAfter {
+			bucket[i] = newNode
+			return true
+		}
+	}
+	return false
+}
+
+// fail flags a failed query to a address
+// Nodes are removed after too many failures
+func (t *table) fail(addr *net.UDPAddr) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for index, bucket := range t.buckets {
+		for i, n := range bucket {
+			if !n.info.Addr.IP.Equal(addr.IP) || n.info.Addr.Port != addr.Port {
+				continue
+			}
+			n.failures++
+			if n.failures >= maxFailures {
+				t.buckets[index] = append(bucket[:i:i], bucket[i+1:]...)
+			}
+			return
+		}
+	}
+}
+
+// closest returns up to n nodes closest to the target
+func (t *table) closest(target krpc.NodeID, n int) []krpc.NodeInfo {
+	nodes := t.nodes()
+	sortByDistance(nodes, target)
+	if len(nodes) > n {
+		nodes = nodes[:n]
+	}
+	return nodes
+}
+
+// nodes returns all the nodes from the table
+func (t *table) nodes() []krpc.NodeInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	nodes := []krpc.NodeInfo{}
+	for _, bucket := range t.buckets {
+		for _, n := range bucket {
+			nodes = append(nodes, n.info)
+		}
+	}
+	return nodes
+}
+
+// len returns the number of nodes on the table
+func (t *table) len() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	total := 0
+	for _, bucket := range t.buckets {
+		total += len(bucket)
+	}
+	return total
+}
+
+// sortByDistance sorts the nodes by the distance to the target
+func sortByDistance(nodes []krpc.NodeInfo, target krpc.NodeID) {
+	sort.Slice(nodes, func(i, j int) bool {
+		di := distance(nodes[i].ID, target)
+		dj := distance(nodes[j].ID, target)
+		return bytes.Compare(di[:], dj[:]) < 0
+	})
+}
@@ -5,14 +5,32 @@ import (
 )
 
 // CreateFileWithSize creates a new file with size filled empty 0 bytes
+// Existing files keep their data and are only resized if needed
 // Since we will be writing chunks, we don't close the file
 func CreateFileWithSize(filename string, size int64) (*os.File, error) {
-	// Create the file or truncate the existing one
-	file, err := os.Create(filename)
+	// Create the file or open the existing one
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE, 0o666)
 	if err != nil {
 		return file, err
 	}
 
+	// Existing files with the wrong size are resized
+	info, err := file.Stat()
+	if err != nil {
+		return file, err
+	}
+	if info.Size() != 0 {
+		if info.Size() != size {
+			err = file.Truncate(size)
+		}
+		return file, err
+	}
+
+	// Empty files don't need to be filled
+	if size == 0 {
+		return file, nil
+	}
+
 	// Seek to the desired size minus one
 	_, err = file.Seek(size-1, 0)
 	if err != nil {
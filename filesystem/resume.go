@@ -0,0 +1,88 @@
+package filesystem
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// FileState is the size and modification time of a file
+// It's used to detect changes on the files since the last save
+type FileState struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mod_time"`
+}
+
+// ResumeData is the fast resume state of a download
+// The bitfield is only valid if the files didn't change
+type ResumeData struct {
+	Bitfield []byte      `json:"bitfield"`
+	Files    []FileState `json:"files"`
+}
+
+// FileStates returns the current state for all the files of the storage
+func (s *Storage) FileStates() ([]FileState, error) {
+	states := make([]FileState, len(s.files))
+	for i, f := range s.files {
+		info, err := f.file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		states[i] = FileState{
+			Path:    f.entry.Path,
+			Size:    info.Size(),
+			ModTime: info.ModTime().UnixNano(),
+		}
+	}
+	return states, nil
+}
+
+// Matches returns if the resume data was saved with the same file states
+func (r ResumeData) Matches(states []FileState) bool {
+	if len(r.Files) != len(states) {
+		return false
+	}
+	for i, state := range states {
+		if r.Files[i] != state {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadResume reads a fast resume file
+func LoadResume(path string) (*ResumeData, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data := ResumeData{}
+	err = json.Unmarshal(raw, &data)
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// SaveResume writes a fast resume file
+// The data is written to a temporary file first, so a crash never leaves a partial file
+func SaveResume(path string, data ResumeData) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := path + ".tmp"
+	err = os.WriteFile(tmpPath, raw, 0o644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
@@ -0,0 +1,139 @@
+package filesystem
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// FileEntry is a single file to be stored
+// The offset is where the file starts on the torrent byte space
+type FileEntry struct {
+	Path   string
+	Length int64
+	Offset int64
+}
+
+// storageFile is a opened file from the storage
+type storageFile struct {
+	entry FileEntry
+	file  *os.File
+}
+
+// Storage maps the continuous byte space of a torrent into a list of files
+type Storage struct {
+	files []storageFile
+}
+
+// NewStorage creates all the files with their directories and sizes
+// The entries must be sorted by offset
+func NewStorage(entries []FileEntry) (*Storage, error) {
+	return buildStorage(entries, func(entry FileEntry) (*os.File, error) {
+		// Create the directory tree for the file
+		err := os.MkdirAll(filepath.Dir(entry.Path), os.ModePerm)
+		if err != nil {
+			return nil, err
+		}
+
+		return CreateFileWithSize(entry.Path, entry.Length)
+	})
+}
+
+// OpenStorage opens the existing files of a storage as read only
+// This is used to share data that has already been downloaded
+func OpenStorage(entries []FileEntry) (*Storage, error) {
+	return buildStorage(entries, func(entry FileEntry) (*os.File, error) {
+		return os.Open(entry.Path)
+	})
+}
+
+// buildStorage builds a storage opening each file with the open function
+// All the opened files are closed if any of them fails
+func buildStorage(entries []FileEntry, open func(entry FileEntry) (*os.File, error)) (*Storage, error) {
+	storage := &Storage{}
+	for _, entry := range entries {
+		file, err := open(entry)
+		if err != nil {
+			storage.Close() //nolint:errcheck
+			return nil, err
+		}
+
+		storage.files = append(storage.files, storageFile{
+			entry: entry,
+			file:  file,
+		})
+	}
+
+	return storage, nil
+}
+
+// WriteAt writes a buffer at a offset of the torrent byte space
+// The buffer can span multiple files
+func (s *Storage) WriteAt(buf []byte, offset int64) error {
+	return s.forEachSpan(buf, offset, func(file *os.File, chunk []byte, fileOffset int64) error {
+		return WriteFileChunk(file, chunk, fileOffset)
+	})
+}
+
+// ReadAt reads into a buffer from a offset of the torrent byte space
+// The buffer can span multiple files
+func (s *Storage) ReadAt(buf []byte, offset int64) error {
+	return s.forEachSpan(buf, offset, func(file *os.File, chunk []byte, fileOffset int64) error {
+		_, err := file.ReadAt(chunk, fileOffset)
+		return err
+	})
+}
+
+// forEachSpan splits a buffer in the parts that belong to each file
+// and calls fn for each one of them
+func (s *Storage) forEachSpan(
+	buf []byte,
+	offset int64,
+	fn func(file *os.File, chunk []byte, fileOffset int64) error,
+) error {
+	end := offset + int64(len(buf))
+	cursor := offset
+	for _, f := range s.files {
+		fileBegin := f.entry.Offset
+		fileEnd := f.entry.Offset + f.entry.Length
+
+		// Skip files outside the range
+		if fileEnd <= cursor || fileBegin >= end {
+			continue
+		}
+
+		// Calculate the overlap between the buffer and the file
+		begin := cursor
+		if fileBegin > begin {
+			begin = fileBegin
+		}
+		stop := end
+		if fileEnd < stop {
+			stop = fileEnd
+		}
+
+		err := fn(f.file, buf[begin-offset:stop-offset], begin-fileBegin)
+		if err != nil {
+			return err
+		}
+		cursor = stop
+	}
+
+	// The buffer must be fully used
+	if cursor < end {
+		return fmt.Errorf("range %d-%d out of the storage bounds", cursor, end)
+	}
+
+	return nil
+}
+
+// Close closes all the files from the storage
+func (s *Storage) Close() error {
+	var firstErr error
+	for _, f := range s.files {
+		if err := f.file.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,56 @@
+package filesystem_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/filesystem"
+)
+
+// TestStorage tests the writes across files and the reopening of existing data
+func TestStorage(t *testing.T) {
+	dir := t.TempDir()
+	entries := []filesystem.FileEntry{
+		{Path: filepath.Join(dir, "a"), Length: 4, Offset: 0},
+		{Path: filepath.Join(dir, "empty"), Length: 0, Offset: 4},
+		{Path: filepath.Join(dir, "sub", "b"), Length: 6, Offset: 4},
+	}
+
+	storage, err := filesystem.NewStorage(entries)
+	require.NoError(t, err)
+
+	// A write spanning all the files
+	require.NoError(t, storage.WriteAt([]byte("0123456789"), 0))
+	require.ErrorContains(t, storage.WriteAt([]byte("ab"), 9), "out of the storage bounds")
+
+	states, err := storage.FileStates()
+	require.NoError(t, err)
+	require.NoError(t, storage.Close())
+
+	// Creating the storage again keeps the data
+	storage, err = filesystem.NewStorage(entries)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	buf := make([]byte, 5)
+	require.NoError(t, storage.ReadAt(buf, 2))
+	require.Equal(t, "23456", string(buf))
+
+	// The resume data matches until a file changes
+	data := filesystem.ResumeData{Bitfield: []byte{0x80}, Files: states}
+	resumePath := filepath.Join(dir, "resume", "test.json")
+	require.NoError(t, filesystem.SaveResume(resumePath, data))
+
+	loaded, err := filesystem.LoadResume(resumePath)
+	require.NoError(t, err)
+	require.Equal(t, data, *loaded)
+
+	current, err := storage.FileStates()
+	require.NoError(t, err)
+	require.True(t, loaded.Matches(current))
+
+	current[2].Size++
+	require.False(t, loaded.Matches(current))
+}
@@ -3,7 +3,6 @@ module github.com/jhelison/go-torrent
 go 1.20
 
 require (
-	github.com/jackpal/bencode-go v1.0.0
 	github.com/rs/zerolog v1.31.0
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/viper v1.18.2
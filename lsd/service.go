@@ -0,0 +1,202 @@
+package lsd
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/logger"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	lsdmsg "github.com/jhelison/go-torrent/marshallers/lsd"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+var (
+	// Default logger
+	log = logger.GetLogger()
+)
+
+// The multicast groups for the local service discovery
+// More information can be found on https://www.bittorrent.org/beps/bep_0014.html
+var (
+	Group4 = &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: 6771}
+	Group6 = &net.UDPAddr{IP: net.ParseIP("ff15::efc0:988f"), Port: 6771}
+)
+
+const (
+	// MinAnnounceInterval is the min time between two announces of a info hash
+	MinAnnounceInterval = time.Minute
+	// maxPacketSize is the biggest announce we read
+	maxPacketSize = 1400
+	// subscriberBuffer is the number of pending peer lists per subscriber
+	subscriberBuffer = 16
+)
+
+// groupConn is a connection joined to a multicast group
+type groupConn struct {
+	conn  *net.UDPConn
+	group *net.UDPAddr
+}
+
+// Service announces and discovers the peers on the local network
+// Our own announces are filtered by a random cookie
+type Service struct {
+	conns  []groupConn
+	cookie string
+
+	mu            sync.Mutex
+	subscribers   map[handshake.Hash]chan []peer.Peer
+	lastAnnounces map[handshake.Hash]time.Time
+}
+
+// Listen joins the multicast groups
+// It only fails if no group can be joined
+func Listen(groups ...*net.UDPAddr) (*Service, error) {
+	var cookie [8]byte
+	_, err := rand.Read(cookie[:])
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Service{
+		cookie:        hex.EncodeToString(cookie[:]),
+		subscribers:   map[handshake.Hash]chan []peer.Peer{},
+		lastAnnounces: map[handshake.Hash]time.Time{},
+	}
+
+	var errs []error
+	for _, group := range groups {
+		network := "udp4"
+		if group.IP.To4() == nil {
+			network = "udp6"
+		}
+
+		conn, err := net.ListenMulticastUDP(network, nil, group)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		s.conns = append(s.conns, groupConn{conn: conn, group: group})
+		log.Info().Msgf("Local service discovery listening on %s", group)
+	}
+	if len(s.conns) == 0 {
+		return nil, errors.Join(append(errs, errors.New("no multicast group joined"))...)
+	}
+
+	for _, gc := range s.conns {
+		go s.readLoop(gc)
+	}
+	return s, nil
+}
+
+// Close leaves the multicast groups
+func (s *Service) Close() error {
+	var errs []error
+	for _, gc := range s.conns {
+		errs = append(errs, gc.conn.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// Subscribe returns a channel with the local peers of a info hash
+func (s *Service) Subscribe(infoHash handshake.Hash) <-chan []peer.Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make(chan []peer.Peer, subscriberBuffer)
+	s.subscribers[infoHash] = peers
+	return peers
+}
+
+// Unsubscribe stops sending the local peers of a info hash
+func (s *Service) Unsubscribe(infoHash handshake.Hash) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, infoHash)
+}
+
+// Announce sends a announce for a info hash to all the groups
+// Announces are skipped if the info hash was announced in the last minute
+func (s *Service) Announce(infoHash handshake.Hash, port uint16) error {
+	s.mu.Lock()
+	if time.Since(s.lastAnnounces[infoHash]) < MinAnnounceInterval {
+		s.mu.Unlock()
+		return nil
+	}
+	s.lastAnnounces[infoHash] = time.Now()
+	s.mu.Unlock()
+
+	var errs []error
+	for _, gc := range s.conns {
+		announce := lsdmsg.Announce{
+			Host:       gc.group.String(),
+			Port:       port,
+			InfoHashes: []handshake.Hash{infoHash},
+			Cookie:     s.cookie,
+		}
+		_, err := gc.conn.WriteToUDP(announce.Marshal(), gc.group)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// Only fail if no group received the announce
+	if len(errs) == len(s.conns) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// readLoop reads the announces until the connection is closed
+func (s *Service) readLoop(gc groupConn) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := gc.conn.ReadFromUDP(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Debug().Msgf("failed to read local announce, err: %s", err)
+			continue
+		}
+
+		announce, err := lsdmsg.Unmarshal(buf[:n])
+		if err != nil {
+			log.Debug().Msgf("invalid local announce from %s, err: %s", addr, err)
+			continue
+		}
+		if announce.Cookie == s.cookie {
+			continue
+		}
+
+		s.handleAnnounce(announce, addr)
+	}
+}
+
+// handleAnnounce sends the announcing peer to the subscribers of its info hashes
+func (s *Service) handleAnnounce(announce *lsdmsg.Announce, addr *net.UDPAddr) {
+	ip := addr.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	local := peer.Peer{IP: ip, Port: announce.Port}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, infoHash := range announce.InfoHashes {
+		peers, ok := s.subscribers[infoHash]
+		if !ok {
+			continue
+		}
+
+		select {
+		case peers <- []peer.Peer{local}:
+			log.Debug().Msgf("Found local peer %s", local)
+		default:
+		}
+	}
+}
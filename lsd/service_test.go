@@ -0,0 +1,47 @@
+package lsd_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/lsd"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+)
+
+// TestService tests the announces between two services on the same group
+func TestService(t *testing.T) {
+	group := &net.UDPAddr{IP: net.IPv4(239, 192, 152, 143), Port: 16771}
+
+	first, err := lsd.Listen(group)
+	if err != nil {
+		t.Skipf("multicast not available, err: %s", err)
+	}
+	defer first.Close()
+	second, err := lsd.Listen(group)
+	require.NoError(t, err)
+	defer second.Close()
+
+	infoHash := handshake.Hash{1}
+	firstPeers := first.Subscribe(infoHash)
+	secondPeers := second.Subscribe(infoHash)
+
+	require.NoError(t, first.Announce(infoHash, 6881))
+
+	select {
+	case peers := <-secondPeers:
+		require.Len(t, peers, 1)
+		require.Equal(t, uint16(6881), peers[0].Port)
+	case <-time.After(500 * time.Millisecond):
+		t.Skip("multicast loopback not available")
+	}
+
+	// Our own announces are ignored
+	select {
+	case <-firstPeers:
+		t.Fatal("received our own announce")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -1,44 +1,169 @@
 package bencode
 
 import (
-	"bytes"
 	"crypto/sha1"
+	"errors"
 	"fmt"
 	"io"
-
-	"github.com/jackpal/bencode-go"
+	"net"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jhelison/go-torrent/marshallers/handshake"
 )
 
 type bencodeTorrent struct {
-	Announce string      `bencode:"announce"`
-	Info     bencodeInfo `bencode:"info"`
+	Announce     string     `bencode:"announce,omitempty"`
+	AnnounceList [][]string `bencode:"announce-list,omitempty"`
+	Comment      string     `bencode:"comment,omitempty"`
+	CreatedBy    string     `bencode:"created by,omitempty"`
+	CreationDate int64      `bencode:"creation date,omitempty"`
+	URLList      urlList    `bencode:"url-list,omitempty"`
+	Nodes        dhtNodes   `bencode:"nodes,omitempty"`
+	RawInfo      RawMessage `bencode:"info"`
+
+	// Info is decoded from the raw info
+	// The raw bytes are kept, so keys not modeled by the struct are on the info hash
+	Info bencodeInfo `bencode:"-"`
 }
 
 type bencodeInfo struct {
-	Pieces       string `bencode:"pieces"`
-	PiecesLength int    `bencode:"piece length"`
-	Length       int    `bencode:"length"`
-	Name         string `bencode:"name"`
+	Pieces       string        `bencode:"pieces"`
+	PiecesLength int           `bencode:"piece length"`
+	Length       int           `bencode:"length,omitempty"`
+	Name         string        `bencode:"name"`
+	Files        []bencodeFile `bencode:"files,omitempty"`
+	Private      int           `bencode:"private,omitempty"`
+}
+
+// bencodeFile is a single file entry from a multi-file torrent
+type bencodeFile struct {
+	Length int      `bencode:"length"`
+	Path   []string `bencode:"path"`
+}
+
+// urlList is the list of web seeds
+// Torrents with a single web seed may have it as a string
+type urlList []string
+
+// UnmarshalBencode decodes a string or a list of strings
+func (u *urlList) UnmarshalBencode(data []byte) error {
+	if len(data) > 0 && data[0] == 'l' {
+		return DecodeBytes(data, (*[]string)(u))
+	}
+
+	var single string
+	err := DecodeBytes(data, &single)
+	if err != nil {
+		return err
+	}
+	*u = urlList{single}
+	return nil
+}
+
+// dhtNodes are the DHT nodes of trackerless torrents
+// Each node is a list with the host and the port
+type dhtNodes []string
+
+// UnmarshalBencode decodes the nodes into host:port addresses
+// Invalid nodes are skipped
+func (n *dhtNodes) UnmarshalBencode(data []byte) error {
+	list := [][]interface{}{}
+	err := DecodeBytes(data, &list)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range list {
+		if len(node) != 2 {
+			continue
+		}
+		host, ok := node[0].(string)
+		if !ok {
+			continue
+		}
+		port, ok := node[1].(int64)
+		if !ok || port <= 0 || port > 65535 {
+			continue
+		}
+		*n = append(*n, net.JoinHostPort(host, strconv.FormatInt(port, 10)))
+	}
+	return nil
+}
+
+// MarshalBencode encodes the nodes as lists with the host and the port
+func (n dhtNodes) MarshalBencode() ([]byte, error) {
+	list := make([][]interface{}, 0, len(n))
+	for _, node := range n {
+		host, port, err := net.SplitHostPort(node)
+		if err != nil {
+			return nil, err
+		}
+		portNumber, err := strconv.Atoi(port)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, []interface{}{host, portNumber})
+	}
+	return EncodeBytes(list)
 }
 
 // Unmarshal reads a stream and translates into bencode torrent
 func Unmarshal(r io.Reader) (*bencodeTorrent, error) {
 	becodeT := bencodeTorrent{}
-	err := bencode.Unmarshal(r, &becodeT)
+	err := NewDecoder(r).Decode(&becodeT)
+	if err != nil {
+		return nil, err
+	}
+	if len(becodeT.RawInfo) == 0 {
+		return nil, errors.New("torrent without an info dictionary")
+	}
+
+	err = DecodeBytes(becodeT.RawInfo, &becodeT.Info)
 	if err != nil {
 		return nil, err
 	}
 	return &becodeT, nil
 }
 
+// Marshal writes the bencoded torrent into a stream
+// The dictionary keys are always sorted, as required by the spec
+func (bt bencodeTorrent) Marshal(w io.Writer) error {
+	return NewEncoder(w).Encode(bt)
+}
+
+// UnmarshalInfo reads a stream with only the info dictionary
+// This is used when the info is received from peers, as with magnet links
+func UnmarshalInfo(r io.Reader, announce string) (*bencodeTorrent, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The whole data must be a single dictionary
+	info := bencodeInfo{}
+	err = DecodeBytes(data, &info)
+	if err != nil {
+		return nil, err
+	}
+	return &bencodeTorrent{
+		Announce: announce,
+		RawInfo:  data,
+		Info:     info,
+	}, nil
+}
+
 // ToTorrentFile transforms a bencodeTorrent into a torrentFile object
 func (bt bencodeTorrent) ToTorrentFile() (TorrentFile, error) {
-	// Takes the hash from the info
-	infoHash, err := bt.Info.hash()
-	if err != nil {
-		return TorrentFile{}, err
+	// Takes the hash from the exact info bytes
+	if len(bt.RawInfo) == 0 {
+		return TorrentFile{}, errors.New("torrent without an info dictionary")
+	}
+	infoHash := sha1.Sum(bt.RawInfo)
+
+	if bt.Info.PiecesLength <= 0 {
+		return TorrentFile{}, fmt.Errorf("invalid piece length %d", bt.Info.PiecesLength)
 	}
 
 	// Split the info into pieces
@@ -47,25 +172,41 @@ func (bt bencodeTorrent) ToTorrentFile() (TorrentFile, error) {
 		return TorrentFile{}, err
 	}
 
-	return TorrentFile{
-		Announce:    bt.Announce,
-		Name:        bt.Info.Name,
-		Length:      bt.Info.Length,
-		PieceLength: bt.Info.PiecesLength,
-		InfoHash:    infoHash,
-		PieceHashes: pieceHashes,
-	}, nil
-}
-
-// hash hashes a bencodeInfo
-func (bi bencodeInfo) hash() ([20]byte, error) {
-	// Re-encode the struct
-	var bencodedInfo bytes.Buffer
-	err := bencode.Marshal(&bencodedInfo, bi)
+	// Build the file list with the offsets
+	files, length, err := bt.Info.buildFiles()
 	if err != nil {
-		return [20]byte{}, err
+		return TorrentFile{}, err
+	}
+
+	// The pieces must cover exactly the length of the torrent
+	nPieces := (length + bt.Info.PiecesLength - 1) / bt.Info.PiecesLength
+	if len(pieceHashes) != nPieces {
+		return TorrentFile{}, fmt.Errorf("expected %d piece hashes for length %d, got %d", nPieces, length, len(pieceHashes))
+	}
+
+	// The creation date is optional
+	creationDate := time.Time{}
+	if bt.CreationDate > 0 {
+		creationDate = time.Unix(bt.CreationDate, 0)
 	}
-	return sha1.Sum(bencodedInfo.Bytes()), nil
+
+	return TorrentFile{
+		Announce:     bt.Announce,
+		AnnounceList: bt.AnnounceList,
+		Comment:      bt.Comment,
+		CreatedBy:    bt.CreatedBy,
+		CreationDate: creationDate,
+		WebSeeds:     []string(bt.URLList),
+		Nodes:        []string(bt.Nodes),
+		Private:      bt.Info.Private == 1,
+		Name:         bt.Info.Name,
+		Length:       length,
+		PieceLength:  bt.Info.PiecesLength,
+		InfoHash:     infoHash,
+		Info:         bt.RawInfo,
+		PieceHashes:  pieceHashes,
+		Files:        files,
+	}, nil
 }
 
 // splitPieceHashes splits the info into hashes
@@ -90,3 +231,63 @@ func (bi bencodeInfo) splitPieceHashes() ([]handshake.Hash, error) {
 
 	return pieceHashes, nil
 }
+
+// buildFiles builds the list of files for the info with their offsets
+// Single file torrents return a single file named after the torrent
+// Multi-file torrents have all the paths rooted on the torrent name
+// It returns the files and the total length of the torrent
+func (bi bencodeInfo) buildFiles() ([]File, int, error) {
+	if err := validatePathComponent(bi.Name); err != nil {
+		return nil, 0, err
+	}
+
+	// Single file torrent
+	if len(bi.Files) == 0 {
+		if bi.Length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %d", bi.Length)
+		}
+		return []File{{
+			Path:   []string{bi.Name},
+			Length: bi.Length,
+			Offset: 0,
+		}}, bi.Length, nil
+	}
+
+	// Multi-file torrent, the files are continuous on the piece space
+	files := make([]File, len(bi.Files))
+	offset := 0
+	for i, file := range bi.Files {
+		if len(file.Path) == 0 {
+			return nil, 0, fmt.Errorf("empty path for file %d", i)
+		}
+		if file.Length < 0 {
+			return nil, 0, fmt.Errorf("invalid length %d for file %d", file.Length, i)
+		}
+		for _, component := range file.Path {
+			if err := validatePathComponent(component); err != nil {
+				return nil, 0, err
+			}
+		}
+
+		files[i] = File{
+			Path:   append([]string{bi.Name}, file.Path...),
+			Length: file.Length,
+			Offset: offset,
+		}
+		offset += file.Length
+	}
+
+	return files, offset, nil
+}
+
+// validatePathComponent checks that a path component can't escape
+// the output directory
+func validatePathComponent(component string) error {
+	if component == "" || component == "." || component == ".." {
+		return fmt.Errorf("invalid path component %q", component)
+	}
+	if strings.ContainsAny(component, "/\\") {
+		return fmt.Errorf("invalid path component %q", component)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package bencode_test
+
+import (
+	"crypto/sha1"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+)
+
+// TestToTorrentFile tests the parsing of single and multi-file torrents
+func TestToTorrentFile(t *testing.T) {
+	pieces := strings.Repeat("a", 20)
+
+	testCases := []struct {
+		name        string
+		info        string
+		length      int
+		files       []bencode.File
+		errContains string
+	}{
+		{
+			name:   "single file",
+			info:   "d6:lengthi10e4:name4:test12:piece lengthi16e6:pieces20:" + pieces + "e",
+			length: 10,
+			files: []bencode.File{
+				{Path: []string{"test"}, Length: 10, Offset: 0},
+			},
+		},
+		{
+			name: "multi file",
+			info: "d5:filesld6:lengthi6e4:pathl1:aeed6:lengthi4e4:pathl3:sub1:beee" +
+				"4:name4:test12:piece lengthi16e6:pieces20:" + pieces + "e",
+			length: 10,
+			files: []bencode.File{
+				{Path: []string{"test", "a"}, Length: 6, Offset: 0},
+				{Path: []string{"test", "sub", "b"}, Length: 4, Offset: 6},
+			},
+		},
+		{
+			name: "unknown keys",
+			info: "d6:lengthi10e6:md5sum4:abcd4:name4:test12:piece lengthi16e6:pieces20:" + pieces +
+				"6:source8:internale",
+			length: 10,
+			files: []bencode.File{
+				{Path: []string{"test"}, Length: 10, Offset: 0},
+			},
+		},
+		{
+			name: "path traversal",
+			info: "d5:filesld6:lengthi6e4:pathl2:..1:aeee" +
+				"4:name4:test12:piece lengthi16e6:pieces20:" + pieces + "e",
+			errContains: "invalid path component",
+		},
+		{
+			name:        "zero piece length",
+			info:        "d6:lengthi10e4:name4:test12:piece lengthi0e6:pieces20:" + pieces + "e",
+			errContains: "invalid piece length 0",
+		},
+		{
+			name:        "negative piece length",
+			info:        "d6:lengthi10e4:name4:test12:piece lengthi-16e6:pieces20:" + pieces + "e",
+			errContains: "invalid piece length -16",
+		},
+		{
+			name:        "negative length",
+			info:        "d6:lengthi-10e4:name4:test12:piece lengthi16e6:pieces20:" + pieces + "e",
+			errContains: "invalid length -10",
+		},
+		{
+			name:        "too many pieces",
+			info:        "d6:lengthi10e4:name4:test12:piece lengthi16e6:pieces40:" + pieces + pieces + "e",
+			errContains: "expected 1 piece hashes for length 10, got 2",
+		},
+		{
+			name:        "missing pieces",
+			info:        "d6:lengthi40e4:name4:test12:piece lengthi16e6:pieces20:" + pieces + "e",
+			errContains: "expected 3 piece hashes for length 40, got 1",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := "d8:announce16:http://test.org/4:info" + tc.info + "e"
+
+			torrent, err := bencode.Unmarshal(strings.NewReader(raw))
+			require.NoError(t, err)
+
+			torrentFile, err := torrent.ToTorrentFile()
+			if tc.errContains == "" {
+				require.NoError(t, err)
+
+				require.Equal(t, tc.length, torrentFile.Length)
+				require.Equal(t, tc.files, torrentFile.Files)
+				require.Equal(t, [20]byte(sha1.Sum([]byte(tc.info))), torrentFile.InfoHash)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestUnmarshalInvalid tests torrents that can't be parsed
+func TestUnmarshalInvalid(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		errContains string
+	}{
+		{
+			name:        "not a dictionary",
+			raw:         "l4:teste",
+			errContains: "cannot decode list",
+		},
+		{
+			name:        "truncated info",
+			raw:         "d4:infod4:name10:teste",
+			errContains: "unexpected EOF",
+		},
+		{
+			name:        "unsorted keys",
+			raw:         "d4:infod4:name4:teste8:announce16:http://test.org/e",
+			errContains: "unsorted dictionary key",
+		},
+		{
+			name:        "missing info",
+			raw:         "d8:announce16:http://test.org/e",
+			errContains: "without an info dictionary",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bencode.Unmarshal(strings.NewReader(tc.raw))
+			require.ErrorContains(t, err, tc.errContains)
+		})
+	}
+}
+
+// TestUnmarshalInfo tests that the info hash of received info is taken from the exact bytes
+func TestUnmarshalInfo(t *testing.T) {
+	info := "d6:lengthi10e4:name4:test12:piece lengthi16e6:pieces20:" + strings.Repeat("a", 20) +
+		"6:source8:internale"
+
+	torrent, err := bencode.UnmarshalInfo(strings.NewReader(info), "")
+	require.NoError(t, err)
+	torrentFile, err := torrent.ToTorrentFile()
+	require.NoError(t, err)
+	require.Equal(t, [20]byte(sha1.Sum([]byte(info))), torrentFile.InfoHash)
+
+	_, err = bencode.UnmarshalInfo(strings.NewReader(info+"extra"), "")
+	require.Error(t, err)
+}
+
+// TestNodes tests the DHT nodes of trackerless torrents
+func TestNodes(t *testing.T) {
+	info := "d6:lengthi10e4:name4:test12:piece lengthi16e6:pieces20:" + strings.Repeat("a", 20) + "e"
+	raw := "d4:info" + info + "5:nodesll9:127.0.0.1i6881eel3:::1i6882eel7:invalidee" + "e"
+
+	torrent, err := bencode.Unmarshal(strings.NewReader(raw))
+	require.NoError(t, err)
+	torrentFile, err := torrent.ToTorrentFile()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"127.0.0.1:6881", "[::1]:6882"}, torrentFile.Nodes)
+	require.Empty(t, torrentFile.Tiers())
+}
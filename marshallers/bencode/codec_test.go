@@ -0,0 +1,202 @@
+package bencode_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	bencoderesponse "github.com/jhelison/go-torrent/marshallers/bencode_response"
+)
+
+// codecStruct is used to test the struct tags
+type codecStruct struct {
+	Name     string             `bencode:"name"`
+	Count    int                `bencode:"count,omitempty"`
+	Unsigned uint8              `bencode:"unsigned,omitempty"`
+	Items    []string           `bencode:"items,omitempty"`
+	Extra    map[string]int     `bencode:"extra,omitempty"`
+	Raw      bencode.RawMessage `bencode:"raw,omitempty"`
+	Skipped  string             `bencode:"-"`
+}
+
+// TestDecode tests the decoding of valid and invalid values
+func TestDecode(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    codecStruct
+		lossy       bool
+		errContains string
+	}{
+		{
+			name:     "all fields",
+			raw:      "d5:counti-3e5:extrad1:ai1ee5:itemsl1:a1:be4:name4:test3:rawli1ei2ee8:unsignedi255ee",
+			expected: codecStruct{Name: "test", Count: -3, Unsigned: 255, Items: []string{"a", "b"}, Extra: map[string]int{"a": 1}, Raw: bencode.RawMessage("li1ei2ee")},
+		},
+		{
+			name:     "unknown keys are skipped",
+			raw:      "d1:ad1:bl1:cee4:name4:teste",
+			expected: codecStruct{Name: "test"},
+			lossy:    true,
+		},
+		{
+			name:        "unsorted keys",
+			raw:         "d4:name4:test5:counti1ee",
+			errContains: "unsorted dictionary key",
+		},
+		{
+			name:        "duplicated keys",
+			raw:         "d4:name4:test4:name4:teste",
+			errContains: "duplicated dictionary key",
+		},
+		{
+			name:        "leading zero",
+			raw:         "d5:counti01ee",
+			errContains: "leading zero",
+		},
+		{
+			name:        "negative zero",
+			raw:         "d5:counti-0ee",
+			errContains: "negative zero",
+		},
+		{
+			name:        "string length with leading zero",
+			raw:         "d4:name04:teste",
+			errContains: "leading zero",
+		},
+		{
+			name:        "integer overflow",
+			raw:         "d5:counti99999999999999999999ee",
+			errContains: "integer overflow",
+		},
+		{
+			name:        "field overflow",
+			raw:         "d8:unsignedi256ee",
+			errContains: "overflows uint8",
+		},
+		{
+			name:        "type mismatch",
+			raw:         "d4:namei1ee",
+			errContains: "cannot decode integer into string",
+		},
+		{
+			name:        "huge string",
+			raw:         "d4:name99999999999:teste",
+			errContains: "max size exceeded",
+		},
+		{
+			name:        "truncated",
+			raw:         "d4:name4:te",
+			errContains: "unexpected EOF",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var decoded codecStruct
+			err := bencode.DecodeBytes([]byte(tc.raw), &decoded)
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, decoded)
+
+				// Canonical data is encoded back into the same bytes
+				if !tc.lossy {
+					encoded, err := bencode.EncodeBytes(decoded)
+					require.NoError(t, err)
+					require.Equal(t, tc.raw, string(encoded))
+				}
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestDecoderLimits tests the max depth and max size limits
+func TestDecoderLimits(t *testing.T) {
+	var v interface{}
+
+	nested := strings.Repeat("l", 100) + strings.Repeat("e", 100)
+	err := bencode.DecodeBytes([]byte(nested), &v)
+	require.ErrorIs(t, err, bencode.ErrMaxDepth)
+
+	decoder := bencode.NewDecoder(strings.NewReader("l4:test4:teste"))
+	decoder.SetMaxSize(8)
+	err = decoder.Decode(&v)
+	require.ErrorIs(t, err, bencode.ErrMaxSize)
+
+	decoder = bencode.NewDecoder(strings.NewReader("d1:bi1e1:ai2ee"))
+	decoder.AllowUnsortedKeys()
+	require.NoError(t, decoder.Decode(&v))
+	require.Equal(t, map[string]interface{}{"a": int64(2), "b": int64(1)}, v)
+}
+
+// TestDecodeLenient tests the decoding of unsorted keys and the data after the value
+func TestDecodeLenient(t *testing.T) {
+	var v interface{}
+
+	n, err := bencode.DecodeLenient([]byte("d1:bi1e1:ai2eerest"), &v)
+	require.NoError(t, err)
+	require.Equal(t, 14, n)
+	require.Equal(t, map[string]interface{}{"a": int64(2), "b": int64(1)}, v)
+
+	_, err = bencode.DecodeLenient([]byte("d1:ai1e1:ai2ee"), &v)
+	require.ErrorContains(t, err, "duplicate")
+
+	// Strings longer than the data are not allocated
+	_, err = bencode.DecodeLenient([]byte("9999999:test"), &v)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// TestDecoderStream tests that the decoder only reads the bytes of each value
+func TestDecoderStream(t *testing.T) {
+	reader := bytes.NewReader([]byte("i1e4:testrest"))
+	decoder := bencode.NewDecoder(reader)
+
+	var n int
+	require.NoError(t, decoder.Decode(&n))
+	require.Equal(t, 1, n)
+
+	var s string
+	require.NoError(t, decoder.Decode(&s))
+	require.Equal(t, "test", s)
+	require.Equal(t, 4, reader.Len())
+}
+
+// FuzzDecode tests that any decoded data is canonical and encodes into the same bytes
+func FuzzDecode(f *testing.F) {
+	f.Add([]byte("d8:announce16:http://test.org/4:infod4:name4:testee"))
+	f.Add([]byte("li-1ei0e3:abcde"))
+	f.Add([]byte("d1:ad1:bl1:ceee1:bi1ee"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var v interface{}
+		err := bencode.DecodeBytes(data, &v)
+		if err != nil {
+			return
+		}
+
+		encoded, err := bencode.EncodeBytes(v)
+		require.NoError(t, err)
+		require.Equal(t, data, encoded)
+	})
+}
+
+// FuzzUnmarshal tests that untrusted torrents and tracker responses don't panic
+func FuzzUnmarshal(f *testing.F) {
+	f.Add([]byte("d8:announce16:http://test.org/4:infod6:lengthi10e4:name4:test12:piece lengthi16e6:pieces0:ee"))
+	f.Add([]byte("d8:intervali900e5:peers6:94:6:pe"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		torrent, err := bencode.Unmarshal(bytes.NewReader(data))
+		if err == nil {
+			torrent.ToTorrentFile() //nolint:errcheck
+		}
+		bencoderesponse.Unmarshal(bytes.NewReader(data)) //nolint:errcheck
+	})
+}
@@ -0,0 +1,272 @@
+package bencode
+
+import (
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// minPieceLength and maxPieceLength are the bounds for the piece length
+	minPieceLength = 16 * 1024
+	maxPieceLength = 16 * 1024 * 1024
+	// targetPieces is the number of pieces the automatic piece length aims for
+	targetPieces = 1500
+)
+
+// CreateOptions are the options used to create a new torrent
+// A piece length of 0 picks one based on the total size,
+// otherwise it must be a power of two between 16KiB and 16MiB
+type CreateOptions struct {
+	Tiers        [][]string
+	PieceLength  int
+	Private      bool
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	WebSeeds     []string
+}
+
+// createFile is a file found while walking the path
+type createFile struct {
+	path       string
+	components []string
+	length     int
+}
+
+// piece is a piece read from the files waiting to be hashed
+type piece struct {
+	index int
+	buf   []byte
+}
+
+// Create builds a new torrent from a file or a directory
+// Directories create multi-file torrents with the files sorted by path
+// Returns the bencoded torrent, ready to be saved as a .torrent file
+func Create(path string, opts CreateOptions) ([]byte, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := walkFiles(path, info)
+	if err != nil {
+		return nil, err
+	}
+
+	totalLength := 0
+	for _, file := range files {
+		totalLength += file.length
+	}
+
+	pieceLength := opts.PieceLength
+	if pieceLength == 0 {
+		pieceLength = autoPieceLength(totalLength)
+	}
+	if pieceLength < minPieceLength || pieceLength > maxPieceLength || pieceLength&(pieceLength-1) != 0 {
+		return nil, fmt.Errorf("piece length must be a power of two between %d and %d: %d",
+			minPieceLength, maxPieceLength, pieceLength)
+	}
+
+	pieces, err := hashPieces(files, pieceLength)
+	if err != nil {
+		return nil, err
+	}
+
+	bi := bencodeInfo{
+		Pieces:       pieces,
+		PiecesLength: pieceLength,
+		Name:         filepath.Base(filepath.Clean(path)),
+	}
+	if opts.Private {
+		bi.Private = 1
+	}
+	if info.IsDir() {
+		for _, file := range files {
+			bi.Files = append(bi.Files, bencodeFile{
+				Length: file.length,
+				Path:   file.components,
+			})
+		}
+	} else {
+		bi.Length = totalLength
+	}
+
+	rawInfo, err := EncodeBytes(bi)
+	if err != nil {
+		return nil, err
+	}
+
+	bt := bencodeTorrent{
+		Comment:   opts.Comment,
+		CreatedBy: opts.CreatedBy,
+		URLList:   opts.WebSeeds,
+		RawInfo:   rawInfo,
+		Info:      bi,
+	}
+	if !opts.CreationDate.IsZero() {
+		bt.CreationDate = opts.CreationDate.Unix()
+	}
+
+	// The first tracker is the announce, the announce-list is only needed with more trackers
+	trackers := 0
+	for _, tier := range opts.Tiers {
+		for _, tracker := range tier {
+			if bt.Announce == "" {
+				bt.Announce = tracker
+			}
+			trackers++
+		}
+	}
+	if trackers > 1 {
+		bt.AnnounceList = opts.Tiers
+	}
+
+	return EncodeBytes(bt)
+}
+
+// walkFiles lists all the regular files from the path
+func walkFiles(path string, info fs.FileInfo) ([]createFile, error) {
+	if !info.IsDir() {
+		return []createFile{{
+			path:       path,
+			components: []string{info.Name()},
+			length:     int(info.Size()),
+		}}, nil
+	}
+
+	files := []createFile{}
+	// WalkDir walks the files in lexical order
+	err := filepath.WalkDir(path, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fileInfo, err := d.Info()
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(path, filePath)
+		if err != nil {
+			return err
+		}
+
+		files = append(files, createFile{
+			path:       filePath,
+			components: strings.Split(filepath.ToSlash(relative), "/"),
+			length:     int(fileInfo.Size()),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no files found on the path")
+	}
+
+	return files, nil
+}
+
+// autoPieceLength picks a power of two piece length for the total length
+func autoPieceLength(totalLength int) int {
+	pieceLength := minPieceLength
+	for pieceLength < maxPieceLength && totalLength/pieceLength > targetPieces {
+		pieceLength *= 2
+	}
+	return pieceLength
+}
+
+// hashPieces reads the files as a continuous stream and hashes each piece
+// The pieces are hashed in parallel, one worker per CPU
+func hashPieces(files []createFile, pieceLength int) (string, error) {
+	nWorkers := runtime.NumCPU()
+	pieces := make(chan piece, nWorkers)
+	hashes := map[int][20]byte{}
+	var mu sync.Mutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < nWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range pieces {
+				hash := sha1.Sum(p.buf)
+				mu.Lock()
+				hashes[p.index] = hash
+				mu.Unlock()
+			}
+		}()
+	}
+
+	nPieces, err := readPieces(files, pieceLength, pieces)
+	close(pieces)
+	wg.Wait()
+	if err != nil {
+		return "", err
+	}
+
+	// Join the hashes in the piece order
+	var joined strings.Builder
+	for index := 0; index < nPieces; index++ {
+		hash := hashes[index]
+		joined.Write(hash[:])
+	}
+	return joined.String(), nil
+}
+
+// readPieces reads the files into pieces and sends them to the channel
+// Returns the number of pieces read
+func readPieces(files []createFile, pieceLength int, pieces chan<- piece) (int, error) {
+	index := 0
+	buf := make([]byte, 0, pieceLength)
+	for _, file := range files {
+		f, err := os.Open(file.path)
+		if err != nil {
+			return index, err
+		}
+
+		// Fill the pieces with the file data
+		remaining := file.length
+		for remaining > 0 {
+			n := pieceLength - len(buf)
+			if n > remaining {
+				n = remaining
+			}
+			chunk := buf[len(buf) : len(buf)+n]
+			_, err := io.ReadFull(f, chunk)
+			if err != nil {
+				f.Close()
+				return index, err
+			}
+			buf = buf[:len(buf)+n]
+			remaining -= n
+
+			if len(buf) == pieceLength {
+				pieces <- piece{index: index, buf: buf}
+				index++
+				buf = make([]byte, 0, pieceLength)
+			}
+		}
+		f.Close()
+	}
+
+	// The last piece may be shorter
+	if len(buf) > 0 {
+		pieces <- piece{index: index, buf: buf}
+		index++
+	}
+
+	return index, nil
+}
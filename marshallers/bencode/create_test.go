@@ -0,0 +1,177 @@
+package bencode_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+)
+
+// TestCreate tests that created torrents round trip with Unmarshal
+func TestCreate(t *testing.T) {
+	pieceLength := 16 * 1024
+
+	testCases := []struct {
+		name    string
+		files   map[string]string
+		single  string
+		tiers   [][]string
+		paths   [][]string
+		lengths []int
+	}{
+		{
+			name:    "single file",
+			files:   map[string]string{"data.bin": strings.Repeat("0123456789", 4000)},
+			single:  "data.bin",
+			tiers:   [][]string{{"http://test.org/announce"}},
+			paths:   [][]string{{"data.bin"}},
+			lengths: []int{40000},
+		},
+		{
+			name: "multi file",
+			files: map[string]string{
+				"root/b.txt":     strings.Repeat("b", 20000),
+				"root/a.txt":     strings.Repeat("a", 7000),
+				"root/sub/c.txt": strings.Repeat("c", 13000),
+			},
+			tiers:   [][]string{{"http://test.org/announce"}, {"udp://backup.org:80"}},
+			paths:   [][]string{{"root", "a.txt"}, {"root", "b.txt"}, {"root", "sub", "c.txt"}},
+			lengths: []int{7000, 20000, 13000},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := t.TempDir()
+			content := []byte{}
+			for name, data := range tc.files {
+				path := filepath.Join(dir, name)
+				require.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+				require.NoError(t, os.WriteFile(path, []byte(data), 0o644))
+			}
+			// The content is hashed in lexical order
+			for _, path := range tc.paths {
+				data := tc.files[filepath.Join(path...)]
+				content = append(content, data...)
+			}
+
+			source := filepath.Join(dir, "root")
+			if tc.single != "" {
+				source = filepath.Join(dir, tc.single)
+			}
+
+			created, err := bencode.Create(source, bencode.CreateOptions{
+				Tiers:        tc.tiers,
+				PieceLength:  pieceLength,
+				Private:      true,
+				Comment:      "test",
+				CreatedBy:    "go-torrent",
+				CreationDate: time.Unix(1700000000, 0),
+				WebSeeds:     []string{"http://seed.org/"},
+			})
+			require.NoError(t, err)
+
+			torrent, err := bencode.Unmarshal(bytes.NewReader(created))
+			require.NoError(t, err)
+			torrentFile, err := torrent.ToTorrentFile()
+			require.NoError(t, err)
+
+			require.Equal(t, tc.tiers[0][0], torrentFile.Announce)
+			if len(tc.tiers) > 1 {
+				require.Equal(t, tc.tiers, torrentFile.AnnounceList)
+			}
+			require.True(t, torrentFile.Private)
+			require.Equal(t, "test", torrentFile.Comment)
+			require.Equal(t, "go-torrent", torrentFile.CreatedBy)
+			require.Equal(t, int64(1700000000), torrentFile.CreationDate.Unix())
+			require.Equal(t, []string{"http://seed.org/"}, torrentFile.WebSeeds)
+
+			require.Len(t, torrentFile.Files, len(tc.paths))
+			for i, file := range torrentFile.Files {
+				require.Equal(t, tc.paths[i], file.Path)
+				require.Equal(t, tc.lengths[i], file.Length)
+			}
+
+			// Check every piece against the continuous content
+			require.Equal(t, len(content), torrentFile.Length)
+			require.Len(t, torrentFile.PieceHashes, (len(content)+pieceLength-1)/pieceLength)
+			for i, hash := range torrentFile.PieceHashes {
+				end := (i + 1) * pieceLength
+				if end > len(content) {
+					end = len(content)
+				}
+				require.Equal(t, sha1.Sum(content[i*pieceLength:end]), [20]byte(hash))
+			}
+		})
+	}
+}
+
+// TestCreateEmptyDirectory tests that directories without files fail
+func TestCreateEmptyDirectory(t *testing.T) {
+	_, err := bencode.Create(t.TempDir(), bencode.CreateOptions{})
+	require.ErrorContains(t, err, "no files found")
+}
+
+// TestCreatePieceLength tests the bounds of the piece length
+func TestCreatePieceLength(t *testing.T) {
+	testCases := []struct {
+		name        string
+		pieceLength int
+		errContains string
+	}{
+		{
+			name:        "automatic",
+			pieceLength: 0,
+		},
+		{
+			name:        "minimum",
+			pieceLength: 16 * 1024,
+		},
+		{
+			name:        "maximum",
+			pieceLength: 16 * 1024 * 1024,
+		},
+		{
+			name:        "below the minimum",
+			pieceLength: 8 * 1024,
+			errContains: "piece length must be a power of two between 16384 and 16777216: 8192",
+		},
+		{
+			name:        "above the maximum",
+			pieceLength: 32 * 1024 * 1024,
+			errContains: "piece length must be a power of two between 16384 and 16777216: 33554432",
+		},
+		{
+			name:        "not a power of two",
+			pieceLength: 48 * 1024,
+			errContains: "piece length must be a power of two",
+		},
+		{
+			name:        "negative",
+			pieceLength: -16 * 1024,
+			errContains: "piece length must be a power of two",
+		},
+	}
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0o644))
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := bencode.Create(path, bencode.CreateOptions{PieceLength: tc.pieceLength})
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
@@ -0,0 +1,574 @@
+package bencode
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// Default limits for the decoder
+// Bencoded data is often received from untrusted trackers and peers
+const (
+	DefaultMaxDepth = 64
+	DefaultMaxSize  = 64 * 1024 * 1024
+)
+
+// Errors for the decoder limits
+var (
+	ErrMaxDepth = errors.New("bencode: max depth exceeded")
+	ErrMaxSize  = errors.New("bencode: max size exceeded")
+)
+
+// RawMessage is a raw bencoded value
+// It can be used to delay the decoding or to keep the exact bytes of a value
+type RawMessage []byte
+
+// Unmarshaler is implemented by types that can decode themselves
+// The data is a single raw bencoded value
+type Unmarshaler interface {
+	UnmarshalBencode(data []byte) error
+}
+
+// byteReader is the reader used by the decoder
+// Readers that don't read byte by byte are buffered
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// Decoder reads bencoded values from a stream
+// Only the bytes of each value are read from readers implementing io.ByteReader
+type Decoder struct {
+	r byteReader
+
+	maxDepth     int
+	maxSize      int64
+	unsortedKeys bool
+
+	// offset is the number of bytes read
+	offset int64
+	// peeked is a byte read ahead on lists, -1 if empty
+	peeked int
+	// raw stores the read bytes while a raw value is captured
+	raw *bytes.Buffer
+}
+
+// NewDecoder returns a new decoder reading from r
+func NewDecoder(r io.Reader) *Decoder {
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	return &Decoder{
+		r:        br,
+		maxDepth: DefaultMaxDepth,
+		maxSize:  DefaultMaxSize,
+		peeked:   -1,
+	}
+}
+
+// SetMaxDepth sets the max nesting of lists and dictionaries
+func (d *Decoder) SetMaxDepth(depth int) {
+	d.maxDepth = depth
+}
+
+// SetMaxSize sets the max number of bytes read by the decoder
+func (d *Decoder) SetMaxSize(size int64) {
+	d.maxSize = size
+}
+
+// AllowUnsortedKeys accepts dictionaries with keys out of order
+// Duplicated keys are still rejected
+func (d *Decoder) AllowUnsortedKeys() {
+	d.unsortedKeys = true
+}
+
+// Decode reads the next bencoded value into v
+// v must be a non nil pointer
+func (d *Decoder) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("bencode: decode target must be a non nil pointer, got %T", v)
+	}
+	return d.value(rv.Elem(), 0)
+}
+
+// DecodeBytes decodes a single bencoded value from the data into v
+// Trailing data after the value is an error
+func DecodeBytes(data []byte, v interface{}) error {
+	r := bytes.NewReader(data)
+	err := NewDecoder(r).Decode(v)
+	if err != nil {
+		return err
+	}
+	if r.Len() > 0 {
+		return fmt.Errorf("bencode: %d bytes of trailing data", r.Len())
+	}
+	return nil
+}
+
+// DecodeLenient decodes the first bencoded value from the data into v
+// Peers and trackers often don't sort the dictionary keys, so the order is not checked
+// The number of bytes read is returned, so the callers can handle the data after the value
+func DecodeLenient(data []byte, v interface{}) (int, error) {
+	r := bytes.NewReader(data)
+	decoder := NewDecoder(r)
+	decoder.AllowUnsortedKeys()
+	err := decoder.Decode(v)
+	if err != nil {
+		return 0, err
+	}
+	return len(data) - r.Len(), nil
+}
+
+// readByte reads a single byte checking the size limit
+func (d *Decoder) readByte() (byte, error) {
+	c, err := d.peekByte()
+	if err != nil {
+		return 0, err
+	}
+	d.peeked = -1
+	d.offset++
+	if d.raw != nil {
+		d.raw.WriteByte(c)
+	}
+	return c, nil
+}
+
+// peekByte reads the next byte without consuming it
+func (d *Decoder) peekByte() (byte, error) {
+	if d.peeked >= 0 {
+		return byte(d.peeked), nil
+	}
+	if d.offset >= d.maxSize {
+		return 0, ErrMaxSize
+	}
+	c, err := d.r.ReadByte()
+	if err != nil {
+		if err == io.EOF && d.offset > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	d.peeked = int(c)
+	return c, nil
+}
+
+// readBytes reads n bytes checking the size limit before allocating
+// Readers knowing their remaining bytes are also checked, so short data can't claim long strings
+func (d *Decoder) readBytes(n int64) ([]byte, error) {
+	if n > d.maxSize-d.offset {
+		return nil, ErrMaxSize
+	}
+	if r, ok := d.r.(interface{ Len() int }); ok && n > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	_, err := io.ReadFull(d.r, buf)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	d.offset += n
+	if d.raw != nil {
+		d.raw.Write(buf)
+	}
+	return buf, nil
+}
+
+// readInt reads an integer after the 'i' prefix
+// The integer must be canonical, without leading zeros or negative zero
+func (d *Decoder) readInt() (int64, error) {
+	start := d.offset
+	negative := false
+	digits := 0
+	var value uint64
+
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return 0, err
+		}
+
+		switch {
+		case c == 'e':
+			if digits == 0 {
+				return 0, fmt.Errorf("bencode: empty integer at offset %d", start)
+			}
+			if negative {
+				if value == 0 {
+					return 0, fmt.Errorf("bencode: negative zero at offset %d", start)
+				}
+				return -int64(value), nil
+			}
+			return int64(value), nil
+		case c == '-' && digits == 0 && !negative:
+			negative = true
+		case c >= '0' && c <= '9':
+			if digits > 0 && value == 0 {
+				return 0, fmt.Errorf("bencode: integer with leading zero at offset %d", start)
+			}
+			digit := uint64(c - '0')
+
+			// Guard against overflows, negatives can go to -(1 << 63)
+			limit := uint64(1<<63 - 1)
+			if negative {
+				limit = 1 << 63
+			}
+			if value > (limit-digit)/10 {
+				return 0, fmt.Errorf("bencode: integer overflow at offset %d", start)
+			}
+			value = value*10 + digit
+			digits++
+		default:
+			return 0, fmt.Errorf("bencode: invalid integer character %q at offset %d", c, d.offset-1)
+		}
+	}
+}
+
+// readString reads a string after its first length digit
+// The length is checked against the size limit before allocating
+func (d *Decoder) readString(first byte) ([]byte, error) {
+	start := d.offset - 1
+	length := int64(first - '0')
+	for {
+		c, err := d.readByte()
+		if err != nil {
+			return nil, err
+		}
+		if c == ':' {
+			break
+		}
+		if c < '0' || c > '9' {
+			return nil, fmt.Errorf("bencode: invalid string length character %q at offset %d", c, d.offset-1)
+		}
+		if length == 0 {
+			return nil, fmt.Errorf("bencode: string length with leading zero at offset %d", start)
+		}
+
+		// The length can't be larger than the size limit, this also guards overflows
+		length = length*10 + int64(c-'0')
+		if length > d.maxSize {
+			return nil, ErrMaxSize
+		}
+	}
+
+	return d.readBytes(length)
+}
+
+// readKey reads a dictionary key, returning nil at the end of the dictionary
+func (d *Decoder) readKey() ([]byte, bool, error) {
+	c, err := d.readByte()
+	if err != nil {
+		return nil, false, err
+	}
+	if c == 'e' {
+		return nil, true, nil
+	}
+	if c < '0' || c > '9' {
+		return nil, false, fmt.Errorf("bencode: dictionary key must be a string at offset %d", d.offset-1)
+	}
+	key, err := d.readString(c)
+	return key, false, err
+}
+
+// checkKeyOrder checks that the key comes after the previous key
+func (d *Decoder) checkKeyOrder(previous, key []byte, seen map[string]bool) error {
+	if seen[string(key)] {
+		return fmt.Errorf("bencode: duplicated dictionary key %q at offset %d", key, d.offset)
+	}
+	seen[string(key)] = true
+	if !d.unsortedKeys && previous != nil && bytes.Compare(previous, key) >= 0 {
+		return fmt.Errorf("bencode: unsorted dictionary key %q at offset %d", key, d.offset)
+	}
+	return nil
+}
+
+// value decodes the next value into v
+func (d *Decoder) value(v reflect.Value, depth int) error {
+	// Raw values and unmarshalers take the exact bytes
+	if v.Type() == rawMessageType {
+		raw, err := d.captureRaw(depth)
+		if err != nil {
+			return err
+		}
+		v.SetBytes(raw)
+		return nil
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		raw, err := d.captureRaw(depth)
+		if err != nil {
+			return err
+		}
+		return v.Addr().Interface().(Unmarshaler).UnmarshalBencode(raw)
+	}
+
+	// Allocate the pointers
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return d.value(v.Elem(), depth)
+	}
+
+	c, err := d.readByte()
+	if err != nil {
+		return err
+	}
+
+	// Generic values
+	if v.Kind() == reflect.Interface && v.NumMethod() == 0 {
+		generic, err := d.generic(c, depth)
+		if err != nil {
+			return err
+		}
+		if generic != nil {
+			v.Set(reflect.ValueOf(generic))
+		}
+		return nil
+	}
+
+	switch {
+	case c == 'i':
+		n, err := d.readInt()
+		if err != nil {
+			return err
+		}
+		return d.setInt(v, n)
+	case c >= '0' && c <= '9':
+		s, err := d.readString(c)
+		if err != nil {
+			return err
+		}
+		return d.setString(v, s)
+	case c == 'l':
+		return d.list(v, depth+1)
+	case c == 'd':
+		return d.dict(v, depth+1)
+	default:
+		return fmt.Errorf("bencode: invalid value prefix %q at offset %d", c, d.offset-1)
+	}
+}
+
+// captureRaw reads the next value keeping its exact bytes
+func (d *Decoder) captureRaw(depth int) ([]byte, error) {
+	d.raw = &bytes.Buffer{}
+	defer func() { d.raw = nil }()
+
+	c, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	err = d.skip(c, depth)
+	if err != nil {
+		return nil, err
+	}
+	return d.raw.Bytes(), nil
+}
+
+// skip reads and discards the value starting with c
+func (d *Decoder) skip(c byte, depth int) error {
+	_, err := d.generic(c, depth)
+	return err
+}
+
+// generic decodes the value starting with c into a generic value
+// Integers are int64, strings are string, lists are []interface{} and
+// dictionaries are map[string]interface{}
+func (d *Decoder) generic(c byte, depth int) (interface{}, error) {
+	switch {
+	case c == 'i':
+		return d.readInt()
+	case c >= '0' && c <= '9':
+		s, err := d.readString(c)
+		return string(s), err
+	case c == 'l':
+		if depth+1 > d.maxDepth {
+			return nil, ErrMaxDepth
+		}
+		list := []interface{}{}
+		for {
+			c, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			if c == 'e' {
+				return list, nil
+			}
+			item, err := d.generic(c, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+	case c == 'd':
+		if depth+1 > d.maxDepth {
+			return nil, ErrMaxDepth
+		}
+		dict := map[string]interface{}{}
+		seen := map[string]bool{}
+		var previous []byte
+		for {
+			key, end, err := d.readKey()
+			if err != nil {
+				return nil, err
+			}
+			if end {
+				return dict, nil
+			}
+			if err := d.checkKeyOrder(previous, key, seen); err != nil {
+				return nil, err
+			}
+			previous = key
+
+			c, err := d.readByte()
+			if err != nil {
+				return nil, err
+			}
+			item, err := d.generic(c, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			dict[string(key)] = item
+		}
+	default:
+		return nil, fmt.Errorf("bencode: invalid value prefix %q at offset %d", c, d.offset-1)
+	}
+}
+
+// setInt sets a decoded integer into v
+func (d *Decoder) setInt(v reflect.Value, n int64) error {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(n) {
+			return fmt.Errorf("bencode: integer %d overflows %s", n, v.Type())
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n < 0 || v.OverflowUint(uint64(n)) {
+			return fmt.Errorf("bencode: integer %d overflows %s", n, v.Type())
+		}
+		v.SetUint(uint64(n))
+	case reflect.Bool:
+		v.SetBool(n != 0)
+	default:
+		return fmt.Errorf("bencode: cannot decode integer into %s", v.Type())
+	}
+	return nil
+}
+
+// setString sets a decoded string into v
+func (d *Decoder) setString(v reflect.Value, s []byte) error {
+	switch {
+	case v.Kind() == reflect.String:
+		v.SetString(string(s))
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		v.SetBytes(s)
+	case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+		if len(s) != v.Len() {
+			return fmt.Errorf("bencode: string of length %d doesn't fit %s", len(s), v.Type())
+		}
+		reflect.Copy(v, reflect.ValueOf(s))
+	default:
+		return fmt.Errorf("bencode: cannot decode string into %s", v.Type())
+	}
+	return nil
+}
+
+// list decodes a list into a slice
+func (d *Decoder) list(v reflect.Value, depth int) error {
+	if depth > d.maxDepth {
+		return ErrMaxDepth
+	}
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("bencode: cannot decode list into %s", v.Type())
+	}
+
+	v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	for {
+		c, err := d.peekByte()
+		if err != nil {
+			return err
+		}
+		if c == 'e' {
+			_, err = d.readByte()
+			return err
+		}
+
+		item := reflect.New(v.Type().Elem()).Elem()
+		err = d.value(item, depth)
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.Append(v, item))
+	}
+}
+
+// dict decodes a dictionary into a struct or a map with string keys
+func (d *Decoder) dict(v reflect.Value, depth int) error {
+	if depth > d.maxDepth {
+		return ErrMaxDepth
+	}
+
+	var fields *structFields
+	switch {
+	case v.Kind() == reflect.Struct:
+		fields = cachedFields(v.Type())
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+	default:
+		return fmt.Errorf("bencode: cannot decode dictionary into %s", v.Type())
+	}
+
+	seen := map[string]bool{}
+	var previous []byte
+	for {
+		key, end, err := d.readKey()
+		if err != nil {
+			return err
+		}
+		if end {
+			return nil
+		}
+		if err := d.checkKeyOrder(previous, key, seen); err != nil {
+			return err
+		}
+		previous = key
+
+		// Maps take every key
+		if v.Kind() == reflect.Map {
+			item := reflect.New(v.Type().Elem()).Elem()
+			err = d.value(item, depth)
+			if err != nil {
+				return err
+			}
+			v.SetMapIndex(reflect.ValueOf(string(key)).Convert(v.Type().Key()), item)
+			continue
+		}
+
+		// Unknown struct keys are skipped
+		f, ok := fields.byKey[string(key)]
+		if !ok {
+			c, err := d.readByte()
+			if err != nil {
+				return err
+			}
+			err = d.skip(c, depth)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = d.value(v.FieldByIndex(f.index), depth)
+		if err != nil {
+			return fmt.Errorf("%w (key %q)", err, key)
+		}
+	}
+}
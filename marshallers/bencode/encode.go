@@ -0,0 +1,234 @@
+package bencode
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Marshaler is implemented by types that can encode themselves
+// The returned data must be a single bencoded value
+type Marshaler interface {
+	MarshalBencode() ([]byte, error)
+}
+
+var (
+	rawMessageType  = reflect.TypeOf(RawMessage{})
+	marshalerType   = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+)
+
+// Encoder writes bencoded values into a stream
+// Dictionary keys are always sorted, so the output is canonical
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder returns a new encoder writing into w
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode writes the bencoding of v
+func (e *Encoder) Encode(v interface{}) error {
+	var buf bytes.Buffer
+	err := encodeValue(&buf, reflect.ValueOf(v))
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(buf.Bytes())
+	return err
+}
+
+// EncodeBytes returns the bencoding of v
+func EncodeBytes(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := encodeValue(&buf, reflect.ValueOf(v))
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeValue writes the value into the buffer
+func encodeValue(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		return fmt.Errorf("bencode: cannot encode nil value")
+	}
+
+	// Raw values are written as they are
+	if v.Type() == rawMessageType {
+		if v.Len() == 0 {
+			return fmt.Errorf("bencode: cannot encode empty raw message")
+		}
+		buf.Write(v.Bytes())
+		return nil
+	}
+	if v.Type().Implements(marshalerType) {
+		if v.Kind() == reflect.Pointer && v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+		data, err := v.Interface().(Marshaler).MarshalBencode()
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return fmt.Errorf("bencode: cannot encode nil %s", v.Type())
+		}
+		return encodeValue(buf, v.Elem())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatInt(v.Int(), 10))
+		buf.WriteByte('e')
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		buf.WriteByte('i')
+		buf.WriteString(strconv.FormatUint(v.Uint(), 10))
+		buf.WriteByte('e')
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteString("i1e")
+		} else {
+			buf.WriteString("i0e")
+		}
+	case reflect.String:
+		encodeString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		// Byte slices and arrays are strings
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			data := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(data), v)
+			encodeString(buf, string(data))
+			return nil
+		}
+		buf.WriteByte('l')
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeValue(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("bencode: map keys must be strings, got %s", v.Type().Key())
+		}
+		keys := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			keys = append(keys, key.String())
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('d')
+		for _, key := range keys {
+			encodeString(buf, key)
+			item := v.MapIndex(reflect.ValueOf(key).Convert(v.Type().Key()))
+			if err := encodeValue(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('e')
+	case reflect.Struct:
+		buf.WriteByte('d')
+		for _, f := range cachedFields(v.Type()).sorted {
+			item := v.FieldByIndex(f.index)
+			if f.omitEmpty && isEmptyValue(item) {
+				continue
+			}
+			encodeString(buf, f.key)
+			if err := encodeValue(buf, item); err != nil {
+				return fmt.Errorf("%w (key %q)", err, f.key)
+			}
+		}
+		buf.WriteByte('e')
+	default:
+		return fmt.Errorf("bencode: cannot encode %s", v.Type())
+	}
+	return nil
+}
+
+// encodeString writes a length prefixed string
+func encodeString(buf *bytes.Buffer, s string) {
+	buf.WriteString(strconv.Itoa(len(s)))
+	buf.WriteByte(':')
+	buf.WriteString(s)
+}
+
+// isEmptyValue returns if the value is skipped with omitempty
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Pointer, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// field is a struct field mapped to a dictionary key
+// The key is taken from the bencode tag or is the field name
+type field struct {
+	key       string
+	index     []int
+	omitEmpty bool
+}
+
+// structFields are the fields of a struct, sorted and by key
+type structFields struct {
+	sorted []field
+	byKey  map[string]field
+}
+
+// fieldCache caches the fields of each struct type
+var fieldCache sync.Map
+
+// cachedFields returns the fields of a struct
+func cachedFields(t reflect.Type) *structFields {
+	if cached, ok := fieldCache.Load(t); ok {
+		return cached.(*structFields)
+	}
+
+	fields := &structFields{byKey: map[string]field{}}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		tag := f.Tag.Get("bencode")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = f.Name
+		}
+
+		structField := field{
+			key:       name,
+			index:     f.Index,
+			omitEmpty: options == "omitempty",
+		}
+		fields.sorted = append(fields.sorted, structField)
+		fields.byKey[name] = structField
+	}
+	sort.Slice(fields.sorted, func(i, j int) bool { return fields.sorted[i].key < fields.sorted[j].key })
+
+	fieldCache.Store(t, fields)
+	return fields
+}
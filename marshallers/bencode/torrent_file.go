@@ -4,6 +4,7 @@ import (
 	"errors"
 	"net/url"
 	"strconv"
+	"time"
 
 	"github.com/jhelison/go-torrent/marshallers/handshake"
 )
@@ -11,18 +12,58 @@ import (
 // TorrentFile stores the basic information to handle processing
 // and download of torrents
 type TorrentFile struct {
-	Announce    string
-	InfoHash    [20]byte
-	PieceHashes []handshake.Hash
-	PieceLength int
-	Length      int
-	Name        string
+	Announce     string
+	AnnounceList [][]string
+	Comment      string
+	CreatedBy    string
+	CreationDate time.Time
+	WebSeeds     []string
+	Nodes        []string
+	Private      bool
+	InfoHash     [20]byte
+	Info         []byte
+	PieceHashes  []handshake.Hash
+	PieceLength  int
+	Length       int
+	Name         string
+	Files        []File
+}
+
+// File is a single file inside a torrent
+// The path is relative to the output path and the offset is
+// where the file starts on the torrent byte space
+type File struct {
+	Path   []string
+	Length int
+	Offset int
+}
+
+// AnnounceStats are the transfer stats and the event sent on a announce
+// The event, the IPv6 and the tracker ID are only sent if not empty
+type AnnounceStats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      string
+	IPv6       string
+	TrackerID  string
+}
+
+// Tiers returns the tiers of trackers for the torrent
+// If the announce-list is present the announce is ignored, as defined on BEP 12
+func (t *TorrentFile) Tiers() [][]string {
+	if len(t.AnnounceList) > 0 {
+		return t.AnnounceList
+	}
+	if t.Announce != "" {
+		return [][]string{{t.Announce}}
+	}
+	return nil
 }
 
 // BuildTrackerURL takes the TorrentFile and build the tracker URL with params
 // Example can be found on https://wiki.theory.org/BitTorrent_Tracker_Protocol
-// For this implementation we are always passing as no parts have been downloaded yet
-func (t *TorrentFile) BuildTrackerURL(peerID [20]byte, port uint16) (string, error) {
+func (t *TorrentFile) BuildTrackerURL(peerID [20]byte, port uint16, stats AnnounceStats) (string, error) {
 	if t.Announce == "" {
 		return "", errors.New("Announce not found in selected torrent")
 	}
@@ -36,10 +77,19 @@ func (t *TorrentFile) BuildTrackerURL(peerID [20]byte, port uint16) (string, err
 		"info_hash":  []string{string(t.InfoHash[:])},
 		"peer_id":    []string{string(peerID[:])},
 		"port":       []string{strconv.Itoa(int(port))},
-		"uploaded":   []string{"0"},
-		"downloaded": []string{"0"},
+		"uploaded":   []string{strconv.FormatInt(stats.Uploaded, 10)},
+		"downloaded": []string{strconv.FormatInt(stats.Downloaded, 10)},
 		"compact":    []string{"1"},
-		"left":       []string{strconv.Itoa(t.Length)},
+		"left":       []string{strconv.FormatInt(stats.Left, 10)},
+	}
+	if stats.Event != "" {
+		params.Set("event", stats.Event)
+	}
+	if stats.IPv6 != "" {
+		params.Set("ipv6", stats.IPv6)
+	}
+	if stats.TrackerID != "" {
+		params.Set("trackerid", stats.TrackerID)
 	}
 	base.RawQuery = params.Encode()
 
@@ -14,6 +14,8 @@ func TestBuildTrackerURL(t *testing.T) {
 	testCases := []struct {
 		name        string
 		torrentFile bencode.TorrentFile
+		stats       bencode.AnnounceStats
+		expected    string
 		errContains string
 	}{
 		{
@@ -26,6 +28,30 @@ func TestBuildTrackerURL(t *testing.T) {
 				Length:      0,
 				Name:        "test",
 			},
+			expected: "http://torrent.test.org:6969/announce?compact=1&downloaded=0&info_hash=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&left=0&peer_id=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&port=1&uploaded=0",
+		},
+		{
+			name: "stats and event",
+			torrentFile: bencode.TorrentFile{
+				Announce: "http://torrent.test.org:6969/announce",
+			},
+			stats: bencode.AnnounceStats{
+				Uploaded:   1,
+				Downloaded: 2,
+				Left:       3,
+				Event:      "started",
+			},
+			expected: "http://torrent.test.org:6969/announce?compact=1&downloaded=2&event=started&info_hash=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&left=3&peer_id=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&port=1&uploaded=1",
+		},
+		{
+			name: "ipv6",
+			torrentFile: bencode.TorrentFile{
+				Announce: "http://torrent.test.org:6969/announce",
+			},
+			stats: bencode.AnnounceStats{
+				IPv6: "2001:db8::1",
+			},
+			expected: "http://torrent.test.org:6969/announce?compact=1&downloaded=0&info_hash=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&ipv6=2001%3Adb8%3A%3A1&left=0&peer_id=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&port=1&uploaded=0",
 		},
 		{
 			name: "err",
@@ -38,15 +64,47 @@ func TestBuildTrackerURL(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			url, err := tc.torrentFile.BuildTrackerURL([20]byte{}, 1)
+			url, err := tc.torrentFile.BuildTrackerURL([20]byte{}, 1, tc.stats)
 
 			if tc.errContains == "" {
 				require.NoError(t, err)
 
-				require.Equal(t, "http://torrent.test.org:6969/announce?compact=1&downloaded=0&info_hash=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&left=0&peer_id=%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00%00&port=1&uploaded=0", url)
+				require.Equal(t, tc.expected, url)
 			} else {
 				require.ErrorContains(t, err, tc.errContains)
 			}
 		})
 	}
 }
+
+// TestTiers tests the tiers from the announce and announce-list
+func TestTiers(t *testing.T) {
+	testCases := []struct {
+		name        string
+		torrentFile bencode.TorrentFile
+		tiers       [][]string
+	}{
+		{
+			name:        "announce",
+			torrentFile: bencode.TorrentFile{Announce: "http://a"},
+			tiers:       [][]string{{"http://a"}},
+		},
+		{
+			name: "announce list",
+			torrentFile: bencode.TorrentFile{
+				Announce:     "http://a",
+				AnnounceList: [][]string{{"http://b", "udp://c"}, {"http://d"}},
+			},
+			tiers: [][]string{{"http://b", "udp://c"}, {"http://d"}},
+		},
+		{
+			name: "empty",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.tiers, tc.torrentFile.Tiers())
+		})
+	}
+}
@@ -1,26 +1,173 @@
 package bencoderesponse
 
 import (
+	"fmt"
 	"io"
+	"net"
 
-	"github.com/jackpal/bencode-go"
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/peer"
 )
 
 // bencodeResponse is the response from a announce
-// It stores the interval and peers
+// It stores the intervals and peers
 // More information about the announce response can be found on:
 // https://wiki.theory.org/BitTorrent_Tracker_Protocol
 type bencodeResponse struct {
-	Interval int    `bencode:"interval"`
-	Peers    string `bencode:"peers"`
+	FailureReason  string `bencode:"failure reason"`
+	WarningMessage string `bencode:"warning message"`
+	Interval       int    `bencode:"interval"`
+	MinInterval    int    `bencode:"min interval"`
+	TrackerID      string `bencode:"tracker id"`
+	Complete       int    `bencode:"complete"`
+	Incomplete     int    `bencode:"incomplete"`
+	Peers          peers  `bencode:"peers"`
+	// Peers6 are the compact IPv6 peers, as defined on BEP 7
+	Peers6 peers6 `bencode:"peers6,omitempty"`
 }
 
+// failureResponse only reads the failure reason of a response
+type failureResponse struct {
+	FailureReason string `bencode:"failure reason"`
+}
+
+// FailureError is returned when the tracker answers with a failure reason
+type FailureError struct {
+	Reason string
+}
+
+// Error returns the failure reason from the tracker
+func (e *FailureError) Error() string {
+	return fmt.Sprintf("tracker failure: %s", e.Reason)
+}
+
+// maxResponseSize is the max size of a tracker response
+const maxResponseSize = 4 * 1024 * 1024
+
 // Unmarshal reads a io reader and convert the bytes into a bencodeResponse
+// Some trackers don't sort the dictionary keys, so the order is not checked
+// A response with a failure reason returns a FailureError
 func Unmarshal(r io.Reader) (*bencodeResponse, error) {
 	announceRes := bencodeResponse{}
-	err := bencode.Unmarshal(r, &announceRes)
+	err := unmarshalResponse(r, &announceRes)
 	if err != nil {
 		return nil, err
 	}
+	if announceRes.FailureReason != "" {
+		return nil, &FailureError{Reason: announceRes.FailureReason}
+	}
 	return &announceRes, nil
 }
+
+// scrapeResponse is the response from a scrape
+// The files are keyed by the raw info hash
+type scrapeResponse struct {
+	FailureReason string                `bencode:"failure reason"`
+	Files         map[string]scrapeFile `bencode:"files"`
+}
+
+// scrapeFile is the swarm information of a single torrent
+type scrapeFile struct {
+	Complete   int `bencode:"complete"`
+	Downloaded int `bencode:"downloaded"`
+	Incomplete int `bencode:"incomplete"`
+}
+
+// UnmarshalScrape reads a scrape response
+// A response with a failure reason returns a FailureError
+func UnmarshalScrape(r io.Reader) (*scrapeResponse, error) {
+	scrapeRes := scrapeResponse{}
+	err := unmarshalResponse(r, &scrapeRes)
+	if err != nil {
+		return nil, err
+	}
+	if scrapeRes.FailureReason != "" {
+		return nil, &FailureError{Reason: scrapeRes.FailureReason}
+	}
+	return &scrapeRes, nil
+}
+
+// unmarshalResponse reads a tracker response into v
+// The failure reason is still returned if the other keys fail to decode
+func unmarshalResponse(r io.Reader, v interface{}) error {
+	data, err := io.ReadAll(io.LimitReader(r, maxResponseSize+1))
+	if err != nil {
+		return err
+	}
+	if len(data) > maxResponseSize {
+		return fmt.Errorf("tracker response bigger than %d bytes", maxResponseSize)
+	}
+
+	_, err = bencode.DecodeLenient(data, v)
+	if err != nil {
+		// The failures may not have the other keys on the expected types
+		failure := failureResponse{}
+		if _, err := bencode.DecodeLenient(data, &failure); err == nil && failure.FailureReason != "" {
+			return &FailureError{Reason: failure.FailureReason}
+		}
+		return err
+	}
+	return nil
+}
+
+// peers are the IPv4 peers of a response
+// They can be compact or a list of dictionaries
+type peers []peer.Peer
+
+// dictPeer is a peer from the non compact list
+type dictPeer struct {
+	IP     string `bencode:"ip"`
+	Port   int    `bencode:"port"`
+	PeerID string `bencode:"peer id"`
+}
+
+// UnmarshalBencode decodes the compact string or the list of dictionaries
+// Peers with a invalid IP or port are skipped from the list
+func (p *peers) UnmarshalBencode(data []byte) error {
+	if len(data) == 0 || data[0] != 'l' {
+		var compact string
+		_, err := bencode.DecodeLenient(data, &compact)
+		if err != nil {
+			return err
+		}
+		parsed, err := peer.Unmarshal([]byte(compact))
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+
+	list := []dictPeer{}
+	_, err := bencode.DecodeLenient(data, &list)
+	if err != nil {
+		return err
+	}
+	*p = peers{}
+	for _, d := range list {
+		ip := net.ParseIP(d.IP)
+		if ip == nil || d.Port <= 0 || d.Port > 65535 {
+			continue
+		}
+		*p = append(*p, peer.Peer{IP: ip, Port: uint16(d.Port)})
+	}
+	return nil
+}
+
+// peers6 are the compact IPv6 peers of a response
+type peers6 []peer.Peer
+
+// UnmarshalBencode decodes the compact IPv6 peers
+func (p *peers6) UnmarshalBencode(data []byte) error {
+	var compact string
+	_, err := bencode.DecodeLenient(data, &compact)
+	if err != nil {
+		return err
+	}
+	parsed, err := peer.Unmarshal6([]byte(compact))
+	if err != nil {
+		return err
+	}
+	*p = parsed
+	return nil
+}
@@ -1,6 +1,7 @@
 package bencoderesponse_test
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -14,11 +15,44 @@ func TestUnmarshal(t *testing.T) {
 	testCases := []struct {
 		name        string
 		announceRes string
+		peers       []string
+		failure     string
 		errContains string
 	}{
 		{
 			name:        "pass",
 			announceRes: `d8:intervali900e5:peers6:94:6:peers60:e`,
+			peers:       []string{"57.52.58.54:14960"},
+		},
+		{
+			name:        "peers6",
+			announceRes: "d8:intervali900e5:peers0:6:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1e",
+			peers:       []string{"[2001:db8::1]:6881"},
+		},
+		{
+			name: "dictionary peers",
+			announceRes: "d8:intervali900e5:peersl" +
+				"d2:ip8:10.0.0.17:peer id20:abcdefghij01234567894:porti6881ee" +
+				"d4:porti6882e2:ip11:2001:db8::1e" +
+				"d2:ip7:invalid4:porti1ee" +
+				"d2:ip8:10.0.0.24:porti0ee" +
+				"ee",
+			peers: []string{"10.0.0.1:6881", "[2001:db8::1]:6882"},
+		},
+		{
+			name:        "failure",
+			announceRes: `d14:failure reason22:torrent not registerede`,
+			failure:     "torrent not registered",
+		},
+		{
+			name:        "failure with invalid keys",
+			announceRes: `d14:failure reason6:banned8:intervall1:xee`,
+			failure:     "banned",
+		},
+		{
+			name:        "invalid compact peers",
+			announceRes: `d8:intervali900e5:peers5:abcdee`,
+			errContains: "invalid piers length",
 		},
 		{
 			name:        "error",
@@ -35,14 +69,89 @@ func TestUnmarshal(t *testing.T) {
 			// Call the unmarshal
 			response, err := bencoderesponse.Unmarshal(reader)
 
-			if tc.errContains == "" {
+			switch {
+			case tc.failure != "":
+				var failure *bencoderesponse.FailureError
+				require.True(t, errors.As(err, &failure))
+				require.Equal(t, tc.failure, failure.Reason)
+			case tc.errContains != "":
+				require.ErrorContains(t, err, tc.errContains)
+			default:
 				require.NoError(t, err)
 
 				// Check the response values
-				require.EqualValues(t, response.Interval, 900)
-				require.Equal(t, response.Peers, "94:6:p")
-			} else {
+				require.EqualValues(t, 900, response.Interval)
+				peers := []string{}
+				for _, p := range append(response.Peers, response.Peers6...) {
+					peers = append(peers, p.String())
+				}
+				require.Equal(t, tc.peers, peers)
+			}
+		})
+	}
+}
+
+// TestUnmarshalFields tests the optional fields of the response
+func TestUnmarshalFields(t *testing.T) {
+	// The keys are out of order, as sent by some trackers
+	reader := strings.NewReader("d8:completei5e10:incompletei3e8:intervali1800e12:min intervali60e" +
+		"10:tracker id3:abc15:warning message4:slow5:peers0:e")
+
+	response, err := bencoderesponse.Unmarshal(reader)
+	require.NoError(t, err)
+
+	require.Equal(t, 5, response.Complete)
+	require.Equal(t, 3, response.Incomplete)
+	require.Equal(t, 1800, response.Interval)
+	require.Equal(t, 60, response.MinInterval)
+	require.Equal(t, "abc", response.TrackerID)
+	require.Equal(t, "slow", response.WarningMessage)
+	require.Empty(t, response.Peers)
+}
+
+// TestUnmarshalScrape tests the scrape response
+func TestUnmarshalScrape(t *testing.T) {
+	testCases := []struct {
+		name        string
+		scrapeRes   string
+		failure     string
+		errContains string
+	}{
+		{
+			name:      "pass",
+			scrapeRes: "d5:filesd20:aaaaaaaaaaaaaaaaaaaad8:completei5e10:downloadedi50e10:incompletei10eeee",
+		},
+		{
+			name:      "failure",
+			scrapeRes: "d14:failure reason14:scrape blockede",
+			failure:   "scrape blocked",
+		},
+		{
+			name:        "error",
+			scrapeRes:   "d5:filesd20:aaaaaaaaaaaaaaaaaaaai1eee",
+			errContains: "cannot decode",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := bencoderesponse.UnmarshalScrape(strings.NewReader(tc.scrapeRes))
+
+			switch {
+			case tc.failure != "":
+				var failure *bencoderesponse.FailureError
+				require.True(t, errors.As(err, &failure))
+				require.Equal(t, tc.failure, failure.Reason)
+			case tc.errContains != "":
 				require.ErrorContains(t, err, tc.errContains)
+			default:
+				require.NoError(t, err)
+
+				file, ok := response.Files["aaaaaaaaaaaaaaaaaaaa"]
+				require.True(t, ok)
+				require.Equal(t, 5, file.Complete)
+				require.Equal(t, 50, file.Downloaded)
+				require.Equal(t, 10, file.Incomplete)
 			}
 		})
 	}
@@ -0,0 +1,88 @@
+package extension
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/message"
+)
+
+// ExtendedID is the id of a extended message
+// The id is negotiated per peer on the extended handshake
+type ExtendedID uint8
+
+// HandshakeID is the reserved id for the extended handshake
+const HandshakeID ExtendedID = 0
+
+// Names of the supported extensions
+const (
+	ExtMetadata = "ut_metadata"
+	ExtPex      = "ut_pex"
+)
+
+// Handshake is the extended handshake
+// The M dictionary maps the extension names to the ids used by the sender
+// An id of 0 means the extension has been disabled
+// More information can be found on https://www.bittorrent.org/beps/bep_0010.html
+type Handshake struct {
+	M            map[string]int `bencode:"m"`
+	Version      string         `bencode:"v,omitempty"`
+	Reqq         int            `bencode:"reqq,omitempty"`
+	YourIP       string         `bencode:"yourip,omitempty"`
+	MetadataSize int            `bencode:"metadata_size,omitempty"`
+	Port         int            `bencode:"p,omitempty"`
+}
+
+// NewMessage builds a new extended message
+// The payload is prefixed by the extended message id
+func NewMessage(id ExtendedID, payload []byte) message.Message {
+	buf := make([]byte, len(payload)+1)
+	buf[0] = byte(id)
+	copy(buf[1:], payload)
+	return message.NewMessage(message.MsgExtended, buf)
+}
+
+// ParseMessage parses a extended message returning the extended id and payload
+func ParseMessage(msg message.Message) (ExtendedID, []byte, error) {
+	if msg.ID != message.MsgExtended {
+		return 0, nil, fmt.Errorf("Expected EXTENDED (ID %d), got ID %d", message.MsgExtended, msg.ID)
+	}
+	if len(msg.Payload) < 1 {
+		return 0, nil, fmt.Errorf("Expected payload length of at least 1, got length %d", len(msg.Payload))
+	}
+	return ExtendedID(msg.Payload[0]), msg.Payload[1:], nil
+}
+
+// Marshal serializes the handshake into bencode
+func (h Handshake) Marshal() ([]byte, error) {
+	return bencode.EncodeBytes(h)
+}
+
+// UnmarshalHandshake reads a extended handshake payload
+func UnmarshalHandshake(payload []byte) (*Handshake, error) {
+	h := Handshake{}
+	_, err := bencode.DecodeLenient(payload, &h)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// CompactIP returns the compact form of a IP for the yourip key
+// IPv4 addresses have 4 bytes and IPv6 addresses have 16
+func CompactIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return string(ip4)
+	}
+	return string(ip.To16())
+}
+
+// ExternalIP returns our IP as seen by the peer
+// Returns nil if the peer didn't send a valid yourip
+func (h Handshake) ExternalIP() net.IP {
+	if len(h.YourIP) != net.IPv4len && len(h.YourIP) != net.IPv6len {
+		return nil
+	}
+	return net.IP(h.YourIP)
+}
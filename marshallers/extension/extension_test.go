@@ -0,0 +1,67 @@
+package extension_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+)
+
+// TestHandshake tests the extended handshake marshal and unmarshal
+func TestHandshake(t *testing.T) {
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    extension.Handshake
+		externalIP  net.IP
+		errContains string
+	}{
+		{
+			name: "all keys",
+			raw:  "d1:md11:ut_metadatai1e6:ut_pexi2ee13:metadata_sizei100e1:pi6881e4:reqqi256e1:v10:go-torrent6:yourip4:\x7f\x00\x00\x01e",
+			expected: extension.Handshake{
+				M:            map[string]int{extension.ExtMetadata: 1, extension.ExtPex: 2},
+				Version:      "go-torrent",
+				Reqq:         256,
+				YourIP:       extension.CompactIP(net.IPv4(127, 0, 0, 1)),
+				MetadataSize: 100,
+				Port:         6881,
+			},
+			externalIP: net.IP{127, 0, 0, 1},
+		},
+		{
+			name: "IPv6 yourip",
+			raw:  "d1:mde6:yourip16:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01e",
+			expected: extension.Handshake{
+				M:      map[string]int{},
+				YourIP: extension.CompactIP(net.ParseIP("2001:db8::1")),
+			},
+			externalIP: net.ParseIP("2001:db8::1"),
+		},
+		{
+			name:        "invalid",
+			raw:         "d1:mi1ee",
+			errContains: "cannot decode integer",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h, err := extension.UnmarshalHandshake([]byte(tc.raw))
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, *h)
+				require.True(t, tc.externalIP.Equal(h.ExternalIP()))
+
+				raw, err := tc.expected.Marshal()
+				require.NoError(t, err)
+				require.Equal(t, tc.raw, string(raw))
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package extension
+
+import (
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+)
+
+// MetadataPieceSize is the size of each metadata piece
+// Only the last piece may be smaller
+const MetadataPieceSize = 16384
+
+// MetadataMsgType is the type for a ut_metadata message
+type MetadataMsgType int
+
+// Types of ut_metadata messages
+// More information can be found on https://www.bittorrent.org/beps/bep_0009.html
+const (
+	MetadataRequest MetadataMsgType = 0
+	MetadataData    MetadataMsgType = 1
+	MetadataReject  MetadataMsgType = 2
+)
+
+// MetadataMessage is a single ut_metadata message
+// Data messages have the piece appended after the bencoded dictionary
+type MetadataMessage struct {
+	MsgType   MetadataMsgType `bencode:"msg_type"`
+	Piece     int             `bencode:"piece"`
+	TotalSize int             `bencode:"total_size,omitempty"`
+	Data      []byte          `bencode:"-"`
+}
+
+// NewMetadataRequest builds a new request for a metadata piece
+func NewMetadataRequest(piece int) MetadataMessage {
+	return MetadataMessage{
+		MsgType: MetadataRequest,
+		Piece:   piece,
+	}
+}
+
+// Marshal serializes the message with the data at the end
+func (m MetadataMessage) Marshal() ([]byte, error) {
+	buf, err := bencode.EncodeBytes(m)
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, m.Data...), nil
+}
+
+// UnmarshalMetadata reads a ut_metadata payload
+// Anything after the bencoded dictionary is the piece data
+func UnmarshalMetadata(payload []byte) (*MetadataMessage, error) {
+	m := MetadataMessage{}
+	n, err := bencode.DecodeLenient(payload, &m)
+	if err != nil {
+		return nil, err
+	}
+	m.Data = payload[n:]
+
+	return &m, nil
+}
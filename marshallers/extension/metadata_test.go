@@ -0,0 +1,72 @@
+package extension_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+)
+
+// TestMetadataMessage tests the ut_metadata marshal and unmarshal
+func TestMetadataMessage(t *testing.T) {
+	testCases := []struct {
+		name        string
+		msg         extension.MetadataMessage
+		raw         string
+		errContains string
+	}{
+		{
+			name: "request",
+			msg:  extension.NewMetadataRequest(1),
+			raw:  "d8:msg_typei0e5:piecei1ee",
+		},
+		{
+			name: "data",
+			msg: extension.MetadataMessage{
+				MsgType:   extension.MetadataData,
+				Piece:     0,
+				TotalSize: 4,
+				Data:      []byte("abcd"),
+			},
+			raw: "d8:msg_typei1e5:piecei0e10:total_sizei4eeabcd",
+		},
+		{
+			name:        "invalid",
+			raw:         "d8:msg_type",
+			errContains: "EOF",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := extension.UnmarshalMetadata([]byte(tc.raw))
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+
+				require.Equal(t, tc.msg.MsgType, msg.MsgType)
+				require.Equal(t, tc.msg.Piece, msg.Piece)
+				require.Equal(t, tc.msg.TotalSize, msg.TotalSize)
+				require.Equal(t, string(tc.msg.Data), string(msg.Data))
+
+				raw, err := tc.msg.Marshal()
+				require.NoError(t, err)
+				require.Equal(t, tc.raw, string(raw))
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestUnmarshalMetadataUnsorted tests the messages with keys out of order
+func TestUnmarshalMetadataUnsorted(t *testing.T) {
+	msg, err := extension.UnmarshalMetadata([]byte("d5:piecei2e10:total_sizei4e8:msg_typei1eeabcd"))
+	require.NoError(t, err)
+
+	require.Equal(t, extension.MetadataData, msg.MsgType)
+	require.Equal(t, 2, msg.Piece)
+	require.Equal(t, 4, msg.TotalSize)
+	require.Equal(t, "abcd", string(msg.Data))
+}
@@ -0,0 +1,97 @@
+package extension
+
+import (
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// Flags for each added peer
+// More information can be found on https://www.bittorrent.org/beps/bep_0011.html
+const (
+	PexEncryption byte = 0x01
+	PexSeed       byte = 0x02
+	PexUTP        byte = 0x04
+	PexHolepunch  byte = 0x08
+	PexOutgoing   byte = 0x10
+)
+
+// MaxPexPeers is the max number of added or dropped peers on a message
+const MaxPexPeers = 50
+
+// PexMessage is a single ut_pex message
+// The peers are compact, with a flag byte for each added peer
+type PexMessage struct {
+	Added       string `bencode:"added"`
+	AddedFlags  string `bencode:"added.f,omitempty"`
+	Added6      string `bencode:"added6,omitempty"`
+	Added6Flags string `bencode:"added6.f,omitempty"`
+	Dropped     string `bencode:"dropped"`
+	Dropped6    string `bencode:"dropped6,omitempty"`
+}
+
+// NewPexMessage builds a new message with the added and dropped peers
+// The IPv4 and IPv6 peers are split on their own keys
+func NewPexMessage(added []peer.Peer, flags []byte, dropped []peer.Peer) PexMessage {
+	var added4, added6 []peer.Peer
+	var flags4, flags6 []byte
+	for i, p := range added {
+		flag := byte(0)
+		if i < len(flags) {
+			flag = flags[i]
+		}
+		if p.IP.To4() != nil {
+			added4 = append(added4, p)
+			flags4 = append(flags4, flag)
+		} else {
+			added6 = append(added6, p)
+			flags6 = append(flags6, flag)
+		}
+	}
+
+	return PexMessage{
+		Added:       string(peer.Marshal(added4)),
+		AddedFlags:  string(flags4),
+		Added6:      string(peer.Marshal6(added6)),
+		Added6Flags: string(flags6),
+		Dropped:     string(peer.Marshal(dropped)),
+		Dropped6:    string(peer.Marshal6(dropped)),
+	}
+}
+
+// Marshal serializes the message into bencode
+func (m PexMessage) Marshal() ([]byte, error) {
+	return bencode.EncodeBytes(m)
+}
+
+// UnmarshalPex reads a ut_pex payload
+func UnmarshalPex(payload []byte) (*PexMessage, error) {
+	m := PexMessage{}
+	_, err := bencode.DecodeLenient(payload, &m)
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// AddedPeers returns the IPv4 and IPv6 added peers
+func (m PexMessage) AddedPeers() ([]peer.Peer, error) {
+	return parsePexPeers(m.Added, m.Added6)
+}
+
+// DroppedPeers returns the IPv4 and IPv6 dropped peers
+func (m PexMessage) DroppedPeers() ([]peer.Peer, error) {
+	return parsePexPeers(m.Dropped, m.Dropped6)
+}
+
+// parsePexPeers parses the compact IPv4 and IPv6 peers
+func parsePexPeers(peers4, peers6 string) ([]peer.Peer, error) {
+	parsed, err := peer.Unmarshal([]byte(peers4))
+	if err != nil {
+		return nil, err
+	}
+	parsed6, err := peer.Unmarshal6([]byte(peers6))
+	if err != nil {
+		return nil, err
+	}
+	return append(parsed, parsed6...), nil
+}
@@ -0,0 +1,81 @@
+package extension_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/extension"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestPexMessage tests the ut_pex marshal and unmarshal
+func TestPexMessage(t *testing.T) {
+	peer4 := peer.Peer{IP: net.IP{10, 0, 0, 1}, Port: 6881}
+	peer6 := peer.Peer{IP: net.ParseIP("2001:db8::1"), Port: 6882}
+	dropped := peer.Peer{IP: net.IP{10, 0, 0, 2}, Port: 80}
+
+	testCases := []struct {
+		name        string
+		raw         string
+		added       []peer.Peer
+		dropped     []peer.Peer
+		errContains string
+	}{
+		{
+			name:    "IPv4 and IPv6 peers",
+			raw:     string(mustMarshal(t, extension.NewPexMessage([]peer.Peer{peer4, peer6}, []byte{extension.PexOutgoing, 0}, []peer.Peer{dropped}))),
+			added:   []peer.Peer{peer4, peer6},
+			dropped: []peer.Peer{dropped},
+		},
+		{
+			name:    "unsorted keys",
+			raw:     "d7:dropped0:5:added6:\x0a\x00\x00\x01\x1a\xe1e",
+			added:   []peer.Peer{peer4},
+			dropped: []peer.Peer{},
+		},
+		{
+			name:        "invalid peers length",
+			raw:         "d5:added5:abcde7:dropped0:e",
+			errContains: "invalid piers length",
+		},
+		{
+			name:        "invalid IPv6 peers length",
+			raw:         "d5:added0:6:added66:abcdef7:dropped0:e",
+			errContains: "invalid IPv6 peers length",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := extension.UnmarshalPex([]byte(tc.raw))
+			require.NoError(t, err)
+
+			added, err := msg.AddedPeers()
+			if tc.errContains != "" {
+				require.ErrorContains(t, err, tc.errContains)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, len(tc.added), len(added))
+			for i := range added {
+				require.Equal(t, tc.added[i].String(), added[i].String())
+			}
+
+			droppedPeers, err := msg.DroppedPeers()
+			require.NoError(t, err)
+			require.Equal(t, len(tc.dropped), len(droppedPeers))
+			for i := range droppedPeers {
+				require.Equal(t, tc.dropped[i].String(), droppedPeers[i].String())
+			}
+		})
+	}
+}
+
+// mustMarshal serializes a message failing the test on errors
+func mustMarshal(t *testing.T, msg extension.PexMessage) []byte {
+	raw, err := msg.Marshal()
+	require.NoError(t, err)
+	return raw
+}
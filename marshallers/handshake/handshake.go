@@ -10,14 +10,30 @@ type PeerID [20]byte
 
 // Each handshake if formed by:
 // - Pstr: A string identifier
+// - Reserved: Eight bytes used to flag supported extensions
 // - InfoHash Sha1 for the meta info
 // - PeerID: Unique identifier
 type Handshake struct {
 	Pstr     string
+	Reserved [8]byte
 	InfoHash Hash
 	PeerID   PeerID
 }
 
+// ReservedBit is a bit on the reserved bytes, counted from the right
+// Each bit flags the support for a extension
+type ReservedBit uint8
+
+// Known reserved bits
+const (
+	// DHTBit flags the DHT port message from BEP 5
+	DHTBit ReservedBit = 0
+	// FastBit flags the fast extension from BEP 6
+	FastBit ReservedBit = 2
+	// ExtensionBit flags the extension protocol from BEP 10
+	ExtensionBit ReservedBit = 20
+)
+
 // NewHandshake creates a new handshake
 func NewHandshake(peerID PeerID, infoHash Hash) *Handshake {
 	return &Handshake{
@@ -31,7 +47,7 @@ func NewHandshake(peerID PeerID, infoHash Hash) *Handshake {
 // It's formed by:
 // - The len of the protocol ID
 // - The protocol ID
-// - Eight reserved bytes (all turned to zero, used for extensions)
+// - Eight reserved bytes (used for extensions)
 // - The infoHash
 // - The peer ID
 func (h Handshake) Marshal() []byte {
@@ -42,8 +58,8 @@ func (h Handshake) Marshal() []byte {
 	curr := 1
 	// The protocol ID
 	curr += copy(buf[curr:], h.Pstr)
-	// The empty 8 bytes
-	curr += copy(buf[curr:], make([]byte, 8))
+	// The reserved 8 bytes
+	curr += copy(buf[curr:], h.Reserved[:])
 	// The infoHash
 	curr += copy(buf[curr:], h.InfoHash[:])
 	// The peer ID
@@ -76,17 +92,40 @@ func Unmarshal(r io.Reader) (*Handshake, error) {
 		return nil, err
 	}
 
-	// Get the info hash and peerID
+	// Get the reserved bytes, info hash and peerID
+	var reserved [8]byte
 	var infoHash, peerID [20]byte
+	copy(reserved[:], handshakeBuf[pstrlen:pstrlen+8])
 	copy(infoHash[:], handshakeBuf[pstrlen+8:pstrlen+8+20])
 	copy(peerID[:], handshakeBuf[pstrlen+8+20:])
 
 	// Create the handshake object
 	h := Handshake{
 		Pstr:     string(handshakeBuf[0:pstrlen]),
+		Reserved: reserved,
 		InfoHash: infoHash,
 		PeerID:   peerID,
 	}
 
 	return &h, nil
 }
+
+// SetReserved flags a reserved bit
+func (h *Handshake) SetReserved(bit ReservedBit) {
+	h.Reserved[7-bit/8] |= 1 << (bit % 8)
+}
+
+// HasReserved returns if a reserved bit is flagged
+func (h Handshake) HasReserved(bit ReservedBit) bool {
+	return h.Reserved[7-bit/8]&(1<<(bit%8)) != 0
+}
+
+// EnableExtensions flags the extension protocol as supported
+func (h *Handshake) EnableExtensions() {
+	h.SetReserved(ExtensionBit)
+}
+
+// SupportsExtensions returns if the extension protocol is supported
+func (h Handshake) SupportsExtensions() bool {
+	return h.HasReserved(ExtensionBit)
+}
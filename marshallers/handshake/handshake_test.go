@@ -0,0 +1,52 @@
+package handshake_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+)
+
+// TestReserved tests the reserved bits and their round trip
+func TestReserved(t *testing.T) {
+	testCases := []struct {
+		name     string
+		bits     []handshake.ReservedBit
+		reserved [8]byte
+	}{
+		{
+			name: "no bits",
+		},
+		{
+			name:     "extension protocol",
+			bits:     []handshake.ReservedBit{handshake.ExtensionBit},
+			reserved: [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0},
+		},
+		{
+			name:     "all known bits",
+			bits:     []handshake.ReservedBit{handshake.DHTBit, handshake.FastBit, handshake.ExtensionBit},
+			reserved: [8]byte{0, 0, 0, 0, 0, 0x10, 0, 0x05},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			h := handshake.NewHandshake(handshake.PeerID{1}, handshake.Hash{2})
+			for _, bit := range tc.bits {
+				h.SetReserved(bit)
+			}
+			require.Equal(t, tc.reserved, h.Reserved)
+
+			// The reserved bytes from the peer are kept
+			parsed, err := handshake.Unmarshal(bytes.NewReader(h.Marshal()))
+			require.NoError(t, err)
+			require.Equal(t, *h, *parsed)
+			for _, bit := range tc.bits {
+				require.True(t, parsed.HasReserved(bit))
+			}
+			require.Equal(t, parsed.HasReserved(handshake.ExtensionBit), parsed.SupportsExtensions())
+		})
+	}
+}
@@ -0,0 +1,257 @@
+package krpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// NodeID is the 160 bits id of a DHT node
+type NodeID [20]byte
+
+// Types of messages
+// More information can be found on https://www.bittorrent.org/beps/bep_0005.html
+const (
+	TypeQuery    = "q"
+	TypeResponse = "r"
+	TypeError    = "e"
+)
+
+// Names of the queries
+const (
+	QueryPing         = "ping"
+	QueryFindNode     = "find_node"
+	QueryGetPeers     = "get_peers"
+	QueryAnnouncePeer = "announce_peer"
+)
+
+// Error codes
+const (
+	ErrorGeneric       = 201
+	ErrorServer        = 202
+	ErrorProtocol      = 203
+	ErrorMethodUnknown = 204
+)
+
+// Values of the want argument
+// More information can be found on https://www.bittorrent.org/beps/bep_0032.html
+const (
+	WantNodes4 = "n4"
+	WantNodes6 = "n6"
+)
+
+const (
+	// nodeInfoLength is the length of a compact IPv4 node info
+	nodeInfoLength = 26
+	// nodeInfo6Length is the length of a compact IPv6 node info
+	nodeInfo6Length = 38
+)
+
+// maxMessageSize is the max size of a KRPC message
+// Messages are single UDP packets
+const maxMessageSize = 65536
+
+// Message is a single KRPC message
+// Queries have the method and the arguments, responses have the return values
+type Message struct {
+	TransactionID string  `bencode:"t"`
+	Type          string  `bencode:"y"`
+	Query         string  `bencode:"q,omitempty"`
+	Args          *Args   `bencode:"a,omitempty"`
+	Return        *Return `bencode:"r,omitempty"`
+	Error         *Error  `bencode:"e,omitempty"`
+	Version       string  `bencode:"v,omitempty"`
+}
+
+// Args are the arguments of a query
+type Args struct {
+	ID          string   `bencode:"id"`
+	Target      string   `bencode:"target,omitempty"`
+	InfoHash    string   `bencode:"info_hash,omitempty"`
+	Port        int      `bencode:"port,omitempty"`
+	Token       string   `bencode:"token,omitempty"`
+	ImpliedPort int      `bencode:"implied_port,omitempty"`
+	Want        []string `bencode:"want,omitempty"`
+}
+
+// Return are the values of a response
+// Values are the compact peers and nodes the compact node infos
+type Return struct {
+	ID     string   `bencode:"id"`
+	Nodes  string   `bencode:"nodes,omitempty"`
+	Nodes6 string   `bencode:"nodes6,omitempty"`
+	Values []string `bencode:"values,omitempty"`
+	Token  string   `bencode:"token,omitempty"`
+}
+
+// Error is the error from a error message
+// It's encoded as a list with the code and the message
+type Error struct {
+	Code    int
+	Message string
+}
+
+// NodeInfo is the id and address of a node
+type NodeInfo struct {
+	ID   NodeID
+	Addr *net.UDPAddr
+}
+
+// Marshal serializes the message into bencode
+func (m Message) Marshal() ([]byte, error) {
+	return bencode.EncodeBytes(m)
+}
+
+// Unmarshal reads a KRPC message
+func Unmarshal(data []byte) (*Message, error) {
+	if len(data) > maxMessageSize {
+		return nil, fmt.Errorf("message bigger than %d bytes", maxMessageSize)
+	}
+
+	m := Message{}
+	_, err := bencode.DecodeLenient(data, &m)
+	if err != nil {
+		return nil, err
+	}
+	if m.TransactionID == "" {
+		return nil, errors.New("message without a transaction id")
+	}
+	return &m, nil
+}
+
+// MarshalBencode encodes the error as a list
+func (e Error) MarshalBencode() ([]byte, error) {
+	return bencode.EncodeBytes([]interface{}{e.Code, e.Message})
+}
+
+// UnmarshalBencode decodes the error from a list
+func (e *Error) UnmarshalBencode(data []byte) error {
+	list := []interface{}{}
+	err := bencode.DecodeBytes(data, &list)
+	if err != nil {
+		return err
+	}
+	if len(list) != 2 {
+		return fmt.Errorf("invalid error length %d", len(list))
+	}
+
+	code, ok := list[0].(int64)
+	if !ok {
+		return errors.New("invalid error code")
+	}
+	message, ok := list[1].(string)
+	if !ok {
+		return errors.New("invalid error message")
+	}
+	e.Code = int(code)
+	e.Message = message
+	return nil
+}
+
+// Error returns the error message
+func (e Error) Error() string {
+	return fmt.Sprintf("krpc error %d: %s", e.Code, e.Message)
+}
+
+// ParseID converts a id string from a message into a node id
+func ParseID(id string) (NodeID, error) {
+	if len(id) != len(NodeID{}) {
+		return NodeID{}, fmt.Errorf("invalid node id length %d", len(id))
+	}
+
+	var nodeID NodeID
+	copy(nodeID[:], id)
+	return nodeID, nil
+}
+
+// MarshalNodes serializes the IPv4 nodes into the compact node info
+// Nodes without a IPv4 address are skipped
+func MarshalNodes(nodes []NodeInfo) string {
+	buf := make([]byte, 0, len(nodes)*nodeInfoLength)
+	for _, node := range nodes {
+		ip := node.Addr.IP.To4()
+		if ip == nil {
+			continue
+		}
+		buf = append(buf, node.ID[:]...)
+		buf = append(buf, ip...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(node.Addr.Port))
+	}
+	return string(buf)
+}
+
+// UnmarshalNodes parses the compact node info
+func UnmarshalNodes(data string) ([]NodeInfo, error) {
+	return unmarshalNodes(data, nodeInfoLength)
+}
+
+// MarshalNodes6 serializes the IPv6 nodes into the compact node info
+// Nodes with a IPv4 address are skipped
+func MarshalNodes6(nodes []NodeInfo) string {
+	buf := make([]byte, 0, len(nodes)*nodeInfo6Length)
+	for _, node := range nodes {
+		if node.Addr.IP.To4() != nil || len(node.Addr.IP) != net.IPv6len {
+			continue
+		}
+		buf = append(buf, node.ID[:]...)
+		buf = append(buf, node.Addr.IP...)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(node.Addr.Port))
+	}
+	return string(buf)
+}
+
+// UnmarshalNodes6 parses the compact IPv6 node info
+func UnmarshalNodes6(data string) ([]NodeInfo, error) {
+	return unmarshalNodes(data, nodeInfo6Length)
+}
+
+// unmarshalNodes parses the compact node info with entries of a length
+// The address is after the 20 bytes id, followed by the port
+func unmarshalNodes(data string, length int) ([]NodeInfo, error) {
+	if len(data)%length != 0 {
+		return nil, fmt.Errorf("invalid nodes length %d", len(data))
+	}
+
+	nodes := make([]NodeInfo, len(data)/length)
+	for i := range nodes {
+		entry := []byte(data[i*length : (i+1)*length])
+		copy(nodes[i].ID[:], entry[:20])
+		nodes[i].Addr = &net.UDPAddr{
+			IP:   net.IP(entry[20 : length-2]),
+			Port: int(binary.BigEndian.Uint16(entry[length-2:])),
+		}
+	}
+	return nodes, nil
+}
+
+// MarshalPeer serializes a peer into the compact peer info
+// IPv4 peers have 6 bytes and IPv6 peers 18 bytes
+func MarshalPeer(p peer.Peer) string {
+	if p.IP.To4() != nil {
+		return string(peer.Marshal([]peer.Peer{p}))
+	}
+	return string(peer.Marshal6([]peer.Peer{p}))
+}
+
+// UnmarshalValues parses the compact IPv4 and IPv6 peers from a get_peers response
+func UnmarshalValues(values []string) ([]peer.Peer, error) {
+	peers := []peer.Peer{}
+	for _, value := range values {
+		var parsed []peer.Peer
+		var err error
+		if len(value) == net.IPv6len+2 {
+			parsed, err = peer.Unmarshal6([]byte(value))
+		} else {
+			parsed, err = peer.Unmarshal([]byte(value))
+		}
+		if err != nil {
+			return nil, err
+		}
+		peers = append(peers, parsed...)
+	}
+	return peers, nil
+}
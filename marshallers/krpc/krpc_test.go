@@ -0,0 +1,140 @@
+package krpc_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/krpc"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestUnmarshal tests the parsing of queries, responses and errors
+func TestUnmarshal(t *testing.T) {
+	id := strings.Repeat("a", 20)
+
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    krpc.Message
+		errContains string
+	}{
+		{
+			name: "query",
+			raw:  "d1:ad2:id20:" + id + "e1:q4:ping1:t2:aa1:y1:qe",
+			expected: krpc.Message{
+				TransactionID: "aa",
+				Type:          krpc.TypeQuery,
+				Query:         krpc.QueryPing,
+				Args:          &krpc.Args{ID: id},
+			},
+		},
+		{
+			name: "response",
+			raw:  "d1:rd2:id20:" + id + "5:token2:xx6:valuesl6:abcdefee1:t2:aa1:y1:re",
+			expected: krpc.Message{
+				TransactionID: "aa",
+				Type:          krpc.TypeResponse,
+				Return:        &krpc.Return{ID: id, Token: "xx", Values: []string{"abcdef"}},
+			},
+		},
+		{
+			name: "error",
+			raw:  "d1:eli201e13:Generic Errore1:t2:aa1:y1:ee",
+			expected: krpc.Message{
+				TransactionID: "aa",
+				Type:          krpc.TypeError,
+				Error:         &krpc.Error{Code: krpc.ErrorGeneric, Message: "Generic Error"},
+			},
+		},
+		{
+			name:        "missing transaction",
+			raw:         "d1:y1:qe",
+			errContains: "without a transaction id",
+		},
+		{
+			name:        "invalid error",
+			raw:         "d1:eli201ee1:t2:aa1:y1:ee",
+			errContains: "invalid error length",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := krpc.Unmarshal([]byte(tc.raw))
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, *msg)
+
+				raw, err := msg.Marshal()
+				require.NoError(t, err)
+				require.Equal(t, tc.raw, string(raw))
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestNodes tests the compact node info
+func TestNodes(t *testing.T) {
+	nodes := []krpc.NodeInfo{
+		{ID: krpc.NodeID{1}, Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}},
+		{ID: krpc.NodeID{2}, Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2).To4(), Port: 6882}},
+	}
+
+	raw := krpc.MarshalNodes(nodes)
+	require.Len(t, raw, 52)
+
+	parsed, err := krpc.UnmarshalNodes(raw)
+	require.NoError(t, err)
+	require.Equal(t, nodes, parsed)
+
+	_, err = krpc.UnmarshalNodes(raw[:30])
+	require.ErrorContains(t, err, "invalid nodes length")
+}
+
+// TestNodes6 tests the compact IPv6 node info
+// The IPv4 nodes are skipped
+func TestNodes6(t *testing.T) {
+	nodes := []krpc.NodeInfo{
+		{ID: krpc.NodeID{1}, Addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 6881}},
+		{ID: krpc.NodeID{2}, Addr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 6882}},
+	}
+
+	raw := krpc.MarshalNodes6(nodes)
+	require.Len(t, raw, 38)
+	require.Len(t, krpc.MarshalNodes(nodes), 26)
+
+	parsed, err := krpc.UnmarshalNodes6(raw)
+	require.NoError(t, err)
+	require.Equal(t, nodes[:1], parsed)
+
+	_, err = krpc.UnmarshalNodes6(raw[:30])
+	require.ErrorContains(t, err, "invalid nodes length")
+}
+
+// TestValues tests the compact peers of both families
+func TestValues(t *testing.T) {
+	peers := []peer.Peer{
+		{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881},
+		{IP: net.ParseIP("2001:db8::1"), Port: 6882},
+	}
+
+	values := []string{}
+	for _, p := range peers {
+		values = append(values, krpc.MarshalPeer(p))
+	}
+	require.Len(t, values[0], 6)
+	require.Len(t, values[1], 18)
+
+	parsed, err := krpc.UnmarshalValues(values)
+	require.NoError(t, err)
+	require.Equal(t, peers, parsed)
+
+	_, err = krpc.UnmarshalValues([]string{"abc"})
+	require.Error(t, err)
+}
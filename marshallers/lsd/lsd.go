@@ -0,0 +1,95 @@
+package lsd
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+)
+
+// searchLine is the request line of every announce
+// More information can be found on https://www.bittorrent.org/beps/bep_0014.html
+const searchLine = "BT-SEARCH * HTTP/1.1"
+
+// Announce is a single local service discovery announce
+// The peer address is the source of the packet and the announced port
+type Announce struct {
+	Host       string
+	Port       uint16
+	InfoHashes []handshake.Hash
+	Cookie     string
+}
+
+// Marshal serializes the announce into the HTTP like message
+func (a Announce) Marshal() []byte {
+	var b strings.Builder
+	b.WriteString(searchLine + "\r\n")
+	b.WriteString("Host: " + a.Host + "\r\n")
+	b.WriteString("Port: " + strconv.Itoa(int(a.Port)) + "\r\n")
+	for _, infoHash := range a.InfoHashes {
+		b.WriteString("Infohash: " + hex.EncodeToString(infoHash[:]) + "\r\n")
+	}
+	if a.Cookie != "" {
+		b.WriteString("cookie: " + a.Cookie + "\r\n")
+	}
+	b.WriteString("\r\n\r\n")
+	return []byte(b.String())
+}
+
+// Unmarshal parses a announce
+// The headers are case insensitive and unknown headers are ignored
+func Unmarshal(data []byte) (*Announce, error) {
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != searchLine {
+		return nil, errors.New("not a BT-SEARCH message")
+	}
+
+	a := Announce{}
+	hasPort := false
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			break
+		}
+
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("invalid header %q", line)
+		}
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(strings.TrimSpace(key)) {
+		case "host":
+			a.Host = value
+		case "port":
+			port, err := strconv.ParseUint(value, 10, 16)
+			if err != nil || port == 0 {
+				return nil, fmt.Errorf("invalid port %q", value)
+			}
+			a.Port = uint16(port)
+			hasPort = true
+		case "infohash":
+			raw, err := hex.DecodeString(value)
+			if err != nil || len(raw) != len(handshake.Hash{}) {
+				return nil, fmt.Errorf("invalid info hash %q", value)
+			}
+			var infoHash handshake.Hash
+			copy(infoHash[:], raw)
+			a.InfoHashes = append(a.InfoHashes, infoHash)
+		case "cookie":
+			a.Cookie = value
+		}
+	}
+
+	if !hasPort {
+		return nil, errors.New("announce without a port")
+	}
+	if len(a.InfoHashes) == 0 {
+		return nil, errors.New("announce without a info hash")
+	}
+	return &a, nil
+}
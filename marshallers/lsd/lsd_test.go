@@ -0,0 +1,82 @@
+package lsd_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/lsd"
+)
+
+// TestAnnounce tests the announce marshal and unmarshal
+func TestAnnounce(t *testing.T) {
+	infoHash := handshake.Hash{0xab, 0xcd}
+
+	testCases := []struct {
+		name        string
+		raw         string
+		expected    lsd.Announce
+		errContains string
+	}{
+		{
+			name: "announce with cookie",
+			raw: "BT-SEARCH * HTTP/1.1\r\nHost: 239.192.152.143:6771\r\nPort: 6881\r\n" +
+				"Infohash: abcd000000000000000000000000000000000000\r\ncookie: test\r\n\r\n\r\n",
+			expected: lsd.Announce{
+				Host:       "239.192.152.143:6771",
+				Port:       6881,
+				InfoHashes: []handshake.Hash{infoHash},
+				Cookie:     "test",
+			},
+		},
+		{
+			name: "multiple info hashes and lower case headers",
+			raw: "BT-SEARCH * HTTP/1.1\nhost: [ff15::efc0:988f]:6771\nport: 51413\n" +
+				"infohash: ABCD000000000000000000000000000000000000\ninfohash: 0000000000000000000000000000000000000000\n\n",
+			expected: lsd.Announce{
+				Host:       "[ff15::efc0:988f]:6771",
+				Port:       51413,
+				InfoHashes: []handshake.Hash{infoHash, {}},
+			},
+		},
+		{
+			name:        "other message",
+			raw:         "M-SEARCH * HTTP/1.1\r\n\r\n",
+			errContains: "not a BT-SEARCH message",
+		},
+		{
+			name:        "invalid port",
+			raw:         "BT-SEARCH * HTTP/1.1\r\nPort: 70000\r\n\r\n",
+			errContains: "invalid port",
+		},
+		{
+			name:        "invalid info hash",
+			raw:         "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\nInfohash: abcd\r\n\r\n",
+			errContains: "invalid info hash",
+		},
+		{
+			name:        "without info hash",
+			raw:         "BT-SEARCH * HTTP/1.1\r\nPort: 6881\r\n\r\n",
+			errContains: "without a info hash",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			announce, err := lsd.Unmarshal([]byte(tc.raw))
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, *announce)
+
+				// The marshalled announce is parsed back into the same value
+				parsed, err := lsd.Unmarshal(tc.expected.Marshal())
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, *parsed)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
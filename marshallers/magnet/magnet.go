@@ -0,0 +1,137 @@
+package magnet
+
+import (
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// Magnet is the representation of a magnet URI
+// More information can be found on https://www.bittorrent.org/beps/bep_0009.html
+type Magnet struct {
+	InfoHash    handshake.Hash
+	DisplayName string
+	Trackers    []string
+	Peers       []peer.Peer
+}
+
+// btihPrefix is the prefix for BitTorrent info hashes on the exact topic
+const btihPrefix = "urn:btih:"
+
+// Parse parses a magnet URI
+// The info hash can be encoded as hex (40 chars) or base32 (32 chars)
+func Parse(uri string) (*Magnet, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "magnet" {
+		return nil, fmt.Errorf("invalid magnet scheme %q", u.Scheme)
+	}
+
+	params, err := url.ParseQuery(u.RawQuery)
+	if err != nil {
+		return nil, err
+	}
+
+	m := Magnet{
+		DisplayName: params.Get("dn"),
+	}
+
+	// Find the BitTorrent exact topic
+	found := false
+	for _, xt := range params["xt"] {
+		if !strings.HasPrefix(xt, btihPrefix) {
+			continue
+		}
+		m.InfoHash, err = parseInfoHash(strings.TrimPrefix(xt, btihPrefix))
+		if err != nil {
+			return nil, err
+		}
+		found = true
+		break
+	}
+	if !found {
+		return nil, fmt.Errorf("magnet without a btih exact topic")
+	}
+
+	// Trackers can be numbered as tr.1, tr.2...
+	// The keys are sorted to keep the trackers order stable
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		if key == "tr" || strings.HasPrefix(key, "tr.") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		m.Trackers = append(m.Trackers, params[key]...)
+	}
+
+	// Peers are formed by host:port
+	for _, value := range params["x.pe"] {
+		p, err := parsePeer(value)
+		if err != nil {
+			return nil, err
+		}
+		m.Peers = append(m.Peers, p)
+	}
+
+	return &m, nil
+}
+
+// parseInfoHash decodes a hex or base32 info hash
+func parseInfoHash(encoded string) (handshake.Hash, error) {
+	var hash handshake.Hash
+
+	var decoded []byte
+	var err error
+	switch len(encoded) {
+	case 40:
+		decoded, err = hex.DecodeString(encoded)
+	case 32:
+		decoded, err = base32.StdEncoding.DecodeString(strings.ToUpper(encoded))
+	default:
+		return hash, fmt.Errorf("invalid info hash length %d", len(encoded))
+	}
+	if err != nil {
+		return hash, err
+	}
+
+	copy(hash[:], decoded)
+	return hash, nil
+}
+
+// parsePeer parses a peer in the host:port format
+func parsePeer(value string) (peer.Peer, error) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return peer.Peer{}, err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return peer.Peer{}, fmt.Errorf("invalid peer ip %q", host)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	parsedPort, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return peer.Peer{}, err
+	}
+
+	return peer.Peer{
+		IP:   ip,
+		Port: uint16(parsedPort),
+	}, nil
+}
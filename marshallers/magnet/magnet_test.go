@@ -0,0 +1,67 @@
+package magnet_test
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/magnet"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+// TestParse tests the magnet URI parsing
+func TestParse(t *testing.T) {
+	hash := [20]byte{0xc1, 0x2f, 0xe1, 0xc0, 0x6b, 0xba, 0x25, 0x4a, 0x9d, 0xc9, 0xf5, 0x19, 0xb3, 0x35, 0xaa, 0x7c, 0x13, 0x67, 0xa8, 0x8a}
+
+	testCases := []struct {
+		name        string
+		uri         string
+		trackers    []string
+		peers       []peer.Peer
+		errContains string
+	}{
+		{
+			name:     "hex",
+			uri:      "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a&dn=test&tr=http%3A%2F%2Ftracker.test%2Fannounce&x.pe=10.0.0.1:6881",
+			trackers: []string{"http://tracker.test/announce"},
+			peers:    []peer.Peer{{IP: net.IPv4(10, 0, 0, 1).To4(), Port: 6881}},
+		},
+		{
+			name: "base32",
+			uri:  "magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK&dn=test",
+		},
+		{
+			name:        "invalid scheme",
+			uri:         "http://test.org",
+			errContains: "invalid magnet scheme",
+		},
+		{
+			name:        "missing topic",
+			uri:         "magnet:?dn=test",
+			errContains: "without a btih",
+		},
+		{
+			name:        "invalid hash",
+			uri:         "magnet:?xt=urn:btih:abc",
+			errContains: "invalid info hash length",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			m, err := magnet.Parse(tc.uri)
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+
+				require.EqualValues(t, hash, m.InfoHash)
+				require.Equal(t, "test", m.DisplayName)
+				require.Equal(t, tc.trackers, m.Trackers)
+				require.Equal(t, tc.peers, m.Peers)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
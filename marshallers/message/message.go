@@ -2,6 +2,7 @@ package message
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 )
@@ -20,8 +21,30 @@ const (
 	MsgRequest       MessageID = 6
 	MsgPiece         MessageID = 7
 	MsgCancel        MessageID = 8
+	MsgExtended      MessageID = 20
 )
 
+// Types of messages from the fast extension
+// More information can be found on https://www.bittorrent.org/beps/bep_0006.html
+const (
+	MsgSuggest       MessageID = 13
+	MsgHaveAll       MessageID = 14
+	MsgHaveNone      MessageID = 15
+	MsgRejectRequest MessageID = 16
+	MsgAllowedFast   MessageID = 17
+)
+
+// Max lengths of the received messages, counting the message ID
+// The bitfield of a torrent with 8M pieces fits on MaxLength
+// The piece messages are streamed and limited by the largest block accepted
+const (
+	MaxLength      = 1024 * 1024
+	MaxPieceLength = 1 + 8 + 128*1024
+)
+
+// ErrMessageTooLong is returned for messages above the max length
+var ErrMessageTooLong = errors.New("message too long")
+
 // Message is the structure of a new peer message
 // Formed by the MessageID and a payload
 type Message struct {
@@ -29,6 +52,8 @@ type Message struct {
 	Payload []byte
 	Buffer  io.Reader
 	Length  uint32
+	// KeepAlive flags the messages without an ID, only sent to keep the connection open
+	KeepAlive bool
 }
 
 // NewMessage returns a new message
@@ -57,15 +82,57 @@ func NewRequestMessage(index, begin, length int) Message {
 	)
 }
 
+// NewCancelMessage builds a new cancel message
+// It has the same payload as the request it cancels
+func NewCancelMessage(index, begin, length int) Message {
+	msg := NewRequestMessage(index, begin, length)
+	msg.ID = MsgCancel
+	return msg
+}
+
+// NewPieceMessage builds a new piece message with a block of data
+// A piece is formed by a index, begin and the block
+func NewPieceMessage(index, begin int, block []byte) Message {
+	payload := make([]byte, 8+len(block))
+	binary.BigEndian.PutUint32(payload[:4], uint32(index))
+	binary.BigEndian.PutUint32(payload[4:8], uint32(begin))
+	copy(payload[8:], block)
+
+	return NewMessage(
+		MsgPiece,
+		payload,
+	)
+}
+
+// NewRejectMessage builds a new reject request message
+// It has the same payload as the rejected request
+func NewRejectMessage(index, begin, length int) Message {
+	msg := NewRequestMessage(index, begin, length)
+	msg.ID = MsgRejectRequest
+	return msg
+}
+
 // NewHaveMessage builds a message have
 // It accepts a index
 func NewHaveMessage(index int) Message {
+	return newIndexMessage(MsgHave, index)
+}
+
+// NewSuggestMessage builds a new suggest piece message
+func NewSuggestMessage(index int) Message {
+	return newIndexMessage(MsgSuggest, index)
+}
+
+// NewAllowedFastMessage builds a new allowed fast message
+func NewAllowedFastMessage(index int) Message {
+	return newIndexMessage(MsgAllowedFast, index)
+}
+
+// newIndexMessage builds a message with a single piece index as payload
+func newIndexMessage(id MessageID, index int) Message {
 	payload := make([]byte, 4)
-	binary.BigEndian.AppendUint32(payload[:], uint32(index))
-	return NewMessage(
-		MsgHave,
-		payload,
-	)
+	binary.BigEndian.PutUint32(payload, uint32(index))
+	return NewMessage(id, payload)
 }
 
 // Serialize a message into bytes
@@ -74,7 +141,7 @@ func NewHaveMessage(index int) Message {
 // - The message ID
 // - The payload at the end
 func (m *Message) Serialize() []byte {
-	if m == nil {
+	if m == nil || m.KeepAlive {
 		return make([]byte, 4)
 	}
 
@@ -101,7 +168,7 @@ func Unmarshal(r io.Reader) (Message, error) {
 
 	// Keep alive
 	if length == 0 {
-		return Message{}, nil
+		return Message{KeepAlive: true}, nil
 	}
 
 	// Read the message ID, a single byte
@@ -112,6 +179,15 @@ func Unmarshal(r io.Reader) (Message, error) {
 	}
 	messageID := MessageID(messageIDBuf[0])
 
+	// The length is checked before allocating the message
+	maxLength := uint32(MaxLength)
+	if messageID == MsgPiece {
+		maxLength = MaxPieceLength
+	}
+	if length > maxLength {
+		return Message{}, fmt.Errorf("%w, %d bytes for message %d", ErrMessageTooLong, length, messageID)
+	}
+
 	// Special handler for Piece messages
 	// It may be too big and we don't want to allocate that much memory
 	if messageID == MsgPiece {
@@ -137,56 +213,68 @@ func Unmarshal(r io.Reader) (Message, error) {
 	return msg, nil
 }
 
+// ErrUnexpectedIndex is returned when a piece message is for another piece
+var ErrUnexpectedIndex = errors.New("unexpected piece index")
+
 // ParsePiece parses and validates a piece from a index, buffer and a message
 // Checks for message type, min payload, index, offset
 // returns the
 func ParsePiece(index int, buf []byte, msg Message) (int, error) {
+	_, n, err := ParsePieceBlock(index, buf, msg)
+	return n, err
+}
+
+// ParsePieceBlock parses a piece message into the buffer at the block offset
+// Returns the offset and the length of the block
+// Invalid blocks are drained from the message, so the next message can be read
+func ParsePieceBlock(index int, buf []byte, msg Message) (int, int, error) {
 	// The id must be Piece
 	if msg.ID != MsgPiece {
-		return 0, fmt.Errorf("Expected PIECE (ID %d), got ID %d", MsgPiece, msg.ID)
+		return 0, 0, fmt.Errorf("Expected PIECE (ID %d), got ID %d", MsgPiece, msg.ID)
+	}
+	if msg.Length < 8 {
+		return 0, 0, fmt.Errorf("Expected payload length of at least 8, got length %d", msg.Length)
 	}
 
-	// Read the index from the buffer
-	pieceIndex := make([]byte, 4)
-	_, err := io.ReadFull(msg.Buffer, pieceIndex)
+	// Read the index and the offset from the buffer
+	header := make([]byte, 8)
+	_, err := io.ReadFull(msg.Buffer, header)
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	parsedIndex := int(binary.BigEndian.Uint32(header[0:4]))
+	begin := int(binary.BigEndian.Uint32(header[4:8]))
+	length := int(msg.Length) - 8
 
 	// Check if it's the correct index
-	parsedIndex := int(binary.BigEndian.Uint32(pieceIndex))
 	if parsedIndex != index {
-		return 0, fmt.Errorf("Expected index %d, got %d", index, parsedIndex)
+		return 0, 0, drainBlock(msg, length, fmt.Errorf("%w, expected %d, got %d", ErrUnexpectedIndex, index, parsedIndex))
 	}
 
-	// Read the index from the buffer
-	pieceOffset := make([]byte, 4)
-	_, err = io.ReadFull(msg.Buffer, pieceOffset)
-	if err != nil {
-		return 0, err
-	}
-
-	// Check if the the offset is correct
-	begin := int(binary.BigEndian.Uint32(pieceOffset))
+	// Check the offset and the length against the buffer
 	if begin >= len(buf) {
-		return 0, fmt.Errorf("Begin offset too high. %d >= %d", begin, len(buf))
+		return 0, 0, drainBlock(msg, length, fmt.Errorf("Begin offset too high. %d >= %d", begin, len(buf)))
+	}
+	if begin+length > len(buf) {
+		return 0, 0, drainBlock(msg, length, fmt.Errorf("Data too long [%d] for offset %d with length %d", length, begin, len(buf)))
 	}
 
-	// Read the data from the buffer, we can mke it go directly to our expected buf
-	pieceData := buf[begin : begin+(int(msg.Length)-8)]
-	_, err = io.ReadFull(msg.Buffer, pieceData)
+	// Read the data from the buffer directly to the expected buf
+	_, err = io.ReadFull(msg.Buffer, buf[begin:begin+length])
 	if err != nil {
-		return 0, err
+		return 0, 0, err
 	}
+	return begin, length, nil
+}
 
-	// Check the payload data against the offset and length
-	if begin+len(pieceData) > len(buf) {
-		return 0, fmt.Errorf("Data too long [%d] for offset %d with length %d", len(pieceData), begin, len(buf))
+// drainBlock discards the block data of a invalid piece message
+// The parse error is returned unless the drain fails
+func drainBlock(msg Message, length int, parseErr error) error {
+	_, err := io.CopyN(io.Discard, msg.Buffer, int64(length))
+	if err != nil {
+		return err
 	}
-
-	// Writes the buf to the data at offset
-	copy(buf[begin:], pieceData)
-	return len(pieceData), nil
+	return parseErr
 }
 
 // ParseHave parses a message have, and returns the index
@@ -194,9 +282,54 @@ func ParseHave(msg Message) (int, error) {
 	if msg.ID != MsgHave {
 		return 0, fmt.Errorf("Expected HAVE (ID %d), got ID %d", MsgHave, msg.ID)
 	}
+	return parseIndex(msg)
+}
+
+// ParseSuggest parses a suggest piece message, and returns the index
+func ParseSuggest(msg Message) (int, error) {
+	if msg.ID != MsgSuggest {
+		return 0, fmt.Errorf("Expected SUGGEST (ID %d), got ID %d", MsgSuggest, msg.ID)
+	}
+	return parseIndex(msg)
+}
+
+// ParseAllowedFast parses a allowed fast message, and returns the index
+func ParseAllowedFast(msg Message) (int, error) {
+	if msg.ID != MsgAllowedFast {
+		return 0, fmt.Errorf("Expected ALLOWED FAST (ID %d), got ID %d", MsgAllowedFast, msg.ID)
+	}
+	return parseIndex(msg)
+}
+
+// parseIndex parses the piece index from the payload
+func parseIndex(msg Message) (int, error) {
 	if len(msg.Payload) != 4 {
 		return 0, fmt.Errorf("Expected payload length 4, got length %d", len(msg.Payload))
 	}
 	index := int(binary.BigEndian.Uint32(msg.Payload))
 	return index, nil
 }
+
+// ParseRequest parses a request, cancel or reject request message
+// returns the index, begin and length
+func ParseRequest(msg Message) (int, int, int, error) {
+	if msg.ID != MsgRequest && msg.ID != MsgCancel && msg.ID != MsgRejectRequest {
+		return 0, 0, 0, fmt.Errorf("Expected REQUEST, CANCEL or REJECT (ID %d, %d or %d), got ID %d", MsgRequest, MsgCancel, MsgRejectRequest, msg.ID)
+	}
+	if len(msg.Payload) != 12 {
+		return 0, 0, 0, fmt.Errorf("Expected payload length 12, got length %d", len(msg.Payload))
+	}
+	index := int(binary.BigEndian.Uint32(msg.Payload[0:4]))
+	begin := int(binary.BigEndian.Uint32(msg.Payload[4:8]))
+	length := int(binary.BigEndian.Uint32(msg.Payload[8:12]))
+	return index, begin, length, nil
+}
+
+// DiscardPiece drains the data from a piece message that won't be parsed
+func DiscardPiece(msg Message) error {
+	if msg.ID != MsgPiece {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, msg.Buffer, int64(msg.Length))
+	return err
+}
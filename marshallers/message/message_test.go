@@ -0,0 +1,214 @@
+package message_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/message"
+)
+
+// TestIndexMessages tests the messages with a single piece index
+func TestIndexMessages(t *testing.T) {
+	testCases := []struct {
+		name  string
+		msg   message.Message
+		parse func(message.Message) (int, error)
+	}{
+		{
+			name:  "have",
+			msg:   message.NewHaveMessage(258),
+			parse: message.ParseHave,
+		},
+		{
+			name:  "suggest",
+			msg:   message.NewSuggestMessage(258),
+			parse: message.ParseSuggest,
+		},
+		{
+			name:  "allowed fast",
+			msg:   message.NewAllowedFastMessage(258),
+			parse: message.ParseAllowedFast,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			raw := tc.msg.Serialize()
+			require.Equal(t, []byte{0, 0, 0, 5, byte(tc.msg.ID), 0, 0, 1, 2}, raw)
+
+			parsed, err := message.Unmarshal(bytes.NewReader(raw))
+			require.NoError(t, err)
+			index, err := tc.parse(parsed)
+			require.NoError(t, err)
+			require.Equal(t, 258, index)
+
+			// Other messages are refused
+			_, err = tc.parse(message.NewMessage(message.MsgChoke, nil))
+			require.Error(t, err)
+		})
+	}
+}
+
+// TestKeepAlive tests that keep alives aren't read as choke messages
+func TestKeepAlive(t *testing.T) {
+	keepAlive := message.Message{KeepAlive: true}
+	raw := keepAlive.Serialize()
+	require.Equal(t, []byte{0, 0, 0, 0}, raw)
+
+	parsed, err := message.Unmarshal(bytes.NewReader(raw))
+	require.NoError(t, err)
+	require.True(t, parsed.KeepAlive)
+
+	choke := message.NewMessage(message.MsgChoke, nil)
+	parsed, err = message.Unmarshal(bytes.NewReader(choke.Serialize()))
+	require.NoError(t, err)
+	require.False(t, parsed.KeepAlive)
+	require.Equal(t, message.MsgChoke, parsed.ID)
+}
+
+// TestMessageLength tests the max length of the messages
+func TestMessageLength(t *testing.T) {
+	testCases := []struct {
+		name        string
+		id          message.MessageID
+		length      int
+		errContains string
+	}{
+		{
+			name:   "max bitfield",
+			id:     message.MsgBitfield,
+			length: message.MaxLength,
+		},
+		{
+			name:        "bitfield too long",
+			id:          message.MsgBitfield,
+			length:      message.MaxLength + 1,
+			errContains: message.ErrMessageTooLong.Error(),
+		},
+		{
+			name:   "max piece",
+			id:     message.MsgPiece,
+			length: message.MaxPieceLength,
+		},
+		{
+			name:        "piece too long",
+			id:          message.MsgPiece,
+			length:      message.MaxPieceLength + 1,
+			errContains: message.ErrMessageTooLong.Error(),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg := message.NewMessage(tc.id, make([]byte, tc.length-1))
+			parsed, err := message.Unmarshal(bytes.NewReader(msg.Serialize()))
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.id, parsed.ID)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestRequestMessages tests the messages with a block request
+func TestRequestMessages(t *testing.T) {
+	for _, msg := range []message.Message{
+		message.NewRequestMessage(1, 16384, 16384),
+		message.NewCancelMessage(1, 16384, 16384),
+		message.NewRejectMessage(1, 16384, 16384),
+	} {
+		parsed, err := message.Unmarshal(bytes.NewReader(msg.Serialize()))
+		require.NoError(t, err)
+		require.Equal(t, msg.ID, parsed.ID)
+
+		index, begin, length, err := message.ParseRequest(parsed)
+		require.NoError(t, err)
+		require.Equal(t, []int{1, 16384, 16384}, []int{index, begin, length})
+	}
+
+	_, _, _, err := message.ParseRequest(message.NewHaveMessage(1))
+	require.Error(t, err)
+}
+
+// TestParsePieceBlock tests the piece parse and the drain of the invalid blocks
+func TestParsePieceBlock(t *testing.T) {
+	testCases := []struct {
+		name        string
+		index       int
+		begin       int
+		block       []byte
+		bufLength   int
+		errContains string
+	}{
+		{
+			name:      "pass",
+			index:     1,
+			begin:     4,
+			block:     []byte{1, 2, 3, 4},
+			bufLength: 8,
+		},
+		{
+			name:        "unexpected index",
+			index:       2,
+			block:       []byte{1, 2, 3, 4},
+			bufLength:   8,
+			errContains: message.ErrUnexpectedIndex.Error(),
+		},
+		{
+			name:        "begin too high",
+			index:       1,
+			begin:       8,
+			block:       []byte{1, 2, 3, 4},
+			bufLength:   8,
+			errContains: "Begin offset too high",
+		},
+		{
+			name:        "data too long",
+			index:       1,
+			begin:       6,
+			block:       []byte{1, 2, 3, 4},
+			bufLength:   8,
+			errContains: "Data too long",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			// The piece is followed by a have, which must still be readable
+			stream := bytes.NewBuffer(nil)
+			piece := message.NewPieceMessage(tc.index, tc.begin, tc.block)
+			have := message.NewHaveMessage(3)
+			stream.Write(piece.Serialize())
+			stream.Write(have.Serialize())
+
+			msg, err := message.Unmarshal(stream)
+			require.NoError(t, err)
+
+			buf := make([]byte, tc.bufLength)
+			begin, n, err := message.ParsePieceBlock(1, buf, msg)
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.begin, begin)
+				require.Equal(t, len(tc.block), n)
+				require.Equal(t, tc.block, buf[begin:begin+n])
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+
+			next, err := message.Unmarshal(stream)
+			require.NoError(t, err)
+			index, err := message.ParseHave(next)
+			require.NoError(t, err)
+			require.Equal(t, 3, index)
+		})
+	}
+
+	// A payload without the index and the offset
+	_, _, err := message.ParsePieceBlock(1, make([]byte, 8), message.Message{ID: message.MsgPiece, Length: 4})
+	require.ErrorContains(t, err, "at least 8")
+}
@@ -4,6 +4,14 @@ import (
 	"encoding/binary"
 	"fmt"
 	"net"
+	"strconv"
+)
+
+const (
+	// compactLength is the length of a compact IPv4 peer
+	compactLength = 6
+	// compact6Length is the length of a compact IPv6 peer
+	compact6Length = 18
 )
 
 // This represents a single peer in a announce response
@@ -16,16 +24,16 @@ type Peer struct {
 func Unmarshal(peersBytes []byte) ([]Peer, error) {
 	// Ensure that we have the correct length to parse
 	// each peer is 6 bytes
-	if len(peersBytes)%6 != 0 {
+	if len(peersBytes)%compactLength != 0 {
 		return []Peer{}, fmt.Errorf("invalid piers length")
 	}
 
 	// number of peers
-	nPeers := len(peersBytes) / 6
+	nPeers := len(peersBytes) / compactLength
 
 	peers := make([]Peer, nPeers)
 	for i := 0; i < nPeers; i++ {
-		offset := i * 6
+		offset := i * compactLength
 		// First four bytes
 		peers[i].IP = net.IP(peersBytes[offset : offset+4])
 		peers[i].Port = binary.BigEndian.Uint16(peersBytes[offset+4 : offset+6])
@@ -34,7 +42,55 @@ func Unmarshal(peersBytes []byte) ([]Peer, error) {
 	return peers, nil
 }
 
+// Unmarshal6 parses the compact IPv6 peers
+// Each peer is 16 bytes for the IP and 2 for the port
+func Unmarshal6(peersBytes []byte) ([]Peer, error) {
+	if len(peersBytes)%compact6Length != 0 {
+		return []Peer{}, fmt.Errorf("invalid IPv6 peers length")
+	}
+
+	nPeers := len(peersBytes) / compact6Length
+	peers := make([]Peer, nPeers)
+	for i := 0; i < nPeers; i++ {
+		offset := i * compact6Length
+		peers[i].IP = net.IP(peersBytes[offset : offset+16])
+		peers[i].Port = binary.BigEndian.Uint16(peersBytes[offset+16 : offset+18])
+	}
+
+	return peers, nil
+}
+
+// Marshal serializes the IPv4 peers into the compact form
+// Peers without a IPv4 address are skipped
+func Marshal(peers []Peer) []byte {
+	buf := make([]byte, 0, len(peers)*compactLength)
+	for _, p := range peers {
+		ip := p.IP.To4()
+		if ip == nil {
+			continue
+		}
+		buf = append(buf, ip...)
+		buf = binary.BigEndian.AppendUint16(buf, p.Port)
+	}
+	return buf
+}
+
+// Marshal6 serializes the IPv6 peers into the compact form
+// Peers with a IPv4 address are skipped
+func Marshal6(peers []Peer) []byte {
+	buf := make([]byte, 0, len(peers)*compact6Length)
+	for _, p := range peers {
+		if p.IP.To4() != nil || len(p.IP) != net.IPv6len {
+			continue
+		}
+		buf = append(buf, p.IP...)
+		buf = binary.BigEndian.AppendUint16(buf, p.Port)
+	}
+	return buf
+}
+
 // String returns a string representation for the Peer object
+// IPv6 addresses are wrapped in brackets
 func (p Peer) String() string {
-	return fmt.Sprintf("%s:%v", p.IP.String(), p.Port)
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
 }
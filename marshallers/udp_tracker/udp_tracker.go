@@ -0,0 +1,211 @@
+package udptracker
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+)
+
+// Action is the type of a UDP tracker packet
+type Action uint32
+
+// Types of actions
+// More information can be found on https://www.bittorrent.org/beps/bep_0015.html
+const (
+	ActionConnect  Action = 0
+	ActionAnnounce Action = 1
+	ActionScrape   Action = 2
+	ActionError    Action = 3
+)
+
+// ProtocolID is the magic constant sent on connect requests
+const ProtocolID uint64 = 0x41727101980
+
+// headerLength is the length of the action and transaction id
+const headerLength = 8
+
+// ConnectRequest is the first packet sent to a tracker
+type ConnectRequest struct {
+	TransactionID uint32
+}
+
+// ConnectResponse returns the connection id used on the next requests
+type ConnectResponse struct {
+	TransactionID uint32
+	ConnectionID  uint64
+}
+
+// AnnounceRequest is the UDP version of the announce
+type AnnounceRequest struct {
+	ConnectionID  uint64
+	TransactionID uint32
+	InfoHash      handshake.Hash
+	PeerID        handshake.PeerID
+	Downloaded    uint64
+	Left          uint64
+	Uploaded      uint64
+	Event         uint32
+	Key           uint32
+	NumWant       int32
+	Port          uint16
+}
+
+// AnnounceResponse is the announce response with the compact peers
+type AnnounceResponse struct {
+	TransactionID uint32
+	Interval      uint32
+	Leechers      uint32
+	Seeders       uint32
+	Peers         []byte
+}
+
+// ScrapeRequest requests the swarm information for a list of info hashes
+type ScrapeRequest struct {
+	ConnectionID  uint64
+	TransactionID uint32
+	InfoHashes    []handshake.Hash
+}
+
+// ScrapeInfo is the swarm information for a single info hash
+type ScrapeInfo struct {
+	Seeders   uint32
+	Completed uint32
+	Leechers  uint32
+}
+
+// ScrapeResponse is the response for a scrape, in the requested order
+type ScrapeResponse struct {
+	TransactionID uint32
+	Infos         []ScrapeInfo
+}
+
+// Marshal serializes the connect request
+// It's formed by:
+// - The protocol id
+// - The action
+// - The transaction id
+func (r ConnectRequest) Marshal() []byte {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], ProtocolID)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(ActionConnect))
+	binary.BigEndian.PutUint32(buf[12:16], r.TransactionID)
+	return buf
+}
+
+// Marshal serializes the announce request
+// The IP address is always 0, letting the tracker use the sender address
+func (r AnnounceRequest) Marshal() []byte {
+	buf := make([]byte, 98)
+	binary.BigEndian.PutUint64(buf[0:8], r.ConnectionID)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(ActionAnnounce))
+	binary.BigEndian.PutUint32(buf[12:16], r.TransactionID)
+	copy(buf[16:36], r.InfoHash[:])
+	copy(buf[36:56], r.PeerID[:])
+	binary.BigEndian.PutUint64(buf[56:64], r.Downloaded)
+	binary.BigEndian.PutUint64(buf[64:72], r.Left)
+	binary.BigEndian.PutUint64(buf[72:80], r.Uploaded)
+	binary.BigEndian.PutUint32(buf[80:84], r.Event)
+	// IP address at buf[84:88] is kept as 0
+	binary.BigEndian.PutUint32(buf[88:92], r.Key)
+	binary.BigEndian.PutUint32(buf[92:96], uint32(r.NumWant))
+	binary.BigEndian.PutUint16(buf[96:98], r.Port)
+	return buf
+}
+
+// Marshal serializes the scrape request
+func (r ScrapeRequest) Marshal() []byte {
+	buf := make([]byte, 16+20*len(r.InfoHashes))
+	binary.BigEndian.PutUint64(buf[0:8], r.ConnectionID)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(ActionScrape))
+	binary.BigEndian.PutUint32(buf[12:16], r.TransactionID)
+	for i, hash := range r.InfoHashes {
+		copy(buf[16+i*20:], hash[:])
+	}
+	return buf
+}
+
+// UnmarshalHeader reads the action and the transaction id from a response
+func UnmarshalHeader(buf []byte) (Action, uint32, error) {
+	if len(buf) < headerLength {
+		return 0, 0, fmt.Errorf("response too short, got length %d", len(buf))
+	}
+	action := Action(binary.BigEndian.Uint32(buf[0:4]))
+	transactionID := binary.BigEndian.Uint32(buf[4:8])
+	return action, transactionID, nil
+}
+
+// UnmarshalConnect reads a connect response
+func UnmarshalConnect(buf []byte) (*ConnectResponse, error) {
+	if err := checkResponse(buf, ActionConnect, 16); err != nil {
+		return nil, err
+	}
+	return &ConnectResponse{
+		TransactionID: binary.BigEndian.Uint32(buf[4:8]),
+		ConnectionID:  binary.BigEndian.Uint64(buf[8:16]),
+	}, nil
+}
+
+// UnmarshalAnnounce reads a announce response
+// The peers are kept in the compact format
+func UnmarshalAnnounce(buf []byte) (*AnnounceResponse, error) {
+	if err := checkResponse(buf, ActionAnnounce, 20); err != nil {
+		return nil, err
+	}
+	return &AnnounceResponse{
+		TransactionID: binary.BigEndian.Uint32(buf[4:8]),
+		Interval:      binary.BigEndian.Uint32(buf[8:12]),
+		Leechers:      binary.BigEndian.Uint32(buf[12:16]),
+		Seeders:       binary.BigEndian.Uint32(buf[16:20]),
+		Peers:         buf[20:],
+	}, nil
+}
+
+// UnmarshalScrape reads a scrape response
+// Each info hash has 12 bytes with seeders, completed and leechers
+func UnmarshalScrape(buf []byte) (*ScrapeResponse, error) {
+	if err := checkResponse(buf, ActionScrape, headerLength); err != nil {
+		return nil, err
+	}
+	if (len(buf)-headerLength)%12 != 0 {
+		return nil, fmt.Errorf("invalid scrape response length %d", len(buf))
+	}
+
+	infos := make([]ScrapeInfo, (len(buf)-headerLength)/12)
+	for i := range infos {
+		offset := headerLength + i*12
+		infos[i] = ScrapeInfo{
+			Seeders:   binary.BigEndian.Uint32(buf[offset : offset+4]),
+			Completed: binary.BigEndian.Uint32(buf[offset+4 : offset+8]),
+			Leechers:  binary.BigEndian.Uint32(buf[offset+8 : offset+12]),
+		}
+	}
+
+	return &ScrapeResponse{
+		TransactionID: binary.BigEndian.Uint32(buf[4:8]),
+		Infos:         infos,
+	}, nil
+}
+
+// UnmarshalError reads the message from a error response
+func UnmarshalError(buf []byte) (string, error) {
+	if err := checkResponse(buf, ActionError, headerLength); err != nil {
+		return "", err
+	}
+	return string(buf[headerLength:]), nil
+}
+
+// checkResponse validates the action and the min length of a response
+func checkResponse(buf []byte, expected Action, minLength int) error {
+	action, _, err := UnmarshalHeader(buf)
+	if err != nil {
+		return err
+	}
+	if action != expected {
+		return fmt.Errorf("Expected action %d, got action %d", expected, action)
+	}
+	if len(buf) < minLength {
+		return fmt.Errorf("Expected length of at least %d, got length %d", minLength, len(buf))
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package utp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+type Type uint8
+
+// Types of packets
+// More information can be found on https://www.bittorrent.org/beps/bep_0029.html
+const (
+	TypeData  Type = 0
+	TypeFin   Type = 1
+	TypeState Type = 2
+	TypeReset Type = 3
+	TypeSyn   Type = 4
+)
+
+const (
+	// Version is the only version of the protocol
+	Version = 1
+	// HeaderLength is the length of the header without extensions
+	HeaderLength = 20
+
+	// extensionSelectiveAck is the id of the selective ack extension
+	extensionSelectiveAck = 1
+)
+
+// Header is the header of a uTP packet
+type Header struct {
+	Type          Type
+	ConnID        uint16
+	Timestamp     uint32
+	TimestampDiff uint32
+	WindowSize    uint32
+	SeqNr         uint16
+	AckNr         uint16
+
+	// SelectiveAck is a bitmask of the packets received after ack_nr + 1
+	// The first bit is ack_nr + 2, its length is a multiple of 4
+	SelectiveAck []byte
+}
+
+// Marshal builds a packet with the header and a payload
+func (h Header) Marshal(payload []byte) []byte {
+	length := HeaderLength + len(payload)
+	extension := byte(0)
+	if len(h.SelectiveAck) > 0 {
+		extension = extensionSelectiveAck
+		length += 2 + len(h.SelectiveAck)
+	}
+
+	buf := make([]byte, HeaderLength, length)
+	buf[0] = byte(h.Type)<<4 | Version
+	buf[1] = extension
+	binary.BigEndian.PutUint16(buf[2:4], h.ConnID)
+	binary.BigEndian.PutUint32(buf[4:8], h.Timestamp)
+	binary.BigEndian.PutUint32(buf[8:12], h.TimestampDiff)
+	binary.BigEndian.PutUint32(buf[12:16], h.WindowSize)
+	binary.BigEndian.PutUint16(buf[16:18], h.SeqNr)
+	binary.BigEndian.PutUint16(buf[18:20], h.AckNr)
+
+	if len(h.SelectiveAck) > 0 {
+		// The selective ack is the last extension
+		buf = append(buf, 0, byte(len(h.SelectiveAck)))
+		buf = append(buf, h.SelectiveAck...)
+	}
+	return append(buf, payload...)
+}
+
+// Unmarshal parses a packet into the header and the payload
+// Unknown extensions are skipped
+func Unmarshal(packet []byte) (Header, []byte, error) {
+	if len(packet) < HeaderLength {
+		return Header{}, nil, errors.New("packet too short")
+	}
+	if packet[0]&0x0f != Version {
+		return Header{}, nil, fmt.Errorf("invalid version %d", packet[0]&0x0f)
+	}
+	packetType := Type(packet[0] >> 4)
+	if packetType > TypeSyn {
+		return Header{}, nil, fmt.Errorf("invalid packet type %d", packetType)
+	}
+
+	h := Header{
+		Type:          packetType,
+		ConnID:        binary.BigEndian.Uint16(packet[2:4]),
+		Timestamp:     binary.BigEndian.Uint32(packet[4:8]),
+		TimestampDiff: binary.BigEndian.Uint32(packet[8:12]),
+		WindowSize:    binary.BigEndian.Uint32(packet[12:16]),
+		SeqNr:         binary.BigEndian.Uint16(packet[16:18]),
+		AckNr:         binary.BigEndian.Uint16(packet[18:20]),
+	}
+
+	// The extensions are a linked list
+	extension := packet[1]
+	data := packet[HeaderLength:]
+	for extension != 0 {
+		if len(data) < 2 {
+			return Header{}, nil, errors.New("invalid extension header")
+		}
+		next, length := data[0], int(data[1])
+		if len(data) < 2+length {
+			return Header{}, nil, errors.New("invalid extension length")
+		}
+		if extension == extensionSelectiveAck {
+			if length == 0 || length%4 != 0 {
+				return Header{}, nil, errors.New("invalid selective ack length")
+			}
+			h.SelectiveAck = data[2 : 2+length]
+		}
+		extension = next
+		data = data[2+length:]
+	}
+
+	return h, data, nil
+}
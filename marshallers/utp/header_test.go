@@ -0,0 +1,103 @@
+package utp_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/utp"
+)
+
+// TestHeader tests the marshal and unmarshal of the packets
+func TestHeader(t *testing.T) {
+	testCases := []struct {
+		name    string
+		header  utp.Header
+		payload []byte
+	}{
+		{
+			name: "syn",
+			header: utp.Header{
+				Type:       utp.TypeSyn,
+				ConnID:     1234,
+				Timestamp:  1000,
+				WindowSize: 1 << 20,
+				SeqNr:      1,
+			},
+		},
+		{
+			name: "data",
+			header: utp.Header{
+				Type:          utp.TypeData,
+				ConnID:        1235,
+				Timestamp:     2000,
+				TimestampDiff: 300,
+				WindowSize:    4096,
+				SeqNr:         65535,
+				AckNr:         10,
+			},
+			payload: []byte("hello"),
+		},
+		{
+			name: "state with selective ack",
+			header: utp.Header{
+				Type:         utp.TypeState,
+				ConnID:       1,
+				SeqNr:        5,
+				AckNr:        7,
+				SelectiveAck: []byte{0x05, 0, 0, 0x80},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			packet := tc.header.Marshal(tc.payload)
+			require.Equal(t, byte(tc.header.Type)<<4|utp.Version, packet[0])
+
+			header, payload, err := utp.Unmarshal(packet)
+			require.NoError(t, err)
+			require.Equal(t, tc.header, header)
+			require.Equal(t, len(tc.payload), len(payload))
+			if len(tc.payload) > 0 {
+				require.Equal(t, tc.payload, payload)
+			}
+		})
+	}
+}
+
+// TestUnmarshalInvalid tests that non uTP packets are refused
+func TestUnmarshalInvalid(t *testing.T) {
+	testCases := []struct {
+		name   string
+		packet []byte
+	}{
+		{
+			name:   "short",
+			packet: []byte{0x01, 0},
+		},
+		{
+			name:   "dht message",
+			packet: []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe"),
+		},
+		{
+			name:   "invalid type",
+			packet: append([]byte{0x51}, make([]byte, 19)...),
+		},
+		{
+			name:   "truncated extension",
+			packet: append([]byte{0x21, 1}, make([]byte, 18)...),
+		},
+		{
+			name:   "invalid selective ack",
+			packet: append(append([]byte{0x21, 1}, make([]byte, 18)...), 0, 3, 1, 2, 3),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, _, err := utp.Unmarshal(tc.packet)
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,423 @@
+package mse
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/rc4"
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+)
+
+// Crypto methods negotiated with crypto_provide and crypto_select
+const (
+	CryptoPlaintext uint32 = 0x01
+	CryptoRC4       uint32 = 0x02
+)
+
+const (
+	// keyLength is the length of the public keys
+	keyLength = 96
+	// maxPadLength is the max length of the paddings
+	maxPadLength = 512
+	// discardLength is the number of RC4 bytes discarded before use
+	discardLength = 1024
+)
+
+var (
+	// prime is the 768 bits prime for the Diffie-Hellman exchange
+	prime, _ = new(big.Int).SetString(
+		"FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74"+
+			"020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437"+
+			"4FE1356D6D51C245E485B576625E7EC6F44C42E9A63A36210000000000090563", 16)
+	// generator is the Diffie-Hellman generator
+	generator = big.NewInt(2)
+	// vc is the verification constant
+	vc = make([]byte, 8)
+)
+
+// ErrNoCommonMethod is returned when the sides don't share a crypto method
+var ErrNoCommonMethod = errors.New("no common crypto method")
+
+// Conn is a connection after the encryption handshake
+// The payload stream is RC4 encrypted unless plaintext was selected
+// Writes are serialized since the cipher keeps the stream position
+type Conn struct {
+	net.Conn
+	Method uint32
+
+	pending []byte
+	reader  io.Reader
+	dec     *rc4.Cipher
+
+	writeMu sync.Mutex
+	enc     *rc4.Cipher
+}
+
+// Read reads and decrypts from the payload stream
+// The initial payload from the handshake is read first
+func (c *Conn) Read(b []byte) (int, error) {
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		return n, nil
+	}
+
+	n, err := c.reader.Read(b)
+	if c.dec != nil {
+		c.dec.XORKeyStream(b[:n], b[:n])
+	}
+	return n, err
+}
+
+// Write encrypts and writes into the payload stream
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.enc == nil {
+		return c.Conn.Write(b)
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	buf := make([]byte, len(b))
+	c.enc.XORKeyStream(buf, b)
+	return c.Conn.Write(buf)
+}
+
+// Initiate does the outgoing side of the handshake
+// The info hash is the shared secret and provide has the accepted methods
+// More information can be found on https://wiki.vuze.com/w/Message_Stream_Encryption
+func Initiate(conn net.Conn, infoHash [20]byte, provide uint32) (*Conn, error) {
+	reader := bufio.NewReader(conn)
+
+	// 1 A->B: Diffie Hellman Ya, PadA
+	private, public, err := newKeys()
+	if err != nil {
+		return nil, err
+	}
+	pad, err := randomPad()
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write(append(public, pad...))
+	if err != nil {
+		return nil, err
+	}
+
+	// 2 B->A: Diffie Hellman Yb, PadB
+	remote := make([]byte, keyLength)
+	_, err = io.ReadFull(reader, remote)
+	if err != nil {
+		return nil, err
+	}
+	secret := sharedSecret(private, remote)
+
+	// 3 A->B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S), ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA))
+	enc, err := newCipher("keyA", secret, infoHash[:])
+	if err != nil {
+		return nil, err
+	}
+	dec, err := newCipher("keyB", secret, infoHash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	req := hash([]byte("req1"), secret)
+	req = append(req, xor(hash([]byte("req2"), infoHash[:]), hash([]byte("req3"), secret))...)
+	header := append([]byte{}, vc...)
+	header = binary.BigEndian.AppendUint32(header, provide)
+	header = binary.BigEndian.AppendUint16(header, 0) // len(PadC)
+	header = binary.BigEndian.AppendUint16(header, 0) // len(IA)
+	enc.XORKeyStream(header, header)
+	_, err = conn.Write(append(req, header...))
+	if err != nil {
+		return nil, err
+	}
+
+	// 4 B->A: ENCRYPT(VC, crypto_select, len(padD), padD)
+	// The encrypted VC marks the end of PadB
+	encryptedVC := make([]byte, len(vc))
+	dec.XORKeyStream(encryptedVC, vc)
+	err = syncTo(reader, encryptedVC, maxPadLength+len(vc))
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make([]byte, 6)
+	_, err = io.ReadFull(reader, selected)
+	if err != nil {
+		return nil, err
+	}
+	dec.XORKeyStream(selected, selected)
+	method := binary.BigEndian.Uint32(selected[:4])
+	if (method != CryptoRC4 && method != CryptoPlaintext) || method&provide == 0 {
+		return nil, fmt.Errorf("invalid crypto select %d", method)
+	}
+	err = skipPad(reader, dec, int(binary.BigEndian.Uint16(selected[4:])))
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(conn, reader, method, enc, dec, nil), nil
+}
+
+// Accept does the incoming side of the handshake
+// The info hash is found from the hashes of the known info hashes
+// Returns the info hash used as the shared secret
+func Accept(conn net.Conn, infoHashes [][20]byte, allowed uint32) (*Conn, [20]byte, error) {
+	reader := bufio.NewReader(conn)
+
+	// 1 A->B: Diffie Hellman Ya, PadA
+	remote := make([]byte, keyLength)
+	_, err := io.ReadFull(reader, remote)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	// 2 B->A: Diffie Hellman Yb, PadB
+	// Written on the background since PadA may still be on the way
+	private, public, err := newKeys()
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	pad, err := randomPad()
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	written := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(append(public, pad...))
+		written <- err
+	}()
+	var writeErr error
+	var writeOnce sync.Once
+	waitWrite := func() error {
+		writeOnce.Do(func() {
+			writeErr = <-written
+		})
+		return writeErr
+	}
+	secret := sharedSecret(private, remote)
+
+	c, infoHash, err := acceptRequest(conn, reader, secret, infoHashes, allowed, waitWrite)
+	if err != nil {
+		// The writer is released by closing the connection
+		conn.Close()
+		waitWrite() //nolint:errcheck
+		return nil, [20]byte{}, err
+	}
+	return c, infoHash, nil
+}
+
+// acceptRequest reads the request and answers with the selected method
+func acceptRequest(conn net.Conn, reader *bufio.Reader, secret []byte, infoHashes [][20]byte, allowed uint32, waitWrite func() error) (*Conn, [20]byte, error) {
+	// 3 A->B: HASH('req1', S), HASH('req2', SKEY) xor HASH('req3', S), ENCRYPT(VC, crypto_provide, len(PadC), PadC, len(IA)), ENCRYPT(IA)
+	// The req1 hash marks the end of PadA
+	err := syncTo(reader, hash([]byte("req1"), secret), maxPadLength+sha1.Size)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	// Find the info hash from the obfuscated hash
+	obfuscated := make([]byte, sha1.Size)
+	_, err = io.ReadFull(reader, obfuscated)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	req2 := xor(obfuscated, hash([]byte("req3"), secret))
+	var infoHash [20]byte
+	found := false
+	for _, candidate := range infoHashes {
+		if bytes.Equal(req2, hash([]byte("req2"), candidate[:])) {
+			infoHash = candidate
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, [20]byte{}, errors.New("unknown info hash")
+	}
+
+	dec, err := newCipher("keyA", secret, infoHash[:])
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	enc, err := newCipher("keyB", secret, infoHash[:])
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	header := make([]byte, 14)
+	_, err = io.ReadFull(reader, header)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	dec.XORKeyStream(header, header)
+	if !bytes.Equal(header[:8], vc) {
+		return nil, [20]byte{}, errors.New("invalid verification constant")
+	}
+	provide := binary.BigEndian.Uint32(header[8:12])
+	err = skipPad(reader, dec, int(binary.BigEndian.Uint16(header[12:14])))
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	// The initial payload is always encrypted
+	iaLength := make([]byte, 2)
+	_, err = io.ReadFull(reader, iaLength)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	dec.XORKeyStream(iaLength, iaLength)
+	ia := make([]byte, binary.BigEndian.Uint16(iaLength))
+	_, err = io.ReadFull(reader, ia)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+	dec.XORKeyStream(ia, ia)
+
+	// RC4 is selected over plaintext
+	method := selectMethod(provide, allowed)
+	if method == 0 {
+		return nil, [20]byte{}, ErrNoCommonMethod
+	}
+
+	// 4 B->A: ENCRYPT(VC, crypto_select, len(padD), padD)
+	if err := waitWrite(); err != nil {
+		return nil, [20]byte{}, err
+	}
+	res := append([]byte{}, vc...)
+	res = binary.BigEndian.AppendUint32(res, method)
+	res = binary.BigEndian.AppendUint16(res, 0) // len(PadD)
+	enc.XORKeyStream(res, res)
+	_, err = conn.Write(res)
+	if err != nil {
+		return nil, [20]byte{}, err
+	}
+
+	return newConn(conn, reader, method, enc, dec, ia), infoHash, nil
+}
+
+// newConn builds the connection for the selected method
+// The initial payload has already been decrypted
+func newConn(conn net.Conn, reader io.Reader, method uint32, enc, dec *rc4.Cipher, ia []byte) *Conn {
+	c := &Conn{
+		Conn:    conn,
+		Method:  method,
+		pending: ia,
+		reader:  reader,
+	}
+	if method == CryptoRC4 {
+		c.enc = enc
+		c.dec = dec
+	}
+	return c
+}
+
+// selectMethod picks the best method provided and allowed
+func selectMethod(provide, allowed uint32) uint32 {
+	common := provide & allowed
+	switch {
+	case common&CryptoRC4 != 0:
+		return CryptoRC4
+	case common&CryptoPlaintext != 0:
+		return CryptoPlaintext
+	}
+	return 0
+}
+
+// newKeys returns a new private key and its public key
+func newKeys() (*big.Int, []byte, error) {
+	buf := make([]byte, 20)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+	private := new(big.Int).SetBytes(buf)
+	public := new(big.Int).Exp(generator, private, prime)
+	return private, public.FillBytes(make([]byte, keyLength)), nil
+}
+
+// sharedSecret returns the Diffie-Hellman secret from the remote public key
+func sharedSecret(private *big.Int, remote []byte) []byte {
+	secret := new(big.Int).Exp(new(big.Int).SetBytes(remote), private, prime)
+	return secret.FillBytes(make([]byte, keyLength))
+}
+
+// newCipher returns the RC4 cipher for a side with the first bytes discarded
+func newCipher(side string, secret, infoHash []byte) (*rc4.Cipher, error) {
+	cipher, err := rc4.NewCipher(hash([]byte(side), secret, infoHash))
+	if err != nil {
+		return nil, err
+	}
+	discard := make([]byte, discardLength)
+	cipher.XORKeyStream(discard, discard)
+	return cipher, nil
+}
+
+// randomPad returns a random padding with a random length
+func randomPad() ([]byte, error) {
+	var length [2]byte
+	_, err := rand.Read(length[:])
+	if err != nil {
+		return nil, err
+	}
+	pad := make([]byte, int(binary.BigEndian.Uint16(length[:]))%(maxPadLength+1))
+	_, err = rand.Read(pad)
+	return pad, err
+}
+
+// skipPad reads and decrypts a padding
+func skipPad(reader io.Reader, dec *rc4.Cipher, length int) error {
+	if length > maxPadLength {
+		return fmt.Errorf("invalid padding length %d", length)
+	}
+	pad := make([]byte, length)
+	_, err := io.ReadFull(reader, pad)
+	if err != nil {
+		return err
+	}
+	dec.XORKeyStream(pad, pad)
+	return nil
+}
+
+// syncTo reads until the pattern is found
+// The pattern must be found in the first max bytes
+func syncTo(reader *bufio.Reader, pattern []byte, max int) error {
+	window := make([]byte, 0, max)
+	for len(window) < max {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		window = append(window, b)
+		if bytes.HasSuffix(window, pattern) {
+			return nil
+		}
+	}
+	return errors.New("encryption handshake not found")
+}
+
+// hash returns the sha1 of the parts
+func hash(parts ...[]byte) []byte {
+	h := sha1.New()
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// xor returns the xor between two slices with the same length
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
@@ -0,0 +1,103 @@
+package mse_test
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/mse"
+)
+
+// TestHandshake tests the encryption handshake between two endpoints
+func TestHandshake(t *testing.T) {
+	infoHash := [20]byte{1, 2, 3}
+
+	testCases := []struct {
+		name        string
+		provide     uint32
+		allowed     uint32
+		known       [][20]byte
+		method      uint32
+		errContains string
+	}{
+		{
+			name:    "rc4 is preferred",
+			provide: mse.CryptoRC4 | mse.CryptoPlaintext,
+			allowed: mse.CryptoRC4 | mse.CryptoPlaintext,
+			known:   [][20]byte{{9}, infoHash},
+			method:  mse.CryptoRC4,
+		},
+		{
+			name:    "plaintext",
+			provide: mse.CryptoPlaintext,
+			allowed: mse.CryptoRC4 | mse.CryptoPlaintext,
+			known:   [][20]byte{infoHash},
+			method:  mse.CryptoPlaintext,
+		},
+		{
+			name:        "encryption required",
+			provide:     mse.CryptoPlaintext,
+			allowed:     mse.CryptoRC4,
+			known:       [][20]byte{infoHash},
+			errContains: "no common crypto method",
+		},
+		{
+			name:        "unknown info hash",
+			provide:     mse.CryptoRC4,
+			allowed:     mse.CryptoRC4,
+			known:       [][20]byte{{9}},
+			errContains: "unknown info hash",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a, b := net.Pipe()
+			defer a.Close()
+			defer b.Close()
+
+			type result struct {
+				conn     *mse.Conn
+				infoHash [20]byte
+				err      error
+			}
+			accepted := make(chan result, 1)
+			go func() {
+				conn, infoHash, err := mse.Accept(b, tc.known, tc.allowed)
+				if err != nil {
+					b.Close()
+				}
+				accepted <- result{conn: conn, infoHash: infoHash, err: err}
+			}()
+
+			initiated, err := mse.Initiate(a, infoHash, tc.provide)
+			res := <-accepted
+			if tc.errContains != "" {
+				require.ErrorContains(t, res.err, tc.errContains)
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, res.err)
+			require.Equal(t, infoHash, res.infoHash)
+			require.Equal(t, tc.method, initiated.Method)
+			require.Equal(t, tc.method, res.conn.Method)
+
+			// The payload goes both ways
+			exchange(t, initiated, res.conn, []byte("from the initiator"))
+			exchange(t, res.conn, initiated, []byte("from the receiver"))
+		})
+	}
+}
+
+// exchange writes the data on a side and reads it on the other
+func exchange(t *testing.T, from, to net.Conn, data []byte) {
+	go from.Write(data) //nolint:errcheck
+
+	buf := make([]byte, len(data))
+	_, err := io.ReadFull(to, buf)
+	require.NoError(t, err)
+	require.Equal(t, data, buf)
+}
@@ -0,0 +1,36 @@
+package mse
+
+import "fmt"
+
+// Policy is how the encryption is used on the peer connections
+type Policy string
+
+// Known policies
+const (
+	// PolicyDisabled only uses plaintext connections
+	PolicyDisabled Policy = "disabled"
+	// PolicyPrefer tries encryption first and falls back to plaintext
+	PolicyPrefer Policy = "prefer"
+	// PolicyRequire refuses any plaintext connection
+	PolicyRequire Policy = "require"
+)
+
+// ParsePolicy parses a policy name
+func ParsePolicy(name string) (Policy, error) {
+	switch policy := Policy(name); policy {
+	case PolicyDisabled, PolicyPrefer, PolicyRequire:
+		return policy, nil
+	}
+	return "", fmt.Errorf("invalid encryption policy %q", name)
+}
+
+// Methods returns the crypto methods accepted by the policy
+func (p Policy) Methods() uint32 {
+	switch p {
+	case PolicyRequire:
+		return CryptoRC4
+	case PolicyDisabled:
+		return CryptoPlaintext
+	}
+	return CryptoRC4 | CryptoPlaintext
+}
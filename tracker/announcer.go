@@ -0,0 +1,213 @@
+package tracker
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+
+	"github.com/spf13/viper"
+)
+
+// Stats are the transfer stats reported to the trackers
+type Stats struct {
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+}
+
+// Announcer announces periodically to the trackers of a torrent
+// It sends the started, completed and stopped events and delivers
+// the peers from each announce
+type Announcer struct {
+	manager  *Manager
+	infoHash handshake.Hash
+	peerID   handshake.PeerID
+	port     uint16
+	ipv6     net.IP
+	stats    func() Stats
+
+	peers chan []peer.Peer
+	wake  chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+
+	mu            sync.Mutex
+	completed     bool
+	startedSent   bool
+	completedSent bool
+}
+
+// NewAnnouncer creates a new announcer
+// The stats function is called before each announce
+func NewAnnouncer(
+	manager *Manager,
+	infoHash handshake.Hash,
+	peerID handshake.PeerID,
+	port uint16,
+	stats func() Stats,
+) *Announcer {
+	return &Announcer{
+		manager:  manager,
+		infoHash: infoHash,
+		peerID:   peerID,
+		port:     port,
+		stats:    stats,
+		peers:    make(chan []peer.Peer),
+		wake:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// SetIPv6 sets our IPv6 address sent on the announces
+// It must be called before starting the announcer
+func (a *Announcer) SetIPv6(ip net.IP) {
+	a.ipv6 = ip
+}
+
+// Start starts announcing on the background
+func (a *Announcer) Start() {
+	go a.run()
+}
+
+// Peers returns the channel with the peers from each announce
+func (a *Announcer) Peers() <-chan []peer.Peer {
+	return a.peers
+}
+
+// Complete flags the download as completed
+// The completed event is announced right away
+func (a *Announcer) Complete() {
+	a.mu.Lock()
+	a.completed = true
+	a.mu.Unlock()
+
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop announces the stopped event and stops the announcer
+func (a *Announcer) Stop() {
+	close(a.stop)
+	<-a.done
+}
+
+// run is the announce loop
+func (a *Announcer) run() {
+	defer close(a.done)
+
+	// Viper configs
+	defaultInterval := viper.GetDuration("tracker.default_interval")
+	retryInterval := viper.GetDuration("tracker.retry_interval")
+
+	for {
+		interval := retryInterval
+		res, err := a.announce(a.nextEvent())
+		if err != nil {
+			log.Warn().Msgf("failed to announce, err: %s", err)
+		} else {
+			// Respect the intervals from the trackers
+			interval = res.Interval
+			if interval <= 0 {
+				interval = defaultInterval
+			}
+			if interval < res.MinInterval {
+				interval = res.MinInterval
+			}
+
+			// Deliver the peers unless we are stopping
+			select {
+			case a.peers <- res.Peers:
+			case <-a.stop:
+				a.shutdown()
+				return
+			}
+
+			// A pending completed event doesn't wait for the interval
+			if a.nextEvent() == EventCompleted {
+				continue
+			}
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-a.wake:
+			timer.Stop()
+		case <-a.stop:
+			timer.Stop()
+			a.shutdown()
+			return
+		}
+	}
+}
+
+// nextEvent returns the event for the next announce
+func (a *Announcer) nextEvent() Event {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.startedSent {
+		return EventStarted
+	}
+	if a.completed && !a.completedSent {
+		return EventCompleted
+	}
+	return EventNone
+}
+
+// shutdown sends the pending completed event and the stopped event
+// Nothing is sent if the trackers never received the started event
+func (a *Announcer) shutdown() {
+	a.mu.Lock()
+	startedSent := a.startedSent
+	pendingCompleted := a.completed && !a.completedSent
+	a.mu.Unlock()
+
+	if !startedSent {
+		return
+	}
+	if pendingCompleted {
+		if _, err := a.announce(EventCompleted); err != nil {
+			log.Warn().Msgf("failed to announce completed, err: %s", err)
+		}
+	}
+	if _, err := a.announce(EventStopped); err != nil {
+		log.Warn().Msgf("failed to announce stopped, err: %s", err)
+	}
+}
+
+// announce announces a event with the current stats
+func (a *Announcer) announce(event Event) (*AnnounceResponse, error) {
+	stats := a.stats()
+	res, err := a.manager.Announce(AnnounceRequest{
+		InfoHash:   a.infoHash,
+		PeerID:     a.peerID,
+		Port:       a.port,
+		Uploaded:   stats.Uploaded,
+		Downloaded: stats.Downloaded,
+		Left:       stats.Left,
+		Event:      event,
+		IPv6:       a.ipv6,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Track the events that reached the trackers
+	a.mu.Lock()
+	switch event {
+	case EventStarted:
+		a.startedSent = true
+	case EventCompleted:
+		a.completedSent = true
+	}
+	a.mu.Unlock()
+
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package tracker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/tracker"
+)
+
+// TestAnnouncer tests the announce events and the reported stats
+func TestAnnouncer(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+	viper.Set("tracker.default_interval", "1h")
+	viper.Set("tracker.retry_interval", "1h")
+
+	var mu sync.Mutex
+	events := []string{}
+	lefts := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		events = append(events, r.URL.Query().Get("event"))
+		lefts = append(lefts, r.URL.Query().Get("left"))
+		mu.Unlock()
+		_, _ = w.Write([]byte("d8:intervali3600e5:peers6:\x7f\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer server.Close()
+
+	left := int64(10)
+	var statsMu sync.Mutex
+	announcer := tracker.NewAnnouncer(
+		tracker.NewManager([][]string{{server.URL}}),
+		handshake.Hash{},
+		handshake.PeerID{},
+		6881,
+		func() tracker.Stats {
+			statsMu.Lock()
+			defer statsMu.Unlock()
+			return tracker.Stats{Downloaded: 10 - left, Left: left}
+		},
+	)
+	announcer.Start()
+
+	// The started announce delivers the peers
+	peers := <-announcer.Peers()
+	require.Len(t, peers, 1)
+
+	statsMu.Lock()
+	left = 0
+	statsMu.Unlock()
+	announcer.Complete()
+
+	// The completed announce also delivers the peers
+	<-announcer.Peers()
+	announcer.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"started", "completed", "stopped"}, events)
+	require.Equal(t, []string{"10", "0", "0"}, lefts)
+}
@@ -0,0 +1,20 @@
+package tracker
+
+import "time"
+
+// This file exposes the internals of the package to the external tests
+
+// CacheConnectionID caches a connection id for a tracker address
+func CacheConnectionID(addr string, id uint64, expires time.Time) {
+	connectionIDsMutex.Lock()
+	defer connectionIDsMutex.Unlock()
+	connectionIDs[addr] = connectionID{id: id, expires: expires}
+}
+
+// HasConnectionID returns if a connection id is cached for a tracker address
+func HasConnectionID(addr string) bool {
+	connectionIDsMutex.Lock()
+	defer connectionIDsMutex.Unlock()
+	_, ok := connectionIDs[addr]
+	return ok
+}
@@ -0,0 +1,149 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/bencode"
+	bencoderesponse "github.com/jhelison/go-torrent/marshallers/bencode_response"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+
+	"github.com/spf13/viper"
+)
+
+// AnnounceHTTP announces to a HTTP tracker
+// More information can be found on https://wiki.theory.org/BitTorrent_Tracker_Protocol
+func AnnounceHTTP(announceURL string, req AnnounceRequest) (*AnnounceResponse, error) {
+	// Viper config
+	timeout := viper.GetDuration("tracker.http_timeout")
+
+	torrentFile := bencode.TorrentFile{
+		Announce: announceURL,
+		InfoHash: req.InfoHash,
+	}
+	url, err := torrentFile.BuildTrackerURL(req.PeerID, req.Port, bencode.AnnounceStats{
+		Uploaded:   req.Uploaded,
+		Downloaded: req.Downloaded,
+		Left:       req.Left,
+		Event:      req.Event.String(),
+		IPv6:       ipString(req.IPv6),
+		TrackerID:  req.TrackerID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Get the response and unmarshal into a bencode response
+	httpClient := http.Client{Timeout: timeout}
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http tracker returned status %s", resp.Status)
+	}
+
+	res, err := bencoderesponse.Unmarshal(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.WarningMessage != "" {
+		log.Warn().Msgf("tracker %s returned a warning: %s", announceURL, res.WarningMessage)
+	}
+
+	// The IPv6 peers are on a separated key
+	peers := append([]peer.Peer{}, res.Peers...)
+	peers = append(peers, res.Peers6...)
+
+	return &AnnounceResponse{
+		Interval:    time.Duration(res.Interval) * time.Second,
+		MinInterval: time.Duration(res.MinInterval) * time.Second,
+		TrackerID:   res.TrackerID,
+		Seeders:     res.Complete,
+		Leechers:    res.Incomplete,
+		Peers:       peers,
+	}, nil
+}
+
+// ipString returns the IP as a string, or empty if not set
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// ScrapeHTTP requests the swarm information for a list of info hashes
+// The results are returned in the same order as the info hashes
+// Info hashes unknown to the tracker have zeroed results
+func ScrapeHTTP(announceURL string, infoHashes []handshake.Hash) ([]ScrapeResult, error) {
+	// Viper config
+	timeout := viper.GetDuration("tracker.http_timeout")
+
+	scrapeURL, err := ScrapeURL(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(scrapeURL)
+	if err != nil {
+		return nil, err
+	}
+	params := u.Query()
+	for _, infoHash := range infoHashes {
+		params.Add("info_hash", string(infoHash[:]))
+	}
+	u.RawQuery = params.Encode()
+
+	httpClient := http.Client{Timeout: timeout}
+	resp, err := httpClient.Get(u.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("http tracker returned status %s", resp.Status)
+	}
+
+	res, err := bencoderesponse.UnmarshalScrape(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ScrapeResult, len(infoHashes))
+	for i, infoHash := range infoHashes {
+		file := res.Files[string(infoHash[:])]
+		results[i] = ScrapeResult{
+			InfoHash:  infoHash,
+			Seeders:   file.Complete,
+			Leechers:  file.Incomplete,
+			Completed: file.Downloaded,
+		}
+	}
+	return results, nil
+}
+
+// ScrapeURL derives the scrape URL from a HTTP announce URL
+// The last path component must start with announce, which is replaced by scrape
+// More information can be found on https://wiki.theory.org/BitTorrent_Tracker_Protocol
+func ScrapeURL(announceURL string) (string, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return "", err
+	}
+
+	slash := strings.LastIndex(u.Path, "/")
+	last := u.Path[slash+1:]
+	if !strings.HasPrefix(last, "announce") {
+		return "", fmt.Errorf("tracker %s doesn't support scrape", announceURL)
+	}
+	u.Path = u.Path[:slash+1] + "scrape" + strings.TrimPrefix(last, "announce")
+	u.RawPath = ""
+	return u.String(), nil
+}
@@ -0,0 +1,125 @@
+package tracker_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	bencoderesponse "github.com/jhelison/go-torrent/marshallers/bencode_response"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/tracker"
+)
+
+// TestAnnounceHTTP tests the HTTP announce with IPv4 and IPv6 peers
+func TestAnnounceHTTP(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+
+	var ipv6 string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ipv6 = r.URL.Query().Get("ipv6")
+		_, _ = w.Write([]byte("d8:intervali900e5:peers6:\x7f\x00\x00\x01\x1a\xe1" +
+			"6:peers618:\x20\x01\x0d\xb8\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01\x1a\xe1e"))
+	}))
+	defer server.Close()
+
+	res, err := tracker.AnnounceHTTP(server.URL+"/announce", tracker.AnnounceRequest{
+		Port: 6881,
+		IPv6: net.ParseIP("2001:db8::2"),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "2001:db8::2", ipv6)
+
+	require.Len(t, res.Peers, 2)
+	require.Equal(t, "127.0.0.1:6881", res.Peers[0].String())
+	require.Equal(t, "[2001:db8::1]:6881", res.Peers[1].String())
+}
+
+// TestAnnounceHTTPFailure tests that the tracker failures are typed errors
+func TestAnnounceHTTPFailure(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("d14:failure reason22:torrent not registerede"))
+	}))
+	defer server.Close()
+
+	_, err := tracker.AnnounceHTTP(server.URL+"/announce", tracker.AnnounceRequest{Port: 6881})
+	var failure *bencoderesponse.FailureError
+	require.True(t, errors.As(err, &failure))
+	require.Equal(t, "torrent not registered", failure.Reason)
+}
+
+// TestScrapeURL tests the scrape URL derived from the announce URL
+func TestScrapeURL(t *testing.T) {
+	testCases := []struct {
+		name        string
+		announceURL string
+		expected    string
+		errContains string
+	}{
+		{
+			name:        "announce",
+			announceURL: "http://example.com/announce",
+			expected:    "http://example.com/scrape",
+		},
+		{
+			name:        "announce with suffix and query",
+			announceURL: "http://example.com/x/announce.php?passkey=abc",
+			expected:    "http://example.com/x/scrape.php?passkey=abc",
+		},
+		{
+			name:        "not supported",
+			announceURL: "http://example.com/a",
+			errContains: "doesn't support scrape",
+		},
+		{
+			name:        "announce not on the last component",
+			announceURL: "http://example.com/announce/x",
+			errContains: "doesn't support scrape",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			scrapeURL, err := tracker.ScrapeURL(tc.announceURL)
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+				require.Equal(t, tc.expected, scrapeURL)
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestScrapeHTTP tests the HTTP scrape against a local tracker
+func TestScrapeHTTP(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+
+	var path string
+	var infoHashes []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		infoHashes = r.URL.Query()["info_hash"]
+		_, _ = w.Write([]byte("d5:filesd20:\x01\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00" +
+			"d8:completei3e10:downloadedi5e10:incompletei4eeee"))
+	}))
+	defer server.Close()
+
+	results, err := tracker.ScrapeHTTP(server.URL+"/announce", []handshake.Hash{{1}, {2}})
+	require.NoError(t, err)
+	require.Equal(t, "/scrape", path)
+	require.Len(t, infoHashes, 2)
+
+	// The unknown info hash has zeroed results
+	require.Equal(t, []tracker.ScrapeResult{
+		{InfoHash: handshake.Hash{1}, Seeders: 3, Leechers: 4, Completed: 5},
+		{InfoHash: handshake.Hash{2}},
+	}, results)
+}
@@ -0,0 +1,181 @@
+package tracker
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+
+	"github.com/jhelison/go-torrent/marshallers/peer"
+
+	"github.com/spf13/viper"
+)
+
+// Manager handles the announces for the tiers of trackers of a torrent
+// More information can be found on https://www.bittorrent.org/beps/bep_0012.html
+type Manager struct {
+	mu    sync.Mutex
+	tiers [][]string
+	// trackerIDs are sent back to the trackers that returned them
+	trackerIDs map[string]string
+}
+
+// NewManager creates a new manager with the tiers of trackers
+// The trackers are shuffled inside each tier
+func NewManager(tiers [][]string) *Manager {
+	m := &Manager{trackerIDs: map[string]string{}}
+	for _, tier := range tiers {
+		shuffled := make([]string, 0, len(tier))
+		for _, tracker := range tier {
+			if tracker != "" {
+				shuffled = append(shuffled, tracker)
+			}
+		}
+		if len(shuffled) == 0 {
+			continue
+		}
+
+		rand.Shuffle(len(shuffled), func(i, j int) {
+			shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+		})
+		m.tiers = append(m.tiers, shuffled)
+	}
+	return m
+}
+
+// Tiers returns a copy of the current tiers with their order
+func (m *Manager) Tiers() [][]string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tiers := make([][]string, len(m.tiers))
+	for i, tier := range m.tiers {
+		tiers[i] = append([]string{}, tier...)
+	}
+	return tiers
+}
+
+// Announce announces to the tiers in order, stopping at the first tier that answers
+// Inside a tier the trackers are tried in order until one answers
+// With tracker.announce_all_tiers every tier is announced and the responses are merged
+// An error is only returned if all the tiers fail
+func (m *Manager) Announce(req AnnounceRequest) (*AnnounceResponse, error) {
+	// Viper config
+	allTiers := viper.GetBool("tracker.announce_all_tiers")
+
+	m.mu.Lock()
+	nTiers := len(m.tiers)
+	m.mu.Unlock()
+	if nTiers == 0 {
+		return nil, errors.New("no trackers to announce")
+	}
+	if allTiers {
+		return m.announceAllTiers(nTiers, req)
+	}
+
+	for i := 0; i < nTiers; i++ {
+		res := m.announceTier(i, req)
+		if res != nil {
+			return res, nil
+		}
+	}
+	return nil, errors.New("all trackers failed to announce")
+}
+
+// announceAllTiers announces to a tracker from each tier and merges the responses
+// The merged response has the smallest interval and the biggest min interval
+func (m *Manager) announceAllTiers(nTiers int, req AnnounceRequest) (*AnnounceResponse, error) {
+	// Each tier is announced at the same time
+	responses := make([]*AnnounceResponse, nTiers)
+	var wg sync.WaitGroup
+	for i := 0; i < nTiers; i++ {
+		wg.Add(1)
+		go func(tierIndex int) {
+			defer wg.Done()
+			responses[tierIndex] = m.announceTier(tierIndex, req)
+		}(i)
+	}
+	wg.Wait()
+
+	// Merge the responses from all the tiers
+	var merged *AnnounceResponse
+	seen := map[string]bool{}
+	for _, res := range responses {
+		if res == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &AnnounceResponse{
+				Interval:    res.Interval,
+				MinInterval: res.MinInterval,
+				Peers:       []peer.Peer{},
+			}
+		}
+		if res.Interval > 0 && (merged.Interval == 0 || res.Interval < merged.Interval) {
+			merged.Interval = res.Interval
+		}
+		if res.MinInterval > merged.MinInterval {
+			merged.MinInterval = res.MinInterval
+		}
+		merged.Seeders += res.Seeders
+		merged.Leechers += res.Leechers
+
+		for _, p := range res.Peers {
+			if seen[p.String()] {
+				continue
+			}
+			seen[p.String()] = true
+			merged.Peers = append(merged.Peers, p)
+		}
+	}
+	if merged == nil {
+		return nil, errors.New("all trackers failed to announce")
+	}
+
+	return merged, nil
+}
+
+// announceTier tries each tracker of a tier in order
+// The first tracker that answers is moved to the front of the tier
+func (m *Manager) announceTier(tierIndex int, req AnnounceRequest) *AnnounceResponse {
+	m.mu.Lock()
+	tier := append([]string{}, m.tiers[tierIndex]...)
+	m.mu.Unlock()
+
+	for _, tracker := range tier {
+		m.mu.Lock()
+		req.TrackerID = m.trackerIDs[tracker]
+		m.mu.Unlock()
+
+		res, err := Announce(tracker, req)
+		if err != nil {
+			log.Warn().Msgf("failed to announce to tracker %s, err: %s", tracker, err)
+			continue
+		}
+
+		log.Info().Msgf("Tracker %s returned %d peers", tracker, len(res.Peers))
+		if res.TrackerID != "" {
+			m.mu.Lock()
+			m.trackerIDs[tracker] = res.TrackerID
+			m.mu.Unlock()
+		}
+		m.promote(tierIndex, tracker)
+		return res
+	}
+
+	return nil
+}
+
+// promote moves a tracker to the front of its tier
+func (m *Manager) promote(tierIndex int, tracker string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tier := m.tiers[tierIndex]
+	for i, t := range tier {
+		if t == tracker {
+			copy(tier[1:i+1], tier[:i])
+			tier[0] = tracker
+			return
+		}
+	}
+}
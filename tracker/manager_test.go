@@ -0,0 +1,104 @@
+package tracker_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/tracker"
+)
+
+// fakeHTTPTracker is a HTTP tracker that always returns the same compact peers
+func fakeHTTPTracker(t *testing.T, peers string) string {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("d8:intervali900e5:peers" + peers + "e"))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL + "/announce"
+}
+
+// TestManagerAnnounce tests the announce to all the tiers, merge and promotion
+func TestManagerAnnounce(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+	viper.Set("tracker.announce_all_tiers", true)
+	defer viper.Set("tracker.announce_all_tiers", false)
+
+	dead := "http://127.0.0.1:1/announce"
+	first := fakeHTTPTracker(t, "6:\x7f\x00\x00\x01\x1a\xe1")
+	second := fakeHTTPTracker(t, "12:\x7f\x00\x00\x01\x1a\xe1\x7f\x00\x00\x02\x1a\xe1")
+
+	manager := tracker.NewManager([][]string{{dead, first}, {second}, {dead}})
+	res, err := manager.Announce(tracker.AnnounceRequest{Port: 6881})
+	require.NoError(t, err)
+	require.EqualValues(t, 900, res.Interval.Seconds())
+
+	// The duplicated peer is merged
+	require.Len(t, res.Peers, 2)
+	require.Equal(t, "127.0.0.1:6881", res.Peers[0].String())
+	require.Equal(t, "127.0.0.2:6881", res.Peers[1].String())
+
+	// The tracker that answered is promoted
+	require.Equal(t, [][]string{{first, dead}, {second}, {dead}}, manager.Tiers())
+
+	// Without any working tracker the announce fails
+	_, err = tracker.NewManager([][]string{{dead}}).Announce(tracker.AnnounceRequest{})
+	require.ErrorContains(t, err, "all trackers failed")
+}
+
+// TestManagerTierOrder tests that the tiers are announced in order until one answers
+func TestManagerTierOrder(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+	viper.Set("tracker.announce_all_tiers", false)
+
+	var mu sync.Mutex
+	announced := []string{}
+	recordingTracker := func(name, response string) string {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			announced = append(announced, name)
+			mu.Unlock()
+			_, _ = w.Write([]byte(response))
+		}))
+		t.Cleanup(server.Close)
+		return server.URL + "/announce"
+	}
+	failing := recordingTracker("failing", "d14:failure reason4:teste")
+	first := recordingTracker("first", "d8:intervali900e5:peers6:\x7f\x00\x00\x01\x1a\xe1e")
+	second := recordingTracker("second", "d8:intervali900e5:peers6:\x7f\x00\x00\x02\x1a\xe1e")
+
+	manager := tracker.NewManager([][]string{{failing}, {first}, {second}})
+	res, err := manager.Announce(tracker.AnnounceRequest{Port: 6881})
+	require.NoError(t, err)
+
+	// The last tier is never announced
+	require.Len(t, res.Peers, 1)
+	require.Equal(t, "127.0.0.1:6881", res.Peers[0].String())
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, []string{"failing", "first"}, announced)
+}
+
+// TestManagerTrackerID tests that the tracker id is sent back to the tracker
+func TestManagerTrackerID(t *testing.T) {
+	viper.Set("tracker.http_timeout", "1s")
+
+	var trackerIDs []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trackerIDs = append(trackerIDs, r.URL.Query().Get("trackerid"))
+		_, _ = w.Write([]byte("d8:completei2e10:incompletei1e8:intervali900e5:peers0:10:tracker id3:abce"))
+	}))
+	defer server.Close()
+
+	manager := tracker.NewManager([][]string{{server.URL + "/announce"}})
+	for i := 0; i < 2; i++ {
+		res, err := manager.Announce(tracker.AnnounceRequest{Port: 6881})
+		require.NoError(t, err)
+		require.Equal(t, 2, res.Seeders)
+		require.Equal(t, 1, res.Leechers)
+	}
+	require.Equal(t, []string{"", "abc"}, trackerIDs)
+}
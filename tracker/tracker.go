@@ -0,0 +1,112 @@
+package tracker
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/jhelison/go-torrent/logger"
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+)
+
+var (
+	// Default logger
+	log = logger.GetLogger()
+)
+
+// Event is the event sent on a announce
+type Event int
+
+// Types of announce events
+// The values follow the UDP tracker protocol
+const (
+	EventNone      Event = 0
+	EventCompleted Event = 1
+	EventStarted   Event = 2
+	EventStopped   Event = 3
+)
+
+// String returns the event name used on HTTP announces
+func (e Event) String() string {
+	switch e {
+	case EventCompleted:
+		return "completed"
+	case EventStarted:
+		return "started"
+	case EventStopped:
+		return "stopped"
+	default:
+		return ""
+	}
+}
+
+// AnnounceRequest is the information sent to a tracker on a announce
+// The IPv6 is our address sent on HTTP announces, as defined on BEP 7
+// The tracker ID is the one received on the previous HTTP announce
+type AnnounceRequest struct {
+	InfoHash   handshake.Hash
+	PeerID     handshake.PeerID
+	Port       uint16
+	Uploaded   int64
+	Downloaded int64
+	Left       int64
+	Event      Event
+	IPv6       net.IP
+	TrackerID  string
+}
+
+// AnnounceResponse is the information received from a tracker on a announce
+// The min interval is zero if not sent by the tracker
+type AnnounceResponse struct {
+	Interval    time.Duration
+	MinInterval time.Duration
+	TrackerID   string
+	Seeders     int
+	Leechers    int
+	Peers       []peer.Peer
+}
+
+// ScrapeResult is the swarm information for a single info hash
+type ScrapeResult struct {
+	InfoHash  handshake.Hash
+	Seeders   int
+	Leechers  int
+	Completed int
+}
+
+// Announce announces to a tracker using the protocol from the URL scheme
+func Announce(announceURL string, req AnnounceRequest) (*AnnounceResponse, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return AnnounceUDP(announceURL, req)
+	case "http", "https":
+		return AnnounceHTTP(announceURL, req)
+	default:
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+}
+
+// Scrape requests the swarm information using the protocol from the URL scheme
+// The results are returned in the same order as the info hashes
+func Scrape(announceURL string, infoHashes []handshake.Hash) ([]ScrapeResult, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "udp":
+		return ScrapeUDP(announceURL, infoHashes)
+	case "http", "https":
+		return ScrapeHTTP(announceURL, infoHashes)
+	default:
+		return nil, fmt.Errorf("unsupported tracker scheme %q", u.Scheme)
+	}
+}
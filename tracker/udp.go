@@ -0,0 +1,308 @@
+package tracker
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	"github.com/jhelison/go-torrent/marshallers/peer"
+	udptracker "github.com/jhelison/go-torrent/marshallers/udp_tracker"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	// connectionIDLifetime is how long a connection id can be reused
+	connectionIDLifetime = time.Minute
+	// maxScrapeHashes is the max number of info hashes on a single scrape
+	maxScrapeHashes = 74
+	// maxPacketSize is the biggest UDP packet we read
+	maxPacketSize = 65507
+)
+
+// connectionID is a connection id received from a tracker
+type connectionID struct {
+	id      uint64
+	expires time.Time
+}
+
+var (
+	// connectionIDs caches the connection ids by tracker address
+	connectionIDs      = map[string]connectionID{}
+	connectionIDsMutex sync.Mutex
+
+	// announceKey identifies this client on the trackers between announces
+	announceKey = randomUint32()
+
+	// errTrackerResponse is returned for the error responses of the trackers
+	errTrackerResponse = errors.New("udp tracker error")
+)
+
+// AnnounceUDP announces to a UDP tracker
+// More information can be found on https://www.bittorrent.org/beps/bep_0015.html
+func AnnounceUDP(announceURL string, req AnnounceRequest) (*AnnounceResponse, error) {
+	conn, err := dialUDP(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	transactionID := randomUint32()
+	buf, err := roundTrip(conn, transactionID, func(connID uint64) []byte {
+		return udptracker.AnnounceRequest{
+			ConnectionID:  connID,
+			TransactionID: transactionID,
+			InfoHash:      req.InfoHash,
+			PeerID:        req.PeerID,
+			Downloaded:    uint64(req.Downloaded),
+			Left:          uint64(req.Left),
+			Uploaded:      uint64(req.Uploaded),
+			Event:         uint32(req.Event),
+			Key:           announceKey,
+			NumWant:       -1,
+			Port:          req.Port,
+		}.Marshal()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := udptracker.UnmarshalAnnounce(buf)
+	if err != nil {
+		return nil, err
+	}
+	// Trackers reached over IPv6 answer with IPv6 peers
+	unmarshal := peer.Unmarshal
+	if conn.RemoteAddr().(*net.UDPAddr).IP.To4() == nil {
+		unmarshal = peer.Unmarshal6
+	}
+	peers, err := unmarshal(res.Peers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AnnounceResponse{
+		Interval: time.Duration(res.Interval) * time.Second,
+		Seeders:  int(res.Seeders),
+		Leechers: int(res.Leechers),
+		Peers:    peers,
+	}, nil
+}
+
+// ScrapeUDP requests the swarm information for a list of info hashes
+// The results are returned in the same order as the info hashes
+func ScrapeUDP(announceURL string, infoHashes []handshake.Hash) ([]ScrapeResult, error) {
+	conn, err := dialUDP(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	results := make([]ScrapeResult, 0, len(infoHashes))
+	// The tracker accepts a limited number of hashes per request
+	for begin := 0; begin < len(infoHashes); begin += maxScrapeHashes {
+		end := begin + maxScrapeHashes
+		if end > len(infoHashes) {
+			end = len(infoHashes)
+		}
+		hashes := infoHashes[begin:end]
+
+		transactionID := randomUint32()
+		buf, err := roundTrip(conn, transactionID, func(connID uint64) []byte {
+			return udptracker.ScrapeRequest{
+				ConnectionID:  connID,
+				TransactionID: transactionID,
+				InfoHashes:    hashes,
+			}.Marshal()
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := udptracker.UnmarshalScrape(buf)
+		if err != nil {
+			return nil, err
+		}
+		if len(res.Infos) != len(hashes) {
+			return nil, fmt.Errorf("expected %d scrape results but got %d", len(hashes), len(res.Infos))
+		}
+
+		for i, info := range res.Infos {
+			results = append(results, ScrapeResult{
+				InfoHash:  hashes[i],
+				Seeders:   int(info.Seeders),
+				Leechers:  int(info.Leechers),
+				Completed: int(info.Completed),
+			})
+		}
+	}
+
+	return results, nil
+}
+
+// dialUDP opens a UDP connection with the tracker from the announce URL
+func dialUDP(announceURL string) (*net.UDPConn, error) {
+	u, err := url.Parse(announceURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "udp" {
+		return nil, fmt.Errorf("invalid udp tracker scheme %q", u.Scheme)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialUDP("udp", nil, addr)
+}
+
+// roundTrip sends a packet built with a valid connection id and
+// returns the response with the same transaction id
+// The timeout for the attempt n is the base timeout * 2^n, the connection id
+// is only requested again when it expires, sharing the same attempts
+func roundTrip(conn *net.UDPConn, transactionID uint32, build func(connID uint64) []byte) ([]byte, error) {
+	// Viper configs
+	timeout := viper.GetDuration("tracker.udp_timeout")
+	maxRetries := viper.GetInt("tracker.udp_max_retries")
+
+	for n := 0; n <= maxRetries; n++ {
+		connID, ok := cachedConnectionID(conn)
+		if !ok {
+			var err error
+			connID, err = connect(conn, timeout<<n)
+			if isTimeout(err) {
+				log.Debug().Msgf("udp tracker %s connect timed out, attempt %d", conn.RemoteAddr(), n)
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		res, err := send(conn, transactionID, build(connID), timeout<<n)
+		if isTimeout(err) {
+			log.Debug().Msgf("udp tracker %s timed out, attempt %d", conn.RemoteAddr(), n)
+			continue
+		}
+		if errors.Is(err, errTrackerResponse) {
+			// The connection id may be the reason of the error
+			evictConnectionID(conn)
+		}
+		return res, err
+	}
+
+	return nil, fmt.Errorf("udp tracker %s timed out", conn.RemoteAddr())
+}
+
+// cachedConnectionID returns the connection id for the tracker if it didn't expire
+func cachedConnectionID(conn *net.UDPConn) (uint64, bool) {
+	connectionIDsMutex.Lock()
+	defer connectionIDsMutex.Unlock()
+
+	cached, ok := connectionIDs[conn.RemoteAddr().String()]
+	if !ok || !time.Now().Before(cached.expires) {
+		return 0, false
+	}
+	return cached.id, true
+}
+
+// connect requests a new connection id to the tracker and caches it
+func connect(conn *net.UDPConn, timeout time.Duration) (uint64, error) {
+	transactionID := randomUint32()
+	buf, err := send(conn, transactionID, udptracker.ConnectRequest{TransactionID: transactionID}.Marshal(), timeout)
+	if err != nil {
+		return 0, err
+	}
+	res, err := udptracker.UnmarshalConnect(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	connectionIDsMutex.Lock()
+	defer connectionIDsMutex.Unlock()
+
+	// The expired ids of all the trackers are dropped, so the cache doesn't grow
+	now := time.Now()
+	for addr, cached := range connectionIDs {
+		if !now.Before(cached.expires) {
+			delete(connectionIDs, addr)
+		}
+	}
+	connectionIDs[conn.RemoteAddr().String()] = connectionID{
+		id:      res.ConnectionID,
+		expires: now.Add(connectionIDLifetime),
+	}
+
+	return res.ConnectionID, nil
+}
+
+// evictConnectionID drops the cached connection id of the tracker
+func evictConnectionID(conn *net.UDPConn) {
+	connectionIDsMutex.Lock()
+	defer connectionIDsMutex.Unlock()
+	delete(connectionIDs, conn.RemoteAddr().String())
+}
+
+// send writes a packet and waits for the response until the timeout
+func send(conn *net.UDPConn, transactionID uint32, packet []byte, timeout time.Duration) ([]byte, error) {
+	_, err := conn.Write(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetReadDeadline(time.Now().Add(timeout))
+	if err != nil {
+		return nil, err
+	}
+
+	return readResponse(conn, transactionID)
+}
+
+// isTimeout returns if the error is a network timeout
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// readResponse reads packets until one matches the transaction id
+// Error responses are returned as errors
+func readResponse(conn *net.UDPConn, transactionID uint32) ([]byte, error) {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		action, resTransactionID, err := udptracker.UnmarshalHeader(buf[:n])
+		if err != nil || resTransactionID != transactionID {
+			// Packets from other requests are ignored
+			continue
+		}
+
+		if action == udptracker.ActionError {
+			message, err := udptracker.UnmarshalError(buf[:n])
+			if err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("%w: %s", errTrackerResponse, message)
+		}
+
+		return buf[:n], nil
+	}
+}
+
+// randomUint32 generates a random uint32 for transaction ids and keys
+func randomUint32() uint32 {
+	var buf [4]byte
+	// crypto/rand doesn't fail on supported platforms
+	_, _ = rand.Read(buf[:])
+	return binary.BigEndian.Uint32(buf[:])
+}
@@ -0,0 +1,218 @@
+package tracker_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/marshallers/handshake"
+	udptracker "github.com/jhelison/go-torrent/marshallers/udp_tracker"
+	"github.com/jhelison/go-torrent/tracker"
+)
+
+// fakeUDPTracker is a minimal UDP tracker used on the tests
+// The first announce is always dropped to force a retransmission
+func fakeUDPTracker(t *testing.T, failure string) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		dropped := false
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packet := buf[:n]
+			action := udptracker.Action(binary.BigEndian.Uint32(packet[8:12]))
+			transactionID := packet[12:16]
+
+			res := make([]byte, 8)
+			binary.BigEndian.PutUint32(res[4:8], binary.BigEndian.Uint32(transactionID))
+			switch {
+			case failure != "":
+				binary.BigEndian.PutUint32(res[0:4], uint32(udptracker.ActionError))
+				res = append(res, failure...)
+			case action == udptracker.ActionConnect:
+				res = append(res, 0, 0, 0, 0, 0, 0, 0, 42)
+			case action == udptracker.ActionAnnounce:
+				if !dropped {
+					dropped = true
+					continue
+				}
+				// A response with a wrong transaction id must be ignored
+				wrong := []byte{0, 0, 0, 1, 0, 0, 0, 0}
+				_, _ = conn.WriteTo(wrong, addr)
+
+				binary.BigEndian.PutUint32(res[0:4], uint32(udptracker.ActionAnnounce))
+				res = append(res,
+					0, 0, 0x07, 0x08, // interval
+					0, 0, 0, 1, // leechers
+					0, 0, 0, 2, // seeders
+					127, 0, 0, 1, 0x1A, 0xE1, // peer
+				)
+			case action == udptracker.ActionScrape:
+				binary.BigEndian.PutUint32(res[0:4], uint32(udptracker.ActionScrape))
+				for i := 16; i < n; i += 20 {
+					res = append(res, 0, 0, 0, 3, 0, 0, 0, 4, 0, 0, 0, 5)
+				}
+			}
+			_, _ = conn.WriteTo(res, addr)
+		}
+	}()
+
+	return fmt.Sprintf("udp://%s/announce", conn.LocalAddr())
+}
+
+// TestAnnounceUDP tests the UDP announce against a local tracker
+func TestAnnounceUDP(t *testing.T) {
+	viper.Set("tracker.udp_timeout", "50ms")
+	viper.Set("tracker.udp_max_retries", 2)
+
+	testCases := []struct {
+		name        string
+		failure     string
+		errContains string
+	}{
+		{
+			name: "pass",
+		},
+		{
+			name:        "failure",
+			failure:     "torrent not registered",
+			errContains: "torrent not registered",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			announceURL := fakeUDPTracker(t, tc.failure)
+
+			res, err := tracker.AnnounceUDP(announceURL, tracker.AnnounceRequest{
+				InfoHash: handshake.Hash{1},
+				Port:     6881,
+				Left:     10,
+			})
+
+			if tc.errContains == "" {
+				require.NoError(t, err)
+
+				require.EqualValues(t, 1800, res.Interval.Seconds())
+				require.Equal(t, 1, res.Leechers)
+				require.Equal(t, 2, res.Seeders)
+				require.Len(t, res.Peers, 1)
+				require.Equal(t, "127.0.0.1:6881", res.Peers[0].String())
+			} else {
+				require.ErrorContains(t, err, tc.errContains)
+			}
+		})
+	}
+}
+
+// TestScrapeUDP tests the UDP scrape against a local tracker
+func TestScrapeUDP(t *testing.T) {
+	viper.Set("tracker.udp_timeout", "50ms")
+	viper.Set("tracker.udp_max_retries", 2)
+
+	announceURL := fakeUDPTracker(t, "")
+	hashes := []handshake.Hash{{1}, {2}}
+
+	results, err := tracker.ScrapeUDP(announceURL, hashes)
+	require.NoError(t, err)
+	require.Equal(t, []tracker.ScrapeResult{
+		{InfoHash: hashes[0], Seeders: 3, Completed: 4, Leechers: 5},
+		{InfoHash: hashes[1], Seeders: 3, Completed: 4, Leechers: 5},
+	}, results)
+}
+
+// TestAnnounceUDPTimeout tests that a silent tracker is only retried up to the max retries
+func TestAnnounceUDPTimeout(t *testing.T) {
+	viper.Set("tracker.udp_timeout", "20ms")
+	viper.Set("tracker.udp_max_retries", 2)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	var packets atomic.Int32
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			_, _, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			packets.Add(1)
+		}
+	}()
+
+	_, err = tracker.AnnounceUDP(fmt.Sprintf("udp://%s/announce", conn.LocalAddr()), tracker.AnnounceRequest{Port: 6881})
+	require.ErrorContains(t, err, "timed out")
+	// A single connect per attempt, without nested retransmissions
+	require.EqualValues(t, 3, packets.Load())
+}
+
+// TestUDPConnectionIDCache tests that the rejected and expired connection ids are dropped
+func TestUDPConnectionIDCache(t *testing.T) {
+	viper.Set("tracker.udp_timeout", "50ms")
+	viper.Set("tracker.udp_max_retries", 2)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// The first announce is rejected, as if the connection id expired on the tracker
+	var connects atomic.Int32
+	go func() {
+		rejected := false
+		buf := make([]byte, 1500)
+		for {
+			_, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			action := udptracker.Action(binary.BigEndian.Uint32(buf[8:12]))
+
+			res := make([]byte, 8)
+			copy(res[4:8], buf[12:16])
+			switch {
+			case action == udptracker.ActionConnect:
+				connects.Add(1)
+				res = append(res, 0, 0, 0, 0, 0, 0, 0, 42)
+			case !rejected:
+				rejected = true
+				binary.BigEndian.PutUint32(res[0:4], uint32(udptracker.ActionError))
+				res = append(res, "connection id expired"...)
+			default:
+				binary.BigEndian.PutUint32(res[0:4], uint32(udptracker.ActionAnnounce))
+				res = append(res, make([]byte, 12)...)
+			}
+			_, _ = conn.WriteTo(res, addr)
+		}
+	}()
+
+	// The expired ids of other trackers are pruned
+	expired := "127.0.0.1:1"
+	tracker.CacheConnectionID(expired, 1, time.Now().Add(-time.Second))
+
+	addr := conn.LocalAddr().String()
+	announceURL := fmt.Sprintf("udp://%s/announce", addr)
+	_, err = tracker.AnnounceUDP(announceURL, tracker.AnnounceRequest{Port: 6881})
+	require.ErrorContains(t, err, "connection id expired")
+	require.False(t, tracker.HasConnectionID(addr))
+	require.False(t, tracker.HasConnectionID(expired))
+
+	// The next announce connects again
+	_, err = tracker.AnnounceUDP(announceURL, tracker.AnnounceRequest{Port: 6881})
+	require.NoError(t, err)
+	require.True(t, tracker.HasConnectionID(addr))
+	require.EqualValues(t, 2, connects.Load())
+}
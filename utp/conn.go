@@ -0,0 +1,747 @@
+package utp
+
+import (
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	utpmsg "github.com/jhelison/go-torrent/marshallers/utp"
+)
+
+const (
+	// packetSize is the biggest packet we send
+	packetSize = 1400
+	// maxPayload is the biggest payload of a data packet
+	maxPayload = packetSize - utpmsg.HeaderLength
+	// bufferSize is the size of the send and receive buffers
+	bufferSize = 1 << 20
+	// maxInflight is the max number of packets waiting for an ack
+	maxInflight = 1024
+	// maxReorder is the max distance of the out of order packets we keep
+	maxReorder = 1024
+	// maxSelectiveAck is the max number of bytes of a selective ack
+	maxSelectiveAck = 32
+	// duplicateAcks is the number of duplicate acks that triggers a retransmission
+	duplicateAcks = 3
+	// maxTimeouts is the number of timeouts in a row before the connection fails
+	maxTimeouts = 7
+	// maxSynTimeouts is the number of SYN timeouts before the dial fails
+	maxSynTimeouts = 3
+)
+
+var (
+	errReset    = errors.New("connection reset by peer")
+	errTimedOut = errors.New("connection timed out")
+)
+
+type connState uint8
+
+const (
+	stateSynSent connState = iota
+	stateConnected
+	stateClosed
+)
+
+// outPacket is a sent packet waiting for an ack
+type outPacket struct {
+	packetType    utpmsg.Type
+	seqNr         uint16
+	payload       []byte
+	sentAt        time.Time
+	transmissions int
+	acked         bool
+	needResend    bool
+	fastResent    bool
+}
+
+// Conn is a uTP connection
+// It implements net.Conn, the writes return once the data is on the send buffer
+type Conn struct {
+	socket *Socket
+	addr   net.Addr
+	recvID uint16
+	sendID uint16
+
+	mu sync.Mutex
+	// changed is closed and replaced on every state change to wake the waiters
+	changed chan struct{}
+	state   connState
+	err     error
+	closing bool
+	// incoming is set on the connections started by the remote
+	incoming bool
+
+	// Sending side
+	seqNr         uint16
+	initialSeqNr  uint16
+	sendBuf       []byte
+	inflight      []*outPacket
+	inflightBytes int
+	peerWindow    uint32
+	lastAckNr     uint16
+	dupAcks       int
+	finSent       bool
+	cc            *ledbat
+	rtoDeadline   time.Time
+	timeouts      int
+
+	// Receiving side
+	ackNr        uint16
+	readBuf      []byte
+	reorder      map[uint16][]byte
+	reorderBytes int
+	finReceived  bool
+	finSeqNr     uint16
+	eof          bool
+	replyMicro   uint32
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// newConn returns a connection with our and the remote ids
+func newConn(s *Socket, addr net.Addr, recvID, sendID uint16) *Conn {
+	return &Conn{
+		socket:     s,
+		addr:       addr,
+		recvID:     recvID,
+		sendID:     sendID,
+		changed:    make(chan struct{}),
+		peerWindow: bufferSize,
+		reorder:    map[uint16][]byte{},
+		cc:         newLedbat(),
+	}
+}
+
+// Read reads the data received in order
+// It returns io.EOF once the remote closed the connection
+func (c *Conn) Read(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for {
+		if c.closing {
+			return 0, net.ErrClosed
+		}
+		if deadlinePassed(c.readDeadline) {
+			return 0, os.ErrDeadlineExceeded
+		}
+		if len(c.readBuf) > 0 {
+			windowBefore := c.recvWindow()
+			n := copy(b, c.readBuf)
+			c.readBuf = c.readBuf[n:]
+			if len(c.readBuf) == 0 {
+				c.readBuf = nil
+			}
+			// The remote stops sending with a closed window, so it's told when it opens
+			if windowBefore < maxPayload && c.recvWindow() >= maxPayload {
+				c.sendState()
+			}
+			return n, nil
+		}
+		if c.eof {
+			return 0, io.EOF
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+
+		err := c.wait(c.readDeadline)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write queues the data on the send buffer
+// It blocks while the buffer is full
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	written := 0
+	for written < len(b) {
+		if c.closing {
+			return written, net.ErrClosed
+		}
+		if c.err != nil {
+			return written, c.err
+		}
+		if deadlinePassed(c.writeDeadline) {
+			return written, os.ErrDeadlineExceeded
+		}
+
+		space := bufferSize - len(c.sendBuf)
+		if space > 0 {
+			n := len(b) - written
+			if n > space {
+				n = space
+			}
+			c.sendBuf = append(c.sendBuf, b[written:written+n]...)
+			written += n
+			c.flush()
+			continue
+		}
+
+		err := c.wait(c.writeDeadline)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Close sends a FIN after the buffered data
+// The connection stays on the socket until the FIN is acked
+func (c *Conn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closing {
+		return net.ErrClosed
+	}
+	c.closing = true
+	c.readBuf = nil
+	c.broadcast()
+
+	if c.state != stateConnected {
+		return nil
+	}
+	c.flush()
+	c.finishIfDone()
+	return nil
+}
+
+// LocalAddr returns the address of the socket
+func (c *Conn) LocalAddr() net.Addr {
+	return c.socket.Addr()
+}
+
+// RemoteAddr returns the address of the remote
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline sets the read and write deadlines
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.writeDeadline = t
+	c.broadcast()
+	return nil
+}
+
+// SetReadDeadline sets the deadline of the reads
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	c.broadcast()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of the writes
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeDeadline = t
+	c.broadcast()
+	return nil
+}
+
+// connect sends the SYN of a outgoing connection
+func (c *Conn) connect() {
+	c.seqNr = 1
+	c.transmit(&outPacket{packetType: utpmsg.TypeSyn, seqNr: c.seqNr})
+	c.seqNr++
+}
+
+// handlePacket processes a packet from the remote
+func (c *Conn) handlePacket(h utpmsg.Header, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == stateClosed {
+		return
+	}
+	c.updateTimestamps(h)
+
+	switch h.Type {
+	case utpmsg.TypeReset:
+		c.failLocked(errReset)
+		return
+	case utpmsg.TypeSyn:
+		// Our answer to the SYN was lost
+		if c.incoming {
+			c.sendSynAck()
+		}
+		return
+	}
+
+	if c.state == stateSynSent {
+		if h.Type != utpmsg.TypeState {
+			return
+		}
+		// The first data from the remote has the sequence number of the state
+		c.ackNr = h.SeqNr - 1
+		c.state = stateConnected
+		c.broadcast()
+	}
+
+	c.processAck(h)
+	if h.Type == utpmsg.TypeData || h.Type == utpmsg.TypeFin {
+		c.receive(h, payload)
+	}
+	c.flush()
+	c.finishIfDone()
+}
+
+// updateTimestamps saves the delay measured on a packet and the remote window
+func (c *Conn) updateTimestamps(h utpmsg.Header) {
+	c.replyMicro = timestamp(time.Now()) - h.Timestamp
+	c.peerWindow = h.WindowSize
+}
+
+// processAck removes the acked packets and updates the congestion control
+func (c *Conn) processAck(h utpmsg.Header) {
+	now := time.Now()
+	ackedBytes := 0
+	progress := false
+	// The last sent packet of the ack gives the round trip
+	// Acks with retransmitted packets are ambiguous, they may have filled a gap
+	var lastSent time.Time
+	ambiguous := false
+	ack := func(p *outPacket) {
+		if p.acked {
+			return
+		}
+		if p.transmissions > 1 {
+			ambiguous = true
+		}
+		if p.sentAt.After(lastSent) {
+			lastSent = p.sentAt
+		}
+		ackedBytes += c.ackPacket(p)
+	}
+
+	// The ack is cumulative
+	if len(c.inflight) > 0 {
+		acked := int(h.AckNr-c.inflight[0].seqNr) + 1
+		if acked > 0 && acked <= len(c.inflight) {
+			for _, p := range c.inflight[:acked] {
+				ack(p)
+			}
+			c.inflight = c.inflight[acked:]
+			progress = true
+		}
+	}
+
+	// The selective ack tells the packets received after a gap
+	sacked := 0
+	for i := 0; i < len(h.SelectiveAck)*8; i++ {
+		if h.SelectiveAck[i/8]&(1<<(i%8)) == 0 || len(c.inflight) == 0 {
+			continue
+		}
+		index := int(h.AckNr + 2 + uint16(i) - c.inflight[0].seqNr)
+		if index < 0 || index >= len(c.inflight) {
+			continue
+		}
+		ack(c.inflight[index])
+		sacked++
+	}
+
+	switch {
+	case progress:
+		c.dupAcks = 0
+		c.timeouts = 0
+		c.cc.onProgress()
+	case h.Type == utpmsg.TypeState && h.AckNr == c.lastAckNr && len(c.inflight) > 0:
+		c.dupAcks++
+	}
+	c.lastAckNr = h.AckNr
+
+	if c.dupAcks >= duplicateAcks || sacked >= duplicateAcks {
+		c.fastRetransmit()
+	}
+
+	if !lastSent.IsZero() && !ambiguous {
+		c.cc.onRTT(now.Sub(lastSent))
+	}
+	if ackedBytes > 0 {
+		c.cc.onAck(ackedBytes, h.TimestampDiff, now)
+	}
+	if progress {
+		c.resetTimer(now)
+	}
+}
+
+// ackPacket marks a packet as acked
+// Returns the number of bytes newly acked
+func (c *Conn) ackPacket(p *outPacket) int {
+	if p.acked {
+		return 0
+	}
+	p.acked = true
+	if !p.needResend {
+		c.inflightBytes -= len(p.payload)
+	}
+	return len(p.payload)
+}
+
+// fastRetransmit resends the first packet not acked
+// The packet is taken as lost, so the window is halved
+func (c *Conn) fastRetransmit() {
+	for _, p := range c.inflight {
+		if p.acked {
+			continue
+		}
+		if !p.fastResent {
+			p.fastResent = true
+			c.cc.onLoss()
+			c.resend(p)
+		}
+		return
+	}
+}
+
+// receive adds the payload of a data or FIN packet to the read buffer
+// Out of order packets are kept until the gap is filled
+func (c *Conn) receive(h utpmsg.Header, payload []byte) {
+	distance := h.SeqNr - c.ackNr - 1
+	if distance >= maxReorder {
+		// Old packet, our ack was lost
+		c.sendState()
+		return
+	}
+	if len(payload) > c.recvWindow() {
+		// No space, the remote will send it again
+		return
+	}
+
+	if h.Type == utpmsg.TypeFin {
+		c.finReceived = true
+		c.finSeqNr = h.SeqNr
+	}
+
+	if distance > 0 {
+		if _, ok := c.reorder[h.SeqNr]; !ok {
+			c.reorder[h.SeqNr] = append([]byte{}, payload...)
+			c.reorderBytes += len(payload)
+		}
+		c.sendState()
+		return
+	}
+
+	// In order, the packets waiting for it are delivered too
+	c.deliver(payload)
+	c.ackNr = h.SeqNr
+	for {
+		next, ok := c.reorder[c.ackNr+1]
+		if !ok {
+			break
+		}
+		delete(c.reorder, c.ackNr+1)
+		c.reorderBytes -= len(next)
+		c.deliver(next)
+		c.ackNr++
+	}
+
+	if c.finReceived && c.ackNr == c.finSeqNr {
+		c.eof = true
+	}
+	c.sendState()
+	c.broadcast()
+}
+
+// deliver adds data to the read buffer
+// The data is dropped after the connection is closed
+func (c *Conn) deliver(data []byte) {
+	if c.closing {
+		return
+	}
+	c.readBuf = append(c.readBuf, data...)
+}
+
+// flush sends the packets allowed by the windows
+// The packets waiting a resend go before the new data
+func (c *Conn) flush() {
+	if c.state != stateConnected {
+		return
+	}
+
+	window := c.cc.size()
+	if int(c.peerWindow) < window {
+		window = int(c.peerWindow)
+	}
+	// A single packet is always allowed, so a closed window is probed
+	fits := func(size int) bool {
+		return c.inflightBytes == 0 || c.inflightBytes+size <= window
+	}
+
+	for _, p := range c.inflight {
+		if !p.needResend {
+			continue
+		}
+		if !fits(len(p.payload)) {
+			return
+		}
+		p.needResend = false
+		c.inflightBytes += len(p.payload)
+		c.resend(p)
+	}
+
+	sent := false
+	for len(c.sendBuf) > 0 && len(c.inflight) < maxInflight {
+		size := len(c.sendBuf)
+		if size > maxPayload {
+			size = maxPayload
+		}
+		if !fits(size) {
+			break
+		}
+
+		payload := append([]byte{}, c.sendBuf[:size]...)
+		c.sendBuf = c.sendBuf[size:]
+		c.inflightBytes += size
+		c.transmit(&outPacket{packetType: utpmsg.TypeData, seqNr: c.seqNr, payload: payload})
+		c.seqNr++
+		sent = true
+	}
+	if len(c.sendBuf) == 0 {
+		c.sendBuf = nil
+	}
+
+	if c.closing && !c.finSent && len(c.sendBuf) == 0 && len(c.inflight) < maxInflight {
+		c.transmit(&outPacket{packetType: utpmsg.TypeFin, seqNr: c.seqNr})
+		c.seqNr++
+		c.finSent = true
+	}
+
+	if sent {
+		c.broadcast()
+	}
+}
+
+// transmit sends a new packet and keeps it until acked
+func (c *Conn) transmit(p *outPacket) {
+	c.inflight = append(c.inflight, p)
+	c.resend(p)
+}
+
+// resend sends a packet from the inflight list
+func (c *Conn) resend(p *outPacket) {
+	now := time.Now()
+	p.sentAt = now
+	p.transmissions++
+	if c.rtoDeadline.IsZero() {
+		c.rtoDeadline = now.Add(c.cc.timeout())
+	}
+
+	c.socket.send(c.addr, utpmsg.Header{
+		Type:          p.packetType,
+		ConnID:        c.packetConnID(p.packetType),
+		Timestamp:     timestamp(now),
+		TimestampDiff: c.replyMicro,
+		WindowSize:    uint32(c.recvWindow()),
+		SeqNr:         p.seqNr,
+		AckNr:         c.ackNr,
+	}, p.payload)
+}
+
+// sendState sends a ack with the selective ack of the out of order packets
+func (c *Conn) sendState() {
+	c.socket.send(c.addr, utpmsg.Header{
+		Type:          utpmsg.TypeState,
+		ConnID:        c.sendID,
+		Timestamp:     timestamp(time.Now()),
+		TimestampDiff: c.replyMicro,
+		WindowSize:    uint32(c.recvWindow()),
+		SeqNr:         c.seqNr,
+		AckNr:         c.ackNr,
+		SelectiveAck:  c.selectiveAck(),
+	}, nil)
+}
+
+// sendSynAck answers the SYN of a incoming connection
+// It always has our initial sequence number, as it sets the ack of the remote
+func (c *Conn) sendSynAck() {
+	c.socket.send(c.addr, utpmsg.Header{
+		Type:          utpmsg.TypeState,
+		ConnID:        c.sendID,
+		Timestamp:     timestamp(time.Now()),
+		TimestampDiff: c.replyMicro,
+		WindowSize:    uint32(c.recvWindow()),
+		SeqNr:         c.initialSeqNr,
+		AckNr:         c.ackNr,
+	}, nil)
+}
+
+// sendReset tells the remote the connection is gone
+func (c *Conn) sendReset() {
+	c.socket.send(c.addr, utpmsg.Header{
+		Type:      utpmsg.TypeReset,
+		ConnID:    c.sendID,
+		Timestamp: timestamp(time.Now()),
+		SeqNr:     c.seqNr,
+		AckNr:     c.ackNr,
+	}, nil)
+}
+
+// packetConnID returns the connection id of a packet type
+// The SYN carries our id, the other packets the remote one
+func (c *Conn) packetConnID(packetType utpmsg.Type) uint16 {
+	if packetType == utpmsg.TypeSyn {
+		return c.recvID
+	}
+	return c.sendID
+}
+
+// selectiveAck builds the bitmask of the out of order packets
+func (c *Conn) selectiveAck() []byte {
+	if len(c.reorder) == 0 {
+		return nil
+	}
+
+	// The first bit is ack_nr + 2
+	furthest := 0
+	for seqNr := range c.reorder {
+		offset := int(seqNr - c.ackNr - 2)
+		if offset > furthest {
+			furthest = offset
+		}
+	}
+	length := (furthest/32 + 1) * 4
+	if length > maxSelectiveAck {
+		length = maxSelectiveAck
+	}
+
+	mask := make([]byte, length)
+	for seqNr := range c.reorder {
+		offset := int(seqNr - c.ackNr - 2)
+		if offset < length*8 {
+			mask[offset/8] |= 1 << (offset % 8)
+		}
+	}
+	return mask
+}
+
+// recvWindow returns the free space on the receive buffer
+func (c *Conn) recvWindow() int {
+	window := bufferSize - len(c.readBuf) - c.reorderBytes
+	if window < 0 {
+		return 0
+	}
+	return window
+}
+
+// tick resends the packets after a timeout
+func (c *Conn) tick(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == stateClosed || c.rtoDeadline.IsZero() || now.Before(c.rtoDeadline) {
+		return
+	}
+	if len(c.inflight) == 0 {
+		c.rtoDeadline = time.Time{}
+		return
+	}
+
+	c.timeouts++
+	if (c.state == stateSynSent && c.timeouts >= maxSynTimeouts) || c.timeouts >= maxTimeouts {
+		c.failLocked(errTimedOut)
+		return
+	}
+
+	// All the packets are taken as lost and sent again as the window allows
+	c.cc.onTimeout()
+	c.inflightBytes = 0
+	for _, p := range c.inflight {
+		if !p.acked {
+			p.needResend = true
+		}
+	}
+	c.rtoDeadline = time.Time{}
+	if c.state == stateSynSent {
+		c.inflight[0].needResend = false
+		c.resend(c.inflight[0])
+		return
+	}
+	c.flush()
+}
+
+// resetTimer restarts the retransmission timer after an ack
+func (c *Conn) resetTimer(now time.Time) {
+	if len(c.inflight) == 0 {
+		c.rtoDeadline = time.Time{}
+		return
+	}
+	c.rtoDeadline = now.Add(c.cc.timeout())
+}
+
+// finishIfDone removes a closed connection once our FIN is acked
+func (c *Conn) finishIfDone() {
+	if c.closing && c.finSent && len(c.inflight) == 0 {
+		c.state = stateClosed
+		c.socket.remove(c)
+		c.broadcast()
+	}
+}
+
+// fail closes the connection with a error
+func (c *Conn) fail(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failLocked(err)
+}
+
+// failLocked closes the connection with a error, with the lock held
+func (c *Conn) failLocked(err error) {
+	if c.state == stateClosed {
+		return
+	}
+	c.state = stateClosed
+	c.err = err
+	c.socket.remove(c)
+	c.broadcast()
+}
+
+// broadcast wakes all the waiters
+func (c *Conn) broadcast() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// wait releases the lock until the next state change or the deadline
+func (c *Conn) wait(deadline time.Time) error {
+	changed := c.changed
+	c.mu.Unlock()
+	defer c.mu.Lock()
+
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-changed:
+		return nil
+	case <-timeout:
+		return os.ErrDeadlineExceeded
+	}
+}
+
+// deadlinePassed returns if a deadline is set and passed
+func deadlinePassed(deadline time.Time) bool {
+	return !deadline.IsZero() && !time.Now().Before(deadline)
+}
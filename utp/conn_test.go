@@ -0,0 +1,183 @@
+package utp_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/jhelison/go-torrent/utp"
+)
+
+// lossyConn drops some of the sent packets
+type lossyConn struct {
+	net.PacketConn
+
+	mu        sync.Mutex
+	sent      int
+	dropEvery int
+}
+
+// WriteTo drops every dropEvery packet
+func (l *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	l.mu.Lock()
+	l.sent++
+	drop := l.dropEvery > 0 && l.sent%l.dropEvery == 0
+	l.mu.Unlock()
+
+	if drop {
+		return len(b), nil
+	}
+	return l.PacketConn.WriteTo(b, addr)
+}
+
+// newSocket starts a socket on loopback dropping some packets
+func newSocket(t *testing.T, dropEvery int) *utp.Socket {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	socket := utp.NewSocket(&lossyConn{PacketConn: conn, dropEvery: dropEvery})
+	t.Cleanup(func() {
+		socket.Close()
+	})
+	return socket
+}
+
+// TestTransfer tests the data on both directions until the remote closes
+func TestTransfer(t *testing.T) {
+	testCases := []struct {
+		name      string
+		length    int
+		dropEvery int
+	}{
+		{
+			name:   "small",
+			length: 100,
+		},
+		{
+			name:   "multiple packets",
+			length: 1 << 20,
+		},
+		{
+			name:      "lossy",
+			length:    256 << 10,
+			dropEvery: 10,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			server := newSocket(t, tc.dropEvery)
+			client := newSocket(t, tc.dropEvery)
+
+			data := make([]byte, tc.length)
+			_, err := rand.Read(data)
+			require.NoError(t, err)
+
+			// The server answers the request with the data and closes
+			errs := make(chan error, 1)
+			go func() {
+				conn, err := server.Accept()
+				if err != nil {
+					errs <- err
+					return
+				}
+				defer conn.Close()
+
+				request := make([]byte, 4)
+				_, err = io.ReadFull(conn, request)
+				if err != nil {
+					errs <- err
+					return
+				}
+				_, err = conn.Write(data)
+				errs <- err
+			}()
+
+			conn, err := client.DialTimeout(server.Addr().String(), time.Second)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.NoError(t, conn.SetDeadline(time.Now().Add(20*time.Second)))
+
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+
+			received, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			require.NoError(t, <-errs)
+			require.True(t, bytes.Equal(data, received))
+		})
+	}
+}
+
+// TestDeadline tests that the reads stop at the deadline
+func TestDeadline(t *testing.T) {
+	server := newSocket(t, 0)
+	client := newSocket(t, 0)
+
+	go func() {
+		conn, err := server.Accept()
+		if err == nil {
+			defer conn.Close()
+			time.Sleep(time.Second)
+		}
+	}()
+
+	conn, err := client.DialTimeout(server.Addr().String(), time.Second)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(100*time.Millisecond)))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	netErr, ok := err.(net.Error)
+	require.True(t, ok)
+	require.True(t, netErr.Timeout())
+}
+
+// TestDialTimeout tests dialing a address without a socket
+func TestDialTimeout(t *testing.T) {
+	client := newSocket(t, 0)
+
+	// A bound UDP socket that never answers
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silent.Close()
+
+	_, err = client.DialTimeout(silent.LocalAddr().String(), 200*time.Millisecond)
+	require.Error(t, err)
+}
+
+// TestPacketConn tests that the non uTP packets are sent to the packet connection
+func TestPacketConn(t *testing.T) {
+	socket := newSocket(t, 0)
+	packetConn := socket.PacketConn()
+	defer packetConn.Close()
+
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer sender.Close()
+
+	message := []byte("d1:ad2:id20:abcdefghij0123456789e1:q4:ping1:t2:aa1:y1:qe")
+	_, err = sender.WriteTo(message, socket.Addr())
+	require.NoError(t, err)
+
+	buf := make([]byte, 1500)
+	n, addr, err := packetConn.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, message, buf[:n])
+	require.Equal(t, sender.LocalAddr().String(), addr.String())
+
+	// Answers go out from the socket
+	_, err = packetConn.WriteTo([]byte("answer"), sender.LocalAddr())
+	require.NoError(t, err)
+	n, addr, err = sender.ReadFrom(buf)
+	require.NoError(t, err)
+	require.Equal(t, "answer", string(buf[:n]))
+	require.Equal(t, socket.Addr().String(), addr.String())
+}
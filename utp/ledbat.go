@@ -0,0 +1,164 @@
+package utp
+
+import (
+	"time"
+)
+
+const (
+	// targetDelay is the queuing delay LEDBAT aims for
+	targetDelay = 100000
+	// maxWindowGain is the max growth of the window per round trip
+	maxWindowGain = 3000
+	// minWindow is the smallest congestion window, a single packet
+	minWindow = maxPayload
+	// initialWindow is the congestion window of new connections
+	initialWindow = 2 * maxPayload
+	// maxWindow is the biggest congestion window
+	maxWindow = bufferSize
+	// minRTO is the smallest retransmission timeout
+	minRTO = 500 * time.Millisecond
+	// maxRTO is the biggest retransmission timeout
+	maxRTO = 60 * time.Second
+	// initialRTO is the retransmission timeout before any round trip sample
+	initialRTO = time.Second
+)
+
+// ledbat is the delay based congestion control of uTP
+// The window grows while the queuing delay is under the target and shrinks above it
+// More information can be found on https://datatracker.ietf.org/doc/html/rfc6817
+type ledbat struct {
+	window float64
+
+	// The base delay is the min delay over the last two minutes
+	// The delays are differences between the clocks, so they can wrap
+	hasDelay     bool
+	currentMin   uint32
+	previousMin  uint32
+	bucketStart  time.Time
+	smoothedRTT  time.Duration
+	rttVariation time.Duration
+	rto          time.Duration
+	// backoff doubles the timeout on every timeout in a row
+	backoff uint
+}
+
+// newLedbat returns the congestion control of a new connection
+func newLedbat() *ledbat {
+	return &ledbat{
+		window: initialWindow,
+		rto:    initialRTO,
+	}
+}
+
+// size returns the congestion window in bytes
+func (l *ledbat) size() int {
+	return int(l.window)
+}
+
+// onAck grows or shrinks the window from the delay of the acked packets
+// The delay is the one measured by the remote on our packets
+func (l *ledbat) onAck(ackedBytes int, delay uint32, now time.Time) {
+	if delay == 0 {
+		// The remote didn't measure any delay yet
+		return
+	}
+	base := l.baseDelay(delay, now)
+
+	ourDelay := float64(delay - base)
+	offTarget := (targetDelay - ourDelay) / targetDelay
+	windowFactor := float64(ackedBytes) / l.window
+	if windowFactor > 1 {
+		windowFactor = 1
+	}
+	l.window += maxWindowGain * offTarget * windowFactor
+	l.clamp()
+}
+
+// onLoss halves the window after a lost packet
+func (l *ledbat) onLoss() {
+	l.window /= 2
+	l.clamp()
+}
+
+// onTimeout resets the window to a single packet and backs off the timeout
+func (l *ledbat) onTimeout() {
+	l.window = minWindow
+	if l.timeout() < maxRTO {
+		l.backoff++
+	}
+}
+
+// onProgress stops the back off once the acks arrive again
+func (l *ledbat) onProgress() {
+	l.backoff = 0
+}
+
+// timeout returns the retransmission timeout with the back off
+func (l *ledbat) timeout() time.Duration {
+	timeout := l.rto << l.backoff
+	if timeout > maxRTO {
+		return maxRTO
+	}
+	return timeout
+}
+
+// onRTT updates the retransmission timeout from a round trip sample
+func (l *ledbat) onRTT(rtt time.Duration) {
+	if l.smoothedRTT == 0 {
+		l.smoothedRTT = rtt
+		l.rttVariation = rtt / 2
+	} else {
+		diff := l.smoothedRTT - rtt
+		if diff < 0 {
+			diff = -diff
+		}
+		l.rttVariation += (diff - l.rttVariation) / 4
+		l.smoothedRTT += (rtt - l.smoothedRTT) / 8
+	}
+
+	l.rto = l.smoothedRTT + 4*l.rttVariation
+	if l.rto < minRTO {
+		l.rto = minRTO
+	}
+	if l.rto > maxRTO {
+		l.rto = maxRTO
+	}
+}
+
+// baseDelay adds a delay sample and returns the base delay
+// The history is kept on one minute buckets
+func (l *ledbat) baseDelay(delay uint32, now time.Time) uint32 {
+	switch {
+	case !l.hasDelay:
+		l.hasDelay = true
+		l.currentMin = delay
+		l.previousMin = delay
+		l.bucketStart = now
+	case now.Sub(l.bucketStart) >= time.Minute:
+		l.previousMin = l.currentMin
+		l.currentMin = delay
+		l.bucketStart = now
+	case delayLess(delay, l.currentMin):
+		l.currentMin = delay
+	}
+
+	if delayLess(l.previousMin, l.currentMin) {
+		return l.previousMin
+	}
+	return l.currentMin
+}
+
+// clamp keeps the window between one packet and the buffer size
+func (l *ledbat) clamp() {
+	if l.window < minWindow {
+		l.window = minWindow
+	}
+	if l.window > maxWindow {
+		l.window = maxWindow
+	}
+}
+
+// delayLess compares two delays that can wrap
+func delayLess(a, b uint32) bool {
+	return int32(a-b) < 0
+}
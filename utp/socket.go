@@ -0,0 +1,417 @@
+package utp
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jhelison/go-torrent/logger"
+	utpmsg "github.com/jhelison/go-torrent/marshallers/utp"
+)
+
+var (
+	// Default logger
+	log = logger.GetLogger()
+)
+
+const (
+	// maxPacketSize is the biggest UDP packet we read
+	maxPacketSize = 65536
+	// acceptBacklog is the number of connections waiting to be accepted
+	acceptBacklog = 32
+	// fallbackBuffer is the number of non uTP packets waiting to be read
+	fallbackBuffer = 64
+	// tickInterval is the interval between the timeout checks
+	tickInterval = 50 * time.Millisecond
+)
+
+// connKey identifies a connection by the remote address and our connection id
+type connKey struct {
+	addr string
+	id   uint16
+}
+
+// Socket multiplexes the uTP connections over a UDP socket
+// It implements net.Listener for the incoming connections
+// More information can be found on https://www.bittorrent.org/beps/bep_0029.html
+type Socket struct {
+	conn net.PacketConn
+
+	mu       sync.Mutex
+	conns    map[connKey]*Conn
+	fallback *packetConn
+
+	accepts   chan *Conn
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Listen starts a uTP socket on a UDP address
+func Listen(address string) (*Socket, error) {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return NewSocket(conn), nil
+}
+
+// NewSocket starts a uTP socket over a packet connection
+func NewSocket(conn net.PacketConn) *Socket {
+	s := &Socket{
+		conn:    conn,
+		conns:   map[connKey]*Conn{},
+		accepts: make(chan *Conn, acceptBacklog),
+		closed:  make(chan struct{}),
+	}
+
+	go s.readLoop()
+	go s.tickLoop()
+	return s
+}
+
+// Addr returns the address the socket is bound to
+func (s *Socket) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Accept waits for the next incoming connection
+func (s *Socket) Accept() (net.Conn, error) {
+	select {
+	case c := <-s.accepts:
+		return c, nil
+	case <-s.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close resets all the connections and closes the socket
+func (s *Socket) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.closed)
+	})
+
+	for _, c := range s.connections() {
+		c.fail(net.ErrClosed)
+	}
+	return s.conn.Close()
+}
+
+// DialTimeout opens a connection to a address
+func (s *Socket) DialTimeout(address string, timeout time.Duration) (net.Conn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	// The ids are random, the remote uses our id + 1 on its packets
+	s.mu.Lock()
+	var id uint16
+	for {
+		id, err = randomUint16()
+		if err != nil {
+			s.mu.Unlock()
+			return nil, err
+		}
+		_, used := s.conns[connKey{addr: addr.String(), id: id}]
+		if !used {
+			break
+		}
+	}
+	c := newConn(s, addr, id, id+1)
+	s.conns[connKey{addr: addr.String(), id: id}] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connect()
+
+	deadline := time.Now().Add(timeout)
+	for c.state == stateSynSent {
+		err := c.wait(deadline)
+		if err != nil {
+			c.failLocked(err)
+			return nil, err
+		}
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c, nil
+}
+
+// PacketConn returns a connection with the packets that aren't from uTP
+// This allows other protocols, as the DHT, to share the socket
+func (s *Socket) PacketConn() net.PacketConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.fallback == nil {
+		s.fallback = &packetConn{
+			socket:  s,
+			packets: make(chan packet, fallbackBuffer),
+			closed:  make(chan struct{}),
+		}
+	}
+	return s.fallback
+}
+
+// readLoop reads the packets and routes them to the connections
+func (s *Socket) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			select {
+			case <-s.closed:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Debug().Msgf("failed to read uTP packet, err: %s", err)
+			continue
+		}
+
+		header, payload, err := utpmsg.Unmarshal(buf[:n])
+		if err != nil {
+			s.handleFallback(buf[:n], addr)
+			continue
+		}
+		s.handlePacket(header, payload, addr)
+	}
+}
+
+// handlePacket routes a packet to its connection
+// The SYN packets start new connections
+func (s *Socket) handlePacket(h utpmsg.Header, payload []byte, addr net.Addr) {
+	id := h.ConnID
+	if h.Type == utpmsg.TypeSyn {
+		id++
+	}
+
+	c := s.lookup(addr, id)
+	if c == nil && h.Type == utpmsg.TypeReset {
+		// The reset can carry any of the two ids of the connection
+		c = s.lookup(addr, id+1)
+		if c == nil {
+			c = s.lookup(addr, id-1)
+		}
+	}
+	if c != nil {
+		c.handlePacket(h, payload)
+		return
+	}
+
+	switch h.Type {
+	case utpmsg.TypeSyn:
+		s.accept(h, addr)
+	case utpmsg.TypeData, utpmsg.TypeFin:
+		s.send(addr, utpmsg.Header{
+			Type:      utpmsg.TypeReset,
+			ConnID:    h.ConnID,
+			Timestamp: timestamp(time.Now()),
+			AckNr:     h.SeqNr,
+		}, nil)
+	}
+}
+
+// accept starts a incoming connection from a SYN packet
+func (s *Socket) accept(h utpmsg.Header, addr net.Addr) {
+	seqNr, err := randomUint16()
+	if err != nil {
+		log.Warn().Msgf("failed to accept uTP connection, err: %s", err)
+		return
+	}
+
+	c := newConn(s, addr, h.ConnID+1, h.ConnID)
+	c.state = stateConnected
+	c.incoming = true
+	c.seqNr = seqNr
+	c.initialSeqNr = seqNr
+	c.ackNr = h.SeqNr
+
+	s.mu.Lock()
+	select {
+	case <-s.closed:
+		s.mu.Unlock()
+		return
+	default:
+	}
+	s.conns[connKey{addr: addr.String(), id: c.recvID}] = c
+	s.mu.Unlock()
+
+	c.mu.Lock()
+	c.updateTimestamps(h)
+	c.sendSynAck()
+	c.mu.Unlock()
+
+	select {
+	case s.accepts <- c:
+	default:
+		log.Debug().Msgf("dropping uTP connection from %s, the accept backlog is full", addr)
+		c.mu.Lock()
+		c.sendReset()
+		c.failLocked(errors.New("accept backlog is full"))
+		c.mu.Unlock()
+	}
+}
+
+// handleFallback sends a non uTP packet to the packet connection
+// The packets are dropped if nobody is reading them
+func (s *Socket) handleFallback(data []byte, addr net.Addr) {
+	s.mu.Lock()
+	fallback := s.fallback
+	s.mu.Unlock()
+	if fallback == nil {
+		return
+	}
+
+	select {
+	case fallback.packets <- packet{data: append([]byte(nil), data...), addr: addr}:
+	case <-fallback.closed:
+	default:
+		log.Trace().Msgf("dropping packet from %s, the fallback buffer is full", addr)
+	}
+}
+
+// tickLoop checks the timeouts of the connections
+func (s *Socket) tickLoop() {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.closed:
+			return
+		case now := <-ticker.C:
+			for _, c := range s.connections() {
+				c.tick(now)
+			}
+		}
+	}
+}
+
+// lookup returns the connection with a address and our id
+func (s *Socket) lookup(addr net.Addr, id uint16) *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[connKey{addr: addr.String(), id: id}]
+}
+
+// connections returns a copy of the open connections
+func (s *Socket) connections() []*Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	conns := make([]*Conn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// remove stops routing the packets to a connection
+func (s *Socket) remove(c *Conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := connKey{addr: c.addr.String(), id: c.recvID}
+	if s.conns[key] == c {
+		delete(s.conns, key)
+	}
+}
+
+// send writes a packet to a address, logging the errors
+func (s *Socket) send(addr net.Addr, h utpmsg.Header, payload []byte) {
+	_, err := s.conn.WriteTo(h.Marshal(payload), addr)
+	if err != nil {
+		log.Debug().Msgf("failed to send uTP packet to %s, err: %s", addr, err)
+	}
+}
+
+// packet is a packet received on the socket
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+// packetConn is the connection with the packets that aren't from uTP
+// Closing it doesn't close the socket
+type packetConn struct {
+	socket *Socket
+
+	packets   chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// ReadFrom reads the next non uTP packet
+func (p *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case pkt := <-p.packets:
+		return copy(b, pkt.data), pkt.addr, nil
+	case <-p.closed:
+		return 0, nil, net.ErrClosed
+	case <-p.socket.closed:
+		return 0, nil, net.ErrClosed
+	}
+}
+
+// WriteTo writes a packet from the socket
+func (p *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-p.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	return p.socket.conn.WriteTo(b, addr)
+}
+
+// Close stops receiving the packets
+func (p *packetConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+	})
+	return nil
+}
+
+// LocalAddr returns the address of the socket
+func (p *packetConn) LocalAddr() net.Addr {
+	return p.socket.Addr()
+}
+
+// SetDeadline isn't supported on the shared socket
+func (p *packetConn) SetDeadline(t time.Time) error {
+	return errDeadlineUnsupported
+}
+
+// SetReadDeadline isn't supported on the shared socket
+func (p *packetConn) SetReadDeadline(t time.Time) error {
+	return errDeadlineUnsupported
+}
+
+// SetWriteDeadline isn't supported on the shared socket
+func (p *packetConn) SetWriteDeadline(t time.Time) error {
+	return errDeadlineUnsupported
+}
+
+// errDeadlineUnsupported is returned by the deadlines of the shared socket
+var errDeadlineUnsupported = errors.New("deadlines aren't supported on the shared socket")
+
+// randomUint16 returns a random number for the ids and sequence numbers
+func randomUint16() (uint16, error) {
+	var buf [2]byte
+	_, err := rand.Read(buf[:])
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buf[:]), nil
+}
+
+// timestamp returns the microseconds timestamp of the packets
+func timestamp(t time.Time) uint32 {
+	return uint32(t.UnixMicro())
+}